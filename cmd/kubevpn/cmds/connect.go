@@ -21,6 +21,7 @@ import (
 func CmdConnect(f cmdutil.Factory) *cobra.Command {
 	var connect = &handler.ConnectOptions{}
 	var sshConf = &util.SshConfig{}
+	var podOptions = &podOptionsFlags{}
 	cmd := &cobra.Command{
 		Use:   "connect",
 		Short: i18n.T("Connect to kubernetes cluster network"),
@@ -38,6 +39,17 @@ func CmdConnect(f cmdutil.Factory) *cobra.Command {
 		└──────┘     └──────┘     └──────┘     └──────┘                 └────────────┘
 		kubevpn connect --ssh-alias <alias>
 
+		# Use another inner tunnel address pool if default 223.254.0.100/16 conflicts with your network or routes of other vpn,
+		# only works while creating traffic manager, ip is tun ip of traffic manager
+		kubevpn connect --tunnel-cidr 198.18.0.100/16
+
+		# Schedule traffic manager to dedicated nodes and disable istio sidecar of it and pods which sidecars are injected into,
+		# only works while creating traffic manager
+		kubevpn connect --node-selector node-role=infra --toleration dedicated=infra:NoSchedule --pod-annotation sidecar.istio.io/inject=false
+
+		# Connect to namespace test by cluster-wide traffic manager installed in namespace kubevpn
+		kubevpn connect -n test --manager-namespace kubevpn
+
 `)),
 		PreRunE: func(cmd *cobra.Command, args []string) (err error) {
 			if !util.IsAdmin() {
@@ -50,6 +62,9 @@ func CmdConnect(f cmdutil.Factory) *cobra.Command {
 			return handler.SshJump(sshConf, cmd.Flags())
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := podOptions.apply(cmd); err != nil {
+				return err
+			}
 			if err := connect.InitClient(f); err != nil {
 				return err
 			}
@@ -65,7 +80,14 @@ func CmdConnect(f cmdutil.Factory) *cobra.Command {
 	cmd.Flags().BoolVar(&config.Debug, "debug", false, "enable debug mode or not, true or false")
 	cmd.Flags().StringVar(&config.Image, "image", config.Image, "use this image to startup container")
 	cmd.Flags().StringArrayVar(&connect.ExtraCIDR, "extra-cidr", []string{}, "Extra cidr string, eg: --extra-cidr 192.168.0.159/24 --extra-cidr 192.168.1.160/32")
+	cmd.Flags().StringVar(&connect.TunnelCIDR, "tunnel-cidr", "", "Inner tunnel address pool of traffic manager, only works while creating traffic manager, later clients use the same one, ip is tun ip of traffic manager, should not overlap with cluster cidrs, local networks and routes, default is "+config.DefaultTunnelCIDR()+", eg: --tunnel-cidr 198.18.0.100/16")
+	addManagerNamespaceFlag(cmd, connect)
+	addPodOptionsFlags(cmd, podOptions)
 
 	addSshFlag(cmd, sshConf)
 	return cmd
 }
+
+func addManagerNamespaceFlag(cmd *cobra.Command, connect *handler.ConnectOptions) {
+	cmd.Flags().StringVar(&connect.ManagerNamespace, "manager-namespace", "", "Namespace of cluster-wide traffic manager installed by kubevpn install --cluster-wide, it serves workloads in namespace of -n, default is namespace of -n")
+}
@@ -1,6 +1,9 @@
 package cmds
 
 import (
+	"fmt"
+	"os"
+
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
@@ -10,21 +13,43 @@ import (
 	"github.com/wencaiwulue/kubevpn/pkg/util"
 )
 
-func CmdControlPlane(_ cmdutil.Factory) *cobra.Command {
+func CmdControlPlane(f cmdutil.Factory) *cobra.Command {
 	var (
 		watchDirectoryFilename string
 		port                   uint = 9002
+		accessLogPort          uint = config.PortAccessLog
+		source                      = "file"
 	)
 	cmd := &cobra.Command{
 		Use:   "control-plane",
 		Short: "Control-plane is a envoy xds server",
 		Long:  `Control-plane is a envoy xds server, distribute envoy route configuration`,
-		Run: func(cmd *cobra.Command, args []string) {
+		RunE: func(cmd *cobra.Command, args []string) error {
 			util.InitLogger(config.Debug)
-			controlplane.Main(watchDirectoryFilename, port, log.StandardLogger())
+			switch source {
+			case "file":
+				controlplane.Main(watchDirectoryFilename, port, accessLogPort, log.StandardLogger())
+			case "configmap":
+				clientset, err := f.KubernetesClientSet()
+				if err != nil {
+					return err
+				}
+				namespace := os.Getenv(config.EnvPodNamespace)
+				if namespace == "" {
+					if namespace, _, err = f.ToRawKubeConfigLoader().Namespace(); err != nil {
+						return err
+					}
+				}
+				controlplane.MainConfigMap(clientset, namespace, port, accessLogPort, log.StandardLogger())
+			default:
+				return fmt.Errorf("unsupported source: %s, only support file and configmap", source)
+			}
+			return nil
 		},
 	}
 	cmd.Flags().StringVarP(&watchDirectoryFilename, "watchDirectoryFilename", "w", "/etc/envoy/envoy-config.yaml", "full path to directory to watch for files")
+	cmd.Flags().StringVar(&source, "source", source, "where to read envoy config, file: watch file by fsnotify, configmap: watch configmap kubevpn-traffic-manager by informer")
+	cmd.Flags().UintVar(&accessLogPort, "access-log-port", accessLogPort, "port of http server which stream envoy access log")
 	cmd.Flags().BoolVar(&config.Debug, "debug", false, "true/false")
 	return cmd
 }
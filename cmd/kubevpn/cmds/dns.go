@@ -0,0 +1,134 @@
+package cmds
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	miekgdns "github.com/miekg/dns"
+	"github.com/spf13/cobra"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/util/i18n"
+	"k8s.io/kubectl/pkg/util/templates"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/dns"
+	"github.com/wencaiwulue/kubevpn/pkg/handler"
+)
+
+func CmdDNS(f cmdutil.Factory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "dns",
+		Short: i18n.T("Debug domain name resolve of kubernetes cluster"),
+		Long:  templates.LongDesc(i18n.T(`Debug domain name resolve of kubernetes cluster, needs to connect to cluster first`)),
+	}
+	cmd.AddCommand(cmdDNSQuery(f), cmdDNSLog())
+	return cmd
+}
+
+func cmdDNSQuery(f cmdutil.Factory) *cobra.Command {
+	var connect = &handler.ConnectOptions{}
+	var qtype string
+	cmd := &cobra.Command{
+		Use:   "query <name>",
+		Short: i18n.T("Resolve name with every candidate name and every cluster dns server"),
+		Long: templates.LongDesc(i18n.T(`
+		Resolve name like kubevpn dns server does, show each candidate name after search list expansion,
+		the server used, the latency and the answer. also show the result of operating system resolver`)),
+		Example: templates.Examples(i18n.T(`
+		# Resolve short domain
+		kubevpn dns query productpage
+
+		# Resolve domain with namespace
+		kubevpn dns query productpage.default`)),
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			t, ok := miekgdns.StringToType[strings.ToUpper(qtype)]
+			if !ok {
+				return fmt.Errorf("unsupported query type: %s", qtype)
+			}
+			if err := connect.InitClient(f); err != nil {
+				return err
+			}
+			clientConfig, err := connect.GetDNSConfig()
+			if err != nil {
+				return err
+			}
+			ctx, cancel := context.WithTimeout(cmd.Context(), time.Second*10)
+			defer cancel()
+
+			w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+			_, _ = fmt.Fprintf(w, "CANDIDATE\tSERVER\tLATENCY\tRESULT\tANSWER\n")
+			for _, l := range dns.Query(ctx, clientConfig, args[0], t) {
+				if l.Skipped {
+					_, _ = fmt.Fprintf(w, "%s\t-\t-\tskipped\t\n", l.Candidate)
+					continue
+				}
+				result := l.Rcode
+				if l.Error != "" {
+					result = l.Error
+				}
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%s\n", l.Candidate, l.Server, l.Latency.Round(time.Microsecond), result, strings.Join(l.Answer, ", "))
+			}
+
+			// operating system resolver, means resolv.conf, /etc/resolver or dns setting of network interface
+			start := time.Now()
+			addrs, err := net.DefaultResolver.LookupHost(ctx, args[0])
+			result := "NOERROR"
+			if err != nil {
+				result = err.Error()
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%v\t%s\t%s\n", args[0], "system", time.Since(start).Round(time.Microsecond), result, strings.Join(addrs, ", "))
+			return w.Flush()
+		},
+	}
+	cmd.Flags().StringVarP(&qtype, "type", "t", "A", "Query type, eg: A, AAAA, SRV")
+	return cmd
+}
+
+func cmdDNSLog() *cobra.Command {
+	var address string
+	cmd := &cobra.Command{
+		Use:   "log",
+		Short: i18n.T("Stream query log of dns server which started by kubevpn connect"),
+		Long: templates.LongDesc(i18n.T(`
+		Stream query log of dns server which started by kubevpn connect/proxy.
+		only works on the platform which kubevpn starts a local dns server, like macOS`)),
+		Example: templates.Examples(i18n.T(`
+		# Stream query log
+		kubevpn dns log`)),
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			req, err := http.NewRequestWithContext(cmd.Context(), "GET", fmt.Sprintf("http://%s%s", address, config.APIDNSQueryLog), nil)
+			if err != nil {
+				return err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return fmt.Errorf("can not connect to kubevpn, is kubevpn connect running? err: %v", err)
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("http status is %d", resp.StatusCode)
+			}
+			scanner := bufio.NewScanner(resp.Body)
+			for scanner.Scan() {
+				var l dns.QueryLog
+				if err = json.Unmarshal(scanner.Bytes(), &l); err != nil {
+					continue
+				}
+				fmt.Println(l.String())
+			}
+			return scanner.Err()
+		},
+	}
+	cmd.Flags().StringVar(&address, "address", "localhost:6060", "Address of kubevpn connect debug server")
+	return cmd
+}
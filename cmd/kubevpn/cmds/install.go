@@ -0,0 +1,135 @@
+package cmds
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/printers"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/util/i18n"
+	"k8s.io/kubectl/pkg/util/templates"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/handler"
+	"github.com/wencaiwulue/kubevpn/pkg/util"
+)
+
+func CmdInstall(f cmdutil.Factory) *cobra.Command {
+	var connect = &handler.ConnectOptions{}
+	var sshConf = &util.SshConfig{}
+	var dryRun bool
+	var output, tunnelCIDR string
+	var replicas int32
+	var clusterWide bool
+	var podOptions = &podOptionsFlags{}
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: i18n.T("Install traffic manager by administrator"),
+		Long: templates.LongDesc(i18n.T(`Install traffic manager by administrator, developers connect to it without permission to create rbac,
+		connect reuses installed traffic manager and never deletes it, uninstall it by kubevpn uninstall`)),
+		Example: templates.Examples(i18n.T(`
+		# Install traffic manager into default namespace
+		kubevpn install
+
+		# Render manifests of traffic manager in namespace test, apply them by GitOps tools
+		kubevpn install -n test --dry-run -o yaml
+
+		# Install traffic manager with private image, schedule it to specific nodes
+		kubevpn install --image registry.example.com/kubevpn:latest --image-pull-secret regcred \
+			--node-selector kubernetes.io/os=linux --toleration dedicated=infra:NoSchedule \
+			--requests cpu=500m,memory=512Mi --limits cpu=1,memory=1Gi
+
+		# Install traffic manager with scheduling and resource options in file, same as flags
+		kubevpn install --pod-options pod-options.yaml
+
+		# Install highly available traffic manager, rolling update of it does not drop sessions
+		kubevpn install --replicas 3
+
+		# Install cluster-wide traffic manager in dedicated namespace kubevpn, it serves workloads in all namespaces,
+		# developers connect to it by kubevpn connect -n <namespace> --manager-namespace kubevpn
+		kubevpn install -n kubevpn --cluster-wide
+`)),
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return handler.SshJump(sshConf, cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			namespace, _, err := f.ToRawKubeConfigLoader().Namespace()
+			if err != nil {
+				return err
+			}
+			if err = podOptions.apply(cmd); err != nil {
+				return err
+			}
+			if replicas < 1 {
+				return fmt.Errorf("replicas must be greater than 0, but is %d", replicas)
+			}
+			o := handler.NewTrafficManagerOptions(namespace)
+			o.Replicas = replicas
+			o.ClusterWide = clusterWide
+			if tunnelCIDR != "" {
+				if err = config.SetTunnelCIDR(tunnelCIDR); err != nil {
+					return err
+				}
+				o.TunnelCIDR = config.TunnelCIDR()
+			}
+			if dryRun {
+				o.Installed = true
+				objects, err := handler.GenTrafficManagerObjects(o)
+				if err != nil {
+					return err
+				}
+				for k, v := range objects.Namespace.Labels {
+					log.Infof("namespace is not rendered, label it by: kubectl label namespace %s %s=%s --overwrite", namespace, k, v)
+				}
+				return printObjects(os.Stdout, objects.RenderedObjects(), output)
+			}
+			if err = connect.InitClient(f); err != nil {
+				return err
+			}
+			if err = connect.Install(cmd.Context(), o); err != nil {
+				return err
+			}
+			log.Infof("traffic manager is installed in namespace %s", namespace)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only print resources of traffic manager, without creating them, namespace and private keys are not printed, webhook generates keys on startup")
+	cmd.Flags().StringVarP(&output, "output", "o", "yaml", "Output format of --dry-run, yaml or json")
+	cmd.Flags().StringVar(&config.Image, "image", config.Image, "use this image to startup container")
+	cmd.Flags().StringVar(&tunnelCIDR, "tunnel-cidr", "", "Inner tunnel address pool of traffic manager, ip is tun ip of traffic manager, default is "+config.DefaultTunnelCIDR()+", eg: --tunnel-cidr 198.18.0.100/16")
+	cmd.Flags().Int32Var(&replicas, "replicas", 1, "Replicas of traffic manager, replicas forward packets to peers registered with each other, only installed traffic manager can have multiple replicas, kubevpn connect creates it with one replica")
+	cmd.Flags().BoolVar(&clusterWide, "cluster-wide", false, "Serve workloads in all namespaces except kube-system and namespaces with their own traffic manager, install it in a dedicated namespace")
+	addPodOptionsFlags(cmd, podOptions)
+
+	addSshFlag(cmd, sshConf)
+	return cmd
+}
+
+// printObjects print resources as yaml documents or json list, both can be applied by kubectl apply -f
+func printObjects(w io.Writer, objects []runtime.Object, output string) error {
+	switch output {
+	case "yaml":
+		printer := &printers.YAMLPrinter{}
+		for _, object := range objects {
+			if err := printer.PrintObj(object, w); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		list := &v1.List{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "List"}}
+		for _, object := range objects {
+			list.Items = append(list.Items, runtime.RawExtension{Object: object})
+		}
+		return (&printers.JSONPrinter{}).PrintObj(list, w)
+	default:
+		return fmt.Errorf("unsupported output format %s, only support yaml and json", output)
+	}
+}
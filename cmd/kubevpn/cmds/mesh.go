@@ -0,0 +1,128 @@
+package cmds
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/util/duration"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/util/i18n"
+	"k8s.io/kubectl/pkg/util/templates"
+
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
+	"github.com/wencaiwulue/kubevpn/pkg/handler"
+	"github.com/wencaiwulue/kubevpn/pkg/util"
+)
+
+func CmdMesh(f cmdutil.Factory) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "mesh",
+		Short: i18n.T("Manage mesh rules of proxy workloads"),
+		Long:  templates.LongDesc(i18n.T(`Manage mesh rules of proxy workloads, which added by kubevpn proxy with headers, path prefix, query, weight or mirror`)),
+	}
+	cmd.AddCommand(cmdMeshRules(f))
+	return cmd
+}
+
+func cmdMeshRules(f cmdutil.Factory) *cobra.Command {
+	var connect = &handler.ConnectOptions{}
+	var sshConf = &util.SshConfig{}
+	cmd := &cobra.Command{
+		Use:   "rules",
+		Short: i18n.T("List all mesh rules per workload"),
+		Long:  templates.LongDesc(i18n.T(`List all mesh rules per workload with owner, local tun ip, matchers, creation time and expire time`)),
+		Example: templates.Examples(i18n.T(`
+		# List mesh rules of default namespace
+		kubevpn mesh rules
+
+		# List mesh rules of another namespace test
+		kubevpn mesh rules -n test
+
+		# List mesh rules of all namespaces served by cluster-wide traffic manager in namespace kubevpn
+		kubevpn mesh rules --manager-namespace kubevpn`)),
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return handler.SshJump(sshConf, cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := connect.InitClient(f); err != nil {
+				return err
+			}
+			virtuals, err := connect.GetVirtuals(cmd.Context())
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+			printRules(w, virtuals)
+			return w.Flush()
+		},
+	}
+	addManagerNamespaceFlag(cmd, connect)
+	addSshFlag(cmd, sshConf)
+	return cmd
+}
+
+// printRules print mesh rules as table, rules of same workload are printed in route order,
+// gRPC method and server names are shown in PATH PREFIX column with grpc: and sni: prefix
+func printRules(w io.Writer, virtuals []*controlplane.Virtual) {
+	_, _ = fmt.Fprintf(w, "NAMESPACE\tWORKLOAD\tOWNER\tLOCAL TUN IP\tHEADERS\tPATH PREFIX\tQUERY\tWEIGHT\tMODE\tAGE\tEXPIRES\n")
+	for _, virtual := range virtuals {
+		for _, rule := range controlplane.SortRules(virtual.Rules) {
+			weight := rule.Weight
+			if weight == 0 {
+				weight = 100
+			}
+			path := rule.PathPrefix
+			if rule.GrpcMethod != "" {
+				path = "grpc:" + rule.GrpcMethod
+			}
+			if len(rule.SNI) != 0 {
+				path = "sni:" + strings.Join(rule.SNI, ",")
+			}
+			mode := "proxy"
+			if rule.Mirror {
+				mode = "mirror"
+			}
+			if rule.Fault != nil {
+				mode = "fault:" + rule.Fault.String()
+			}
+			if rule.TLSSecret != "" {
+				mode = mode + "+tls"
+			}
+			age := "<unknown>"
+			if rule.CreationTimestamp != nil {
+				age = duration.HumanDuration(time.Since(rule.CreationTimestamp.Time))
+			}
+			expires := "<never>"
+			if rule.Expire != nil {
+				expires = rule.Expire.Format(time.RFC3339)
+			}
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d%%\t%s\t%s\t%s\n",
+				virtual.Namespace, handler.UidToWorkload(virtual.Uid), orNone(rule.Owner.String()), rule.LocalTunIP, formatMatchers(rule.Headers),
+				orNone(path), formatMatchers(rule.QueryParams), weight, mode, age, expires)
+		}
+	}
+}
+
+// formatMatchers k1=v1,k2=v2 sorted by key
+func formatMatchers(m map[string]string) string {
+	var result []string
+	for k, v := range m {
+		result = append(result, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(result)
+	return orNone(strings.Join(result, ","))
+}
+
+func orNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
@@ -0,0 +1,100 @@
+package cmds
+
+import (
+	"os"
+
+	"github.com/spf13/cobra"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/kubectl/pkg/generate/versioned"
+	"sigs.k8s.io/yaml"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/util"
+)
+
+// podOptionsFlags scheduling and resource options of traffic manager and sidecars, from file and flags
+type podOptionsFlags struct {
+	file                      string
+	requests, limits          string
+	sidecarRequests           string
+	sidecarLimits             string
+	nodeSelector              map[string]string
+	tolerations               []string
+	priorityClassName         string
+	imagePullSecrets          []string
+	podLabels, podAnnotations map[string]string
+}
+
+func addPodOptionsFlags(cmd *cobra.Command, f *podOptionsFlags) {
+	cmd.Flags().StringVar(&f.file, "pod-options", "", "Yaml file of scheduling and resource options of traffic manager and sidecars, fields: resources, sidecarResources, nodeSelector, tolerations, priorityClassName, imagePullSecrets, labels and annotations, flags override it")
+	cmd.Flags().StringVar(&f.requests, "requests", "", "Resource requests of traffic manager containers, eg: --requests cpu=500m,memory=512Mi")
+	cmd.Flags().StringVar(&f.limits, "limits", "", "Resource limits of traffic manager containers, eg: --limits cpu=1,memory=1Gi")
+	cmd.Flags().StringVar(&f.sidecarRequests, "sidecar-requests", "", "Resource requests of vpn and envoy-proxy sidecars, eg: --sidecar-requests cpu=64m,memory=64Mi")
+	cmd.Flags().StringVar(&f.sidecarLimits, "sidecar-limits", "", "Resource limits of vpn and envoy-proxy sidecars, eg: --sidecar-limits cpu=128m,memory=128Mi")
+	cmd.Flags().StringToStringVar(&f.nodeSelector, "node-selector", nil, "Node selector of traffic manager, eg: --node-selector kubernetes.io/os=linux")
+	cmd.Flags().StringArrayVar(&f.tolerations, "toleration", nil, "Toleration of traffic manager, format is key[=value][:effect], eg: --toleration dedicated=infra:NoSchedule")
+	cmd.Flags().StringVar(&f.priorityClassName, "priority-class", "", "Priority class of traffic manager, pods which sidecars are injected into keep their own priority class, default is system-cluster-critical, empty means no priority class")
+	cmd.Flags().StringArrayVar(&f.imagePullSecrets, "image-pull-secret", nil, "Image pull secret of traffic manager, also added to pods in the same namespace which sidecars are injected into, eg: --image-pull-secret regcred")
+	cmd.Flags().StringToStringVar(&f.podLabels, "pod-label", nil, "Labels of traffic manager pod, also added to pods which sidecars are injected into, eg: --pod-label team=infra")
+	cmd.Flags().StringToStringVar(&f.podAnnotations, "pod-annotation", nil, "Annotations of traffic manager pod, also added to pods which sidecars are injected into, eg: --pod-annotation sidecar.istio.io/inject=false")
+}
+
+// apply set config.Pod by file and flags, only works while creating traffic manager, webhook of existing one
+// injects sidecars with options it was created with
+func (f *podOptionsFlags) apply(cmd *cobra.Command) error {
+	o := config.Pod
+	if f.file != "" {
+		content, err := os.ReadFile(f.file)
+		if err != nil {
+			return err
+		}
+		o = &config.PodOptions{}
+		if err = yaml.UnmarshalStrict(content, o); err != nil {
+			return err
+		}
+	}
+	if f.requests != "" || f.limits != "" {
+		resources, err := versioned.HandleResourceRequirementsV1(map[string]string{"requests": f.requests, "limits": f.limits})
+		if err != nil {
+			return err
+		}
+		o.Resources = &resources
+	}
+	if f.sidecarRequests != "" || f.sidecarLimits != "" {
+		resources, err := versioned.HandleResourceRequirementsV1(map[string]string{"requests": f.sidecarRequests, "limits": f.sidecarLimits})
+		if err != nil {
+			return err
+		}
+		o.SidecarResources = &resources
+	}
+	if len(f.nodeSelector) != 0 {
+		o.NodeSelector = f.nodeSelector
+	}
+	if len(f.tolerations) != 0 {
+		o.Tolerations = nil
+		for _, s := range f.tolerations {
+			toleration, err := util.ParseToleration(s)
+			if err != nil {
+				return err
+			}
+			o.Tolerations = append(o.Tolerations, toleration)
+		}
+	}
+	if cmd.Flags().Changed("priority-class") {
+		o.PriorityClassName = &f.priorityClassName
+	}
+	if len(f.imagePullSecrets) != 0 {
+		o.ImagePullSecrets = nil
+		for _, name := range f.imagePullSecrets {
+			o.ImagePullSecrets = append(o.ImagePullSecrets, v1.LocalObjectReference{Name: name})
+		}
+	}
+	if len(f.podLabels) != 0 {
+		o.Labels = f.podLabels
+	}
+	if len(f.podAnnotations) != 0 {
+		o.Annotations = f.podAnnotations
+	}
+	config.Pod = o
+	return nil
+}
@@ -23,6 +23,8 @@ import (
 func CmdProxy(f cmdutil.Factory) *cobra.Command {
 	var connect = handler.ConnectOptions{}
 	var sshConf = &util.SshConfig{}
+	var podOptions = &podOptionsFlags{}
+	var headerProxy string
 	cmd := &cobra.Command{
 		Use:   "proxy",
 		Short: i18n.T("Connect to kubernetes cluster network and proxy kubernetes workloads inbound traffic into local PC"),
@@ -43,6 +45,48 @@ func CmdProxy(f cmdutil.Factory) *cobra.Command {
 		# Reverse proxy with mesh, traffic with header a=1, will hit local PC, otherwise no effect
 		kubevpn proxy service/productpage --headers a=1
 
+		# Reverse proxy with mesh, header value also support prefix:<prefix>, regex:<regex> and present
+		kubevpn proxy service/productpage --headers user=prefix:test- --headers debug=present
+
+		# Reverse proxy with mesh, only traffic with path prefix /api/v2 and query parameter version=2 will hit local PC
+		kubevpn proxy service/productpage --path-prefix /api/v2 --query version=2
+
+		# Reverse proxy with mesh, only gRPC method /pkg.Service/Method with metadata user=test will hit local PC, other methods of service still go to origin workloads
+		# without method name, like --grpc-method pkg.Service, all methods of this service will hit local PC
+		kubevpn proxy service/productpage --grpc-method pkg.Service/Method --headers user=test
+
+		# Reverse proxy with mesh, raw tls traffic with server name productpage.example.com will hit local PC, tls is not terminated
+		kubevpn proxy service/productpage --sni productpage.example.com
+
+		# Reverse proxy with mesh for https workloads, envoy-proxy terminate tls with workloads secret, route by headers, and re-encrypt to local PC or origin workloads
+		kubevpn proxy service/productpage --tls-secret productpage-tls --headers a=1
+
+		# Inject fault into traffic with header a=1, 10% of requests abort with 503 and 5% of requests delay 2s, traffic still go to origin workloads
+		kubevpn proxy deployment/productpage --headers a=1 --fault abort=503:10% --fault delay=2s:5%
+
+		# Reverse proxy with mesh, only 5% of traffic will hit local PC, can be combined with headers
+		kubevpn proxy service/productpage --weight 5
+		kubevpn proxy service/productpage --headers a=1 --weight 50
+
+		# Mirror traffic to local PC, origin workloads still serve the request, response of local PC will be ignored
+		# can be combined with headers, and weight means percentage of matched traffic to mirror
+		kubevpn proxy service/productpage --mirror
+		kubevpn proxy service/productpage --mirror --headers a=1 --weight 10
+
+		# Show access log of envoy-proxy sidecar, each request's method, path, matched route, status and latency
+		kubevpn proxy service/productpage --headers a=1 --access-log
+
+		# Traffic manager will unpatch workloads automatically after 8 hours, even if you forget to close kubevpn
+		kubevpn proxy service/productpage --headers a=1 --ttl 8h
+
+		# Start a local http proxy, it injects headers to requests which send to cluster, so requests from your browser can hit local PC
+		# and also match headers in W3C baggage header, services which propagate baggage will pass it to downstream
+		kubevpn proxy service/productpage --headers a=1 --header-proxy localhost:8080 --baggage
+		export HTTP_PROXY=http://localhost:8080
+
+		# Reverse proxy without mesh is exclusive, take over workloads which already reversed by others
+		kubevpn proxy service/productpage --force
+
 		# Connect to api-server behind of bastion host or ssh jump host and proxy kubernetes resource traffic into local PC
 		kubevpn proxy --ssh-addr 192.168.1.100:22 --ssh-username root --ssh-keyfile /Users/naison/.ssh/ssh.pem service/productpage --headers a=1
 
@@ -64,6 +108,9 @@ func CmdProxy(f cmdutil.Factory) *cobra.Command {
 			return handler.SshJump(sshConf, cmd.Flags())
 		},
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := podOptions.apply(cmd); err != nil {
+				return err
+			}
 			if err := connect.InitClient(f); err != nil {
 				return err
 			}
@@ -86,14 +133,40 @@ func CmdProxy(f cmdutil.Factory) *cobra.Command {
 				handler.Cleanup(syscall.SIGQUIT)
 			} else {
 				util.Print(os.Stdout, "Now you can access resources in the kubernetes cluster, enjoy it :)")
+				if connect.AccessLog {
+					go connect.StreamAccessLog(cmd.Context(), os.Stdout)
+				}
+				if headerProxy != "" {
+					go func() {
+						if err := connect.StartHeaderProxy(cmd.Context(), headerProxy); err != nil {
+							log.Errorf("failed to start header proxy, err: %v", err)
+						}
+					}()
+				}
 			}
 			select {}
 		},
 	}
-	cmd.Flags().StringToStringVarP(&connect.Headers, "headers", "H", map[string]string{}, "Traffic with special headers with reverse it to local PC, you should startup your service after reverse workloads successfully, If not special, redirect all traffic to local PC, format is k=v, like: k1=v1,k2=v2")
+	cmd.Flags().StringToStringVarP(&connect.Headers, "headers", "H", map[string]string{}, "Traffic with special headers with reverse it to local PC, you should startup your service after reverse workloads successfully, If not special, redirect all traffic to local PC, format is k=v, like: k1=v1,k2=v2, value also support prefix:<prefix>, regex:<regex> and present")
+	cmd.Flags().StringVar(&connect.PathPrefix, "path-prefix", "", "Traffic with special path prefix with reverse it to local PC, like: /api/v2")
+	cmd.Flags().StringVar(&connect.GrpcMethod, "grpc-method", "", "gRPC request of special method with reverse it to local PC, gRPC metadata can be matched by --headers with lowercase key, like: pkg.Service/Method or pkg.Service")
+	cmd.Flags().StringSliceVar(&connect.SNI, "sni", []string{}, "Raw tls traffic with special server names with reverse it to local PC, tls is not terminated, can not be used with headers, like: a.example.com,b.example.com")
+	cmd.Flags().StringVar(&connect.TLSSecret, "tls-secret", "", "Kubernetes tls secret of workloads, mounted into envoy-proxy sidecar, envoy-proxy terminate tls with it, route by headers, and re-encrypt to upstream, you need permission to get it, like: productpage-tls")
+	cmd.Flags().StringToStringVar(&connect.QueryParams, "query", map[string]string{}, "Traffic with special query parameters with reverse it to local PC, same format as headers, like: k1=v1,k2=prefix:v2")
+	cmd.Flags().Uint32Var(&connect.Weight, "weight", 0, "Percentage of matched traffic reverse to local PC, others still go to origin workloads, range 1-100, like: 5")
+	cmd.Flags().BoolVar(&connect.Mirror, "mirror", false, "Mirror matched traffic to local PC, origin workloads still serve it, response of local PC will be ignored")
+	cmd.Flags().BoolVar(&connect.AccessLog, "access-log", false, "Show access log of envoy-proxy sidecar, requires matcher, --weight or --mirror, each request's method, path, matched route (local or origin_cluster), status and latency")
+	cmd.Flags().StringVar(&headerProxy, "header-proxy", "", "Start a local http proxy on this address, inject headers to requests which send to cluster, like: localhost:8080")
+	cmd.Flags().BoolVar(&connect.Baggage, "baggage", false, "Also match headers in W3C baggage header, like: baggage: a=1, useful if services propagate baggage")
+	cmd.Flags().StringArrayVar(&connect.Faults, "fault", []string{}, "Inject fault into matched traffic, requires --headers, --path-prefix, --query or --grpc-method, matched traffic still go to origin workloads, format is abort=<http status>[:<percentage>] or delay=<duration>[:<percentage>], like: --fault abort=503:10% --fault delay=2s:5%")
+	cmd.Flags().BoolVar(&connect.Force, "force", false, "Take over workloads which already reversed by others without mesh")
+	cmd.Flags().DurationVar(&connect.TTL, "ttl", 0, "Traffic manager will unpatch workloads after ttl, even if kubevpn exits without cleanup, like: 8h, default is never")
 	cmd.Flags().BoolVar(&config.Debug, "debug", false, "Enable debug mode or not, true or false")
 	cmd.Flags().StringVar(&config.Image, "image", config.Image, "Use this image to startup container")
 	cmd.Flags().StringArrayVar(&connect.ExtraCIDR, "extra-cidr", []string{}, "Extra cidr string, eg: --extra-cidr 192.168.0.159/24 --extra-cidr 192.168.1.160/32")
+	addManagerNamespaceFlag(cmd, &connect)
+	cmd.Flags().StringVar(&connect.TunnelCIDR, "tunnel-cidr", "", "Inner tunnel address pool of traffic manager, only works while creating traffic manager, later clients use the same one, ip is tun ip of traffic manager, should not overlap with cluster cidrs, local networks and routes, default is "+config.DefaultTunnelCIDR()+", eg: --tunnel-cidr 198.18.0.100/16")
+	addPodOptionsFlags(cmd, podOptions)
 
 	addSshFlag(cmd, sshConf)
 	cmd.ValidArgsFunction = utilcomp.ResourceTypeAndNameCompletionFunc(f)
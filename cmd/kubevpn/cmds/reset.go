@@ -25,6 +25,9 @@ func CmdReset(factory cmdutil.Factory) *cobra.Command {
 		# Reset another namespace test
 		  kubevpn reset -n test
 
+		# Reset namespace test served by cluster-wide traffic manager in namespace kubevpn, keep traffic manager
+		kubevpn reset -n test --manager-namespace kubevpn
+
 		# Reset cluster api-server behind of bastion host or ssh jump host
 		kubevpn reset --ssh-addr 192.168.1.100:22 --ssh-username root --ssh-keyfile /Users/naison/.ssh/ssh.pem
 
@@ -50,6 +53,7 @@ func CmdReset(factory cmdutil.Factory) *cobra.Command {
 		},
 	}
 
+	addManagerNamespaceFlag(cmd, &connect)
 	// for ssh jumper host
 	cmd.Flags().StringVar(&sshConf.Addr, "ssh-addr", "", "Optional ssh jump server address to dial as <hostname>:<port>, eg: 127.0.0.1:22")
 	cmd.Flags().StringVar(&sshConf.User, "ssh-username", "", "Optional username for ssh jump server")
@@ -37,6 +37,16 @@ func NewKubeVPNCommand() *cobra.Command {
 				CmdVersion(factory),
 				CmdOptions(factory),
 				CmdCp(factory),
+				CmdDNS(factory),
+				CmdStatus(factory),
+				CmdMesh(factory),
+			},
+		},
+		{
+			Message: "Administrator Commands:",
+			Commands: []*cobra.Command{
+				CmdInstall(factory),
+				CmdUninstall(factory),
 			},
 		},
 		{
@@ -48,6 +48,8 @@ func CmdServe(factory cmdutil.Factory) *cobra.Command {
 			if err != nil {
 				return err
 			}
+			go handler.Heartbeat(ctx)
+			go handler.KeepReplicaLinks(ctx, factory, *route)
 			<-ctx.Done()
 			return nil
 		},
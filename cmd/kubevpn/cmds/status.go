@@ -0,0 +1,131 @@
+package cmds
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/apimachinery/pkg/util/sets"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/util/i18n"
+	"k8s.io/kubectl/pkg/util/templates"
+	"k8s.io/utils/pointer"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
+	"github.com/wencaiwulue/kubevpn/pkg/handler"
+	"github.com/wencaiwulue/kubevpn/pkg/util"
+)
+
+func CmdStatus(f cmdutil.Factory) *cobra.Command {
+	var connect = &handler.ConnectOptions{}
+	var sshConf = &util.SshConfig{}
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: i18n.T("Show proxied workloads, ip leases and sessions of traffic manager"),
+		Long:  templates.LongDesc(i18n.T(`Show which workloads are proxied, by whom and how much traffic goes to local PC, and which ips are rented by laptops and pods, and which clients are using traffic manager, matchers of rules are listed by kubevpn mesh rules`)),
+		Example: templates.Examples(i18n.T(`
+		# Show proxied workloads, ip leases and sessions of default namespace
+		kubevpn status
+
+		# Show proxied workloads, ip leases and sessions of another namespace test
+		kubevpn status -n test
+
+		# Show proxied workloads, ip leases and sessions of cluster-wide traffic manager in namespace kubevpn
+		kubevpn status --manager-namespace kubevpn`)),
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return handler.SshJump(sshConf, cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := connect.InitClient(f); err != nil {
+				return err
+			}
+			virtuals, err := connect.GetVirtuals(cmd.Context())
+			if err != nil {
+				return err
+			}
+			leases, err := connect.GetIPLeases(cmd.Context())
+			if err != nil {
+				return err
+			}
+			sessions, err := connect.GetSessions(cmd.Context())
+			if err != nil {
+				return err
+			}
+			w := tabwriter.NewWriter(os.Stdout, 1, 1, 2, ' ', 0)
+			printWorkloads(w, virtuals)
+			_, _ = fmt.Fprintln(w)
+			printIPLeases(w, leases)
+			_, _ = fmt.Fprintln(w)
+			printSessions(w, sessions)
+			return w.Flush()
+		},
+	}
+	addManagerNamespaceFlag(cmd, connect)
+	addSshFlag(cmd, sshConf)
+	return cmd
+}
+
+// printWorkloads print one line per proxied workload, weight of each rule in route order, like 5%,100%,
+// matchers of rules are printed by printRules
+func printWorkloads(w io.Writer, virtuals []*controlplane.Virtual) {
+	_, _ = fmt.Fprintf(w, "NAMESPACE\tWORKLOAD\tRULES\tOWNERS\tWEIGHT\n")
+	for _, virtual := range virtuals {
+		var weights, owners []string
+		for _, rule := range controlplane.SortRules(virtual.Rules) {
+			weight := rule.Weight
+			if weight == 0 {
+				weight = 100
+			}
+			weights = append(weights, fmt.Sprintf("%d%%", weight))
+			owners = append(owners, orNone(rule.Owner.String()))
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", virtual.Namespace, handler.UidToWorkload(virtual.Uid),
+			len(virtual.Rules), strings.Join(sets.List(sets.New[string](owners...)), ","), orNone(strings.Join(weights, ",")))
+	}
+}
+
+// printIPLeases print ip leases as table, expired lease will be reclaimed by traffic manager soon
+func printIPLeases(w io.Writer, leases []coordinationv1.Lease) {
+	_, _ = fmt.Fprintf(w, "IP\tKIND\tNAMESPACE\tHOLDER\tHOSTNAME\tAGE\tLAST HEARTBEAT\tSTATUS\n")
+	for _, lease := range leases {
+		age, heartbeat, status := leaseStatus(lease)
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			lease.Annotations[config.AnnotationIP], lease.Labels[config.LabelIPLease], orNone(lease.Annotations[config.AnnotationNamespace]),
+			orNone(pointer.StringDeref(lease.Spec.HolderIdentity, "")),
+			orNone(lease.Annotations[config.AnnotationHostname]), age, heartbeat, status)
+	}
+}
+
+// printSessions print sessions as table, traffic manager cleans up itself after all sessions expired
+func printSessions(w io.Writer, sessions []coordinationv1.Lease) {
+	_, _ = fmt.Fprintf(w, "SESSION\tHOLDER\tHOSTNAME\tAGE\tLAST HEARTBEAT\tSTATUS\n")
+	for _, session := range sessions {
+		age, heartbeat, status := leaseStatus(session)
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			session.Name, orNone(pointer.StringDeref(session.Spec.HolderIdentity, "")),
+			orNone(session.Annotations[config.AnnotationHostname]), age, heartbeat, status)
+	}
+}
+
+func leaseStatus(lease coordinationv1.Lease) (age, heartbeat, status string) {
+	age, heartbeat, status = "<unknown>", "<unknown>", "active"
+	if lease.Spec.AcquireTime != nil {
+		age = duration.HumanDuration(time.Since(lease.Spec.AcquireTime.Time))
+	}
+	if lease.Spec.RenewTime != nil {
+		heartbeat = duration.HumanDuration(time.Since(lease.Spec.RenewTime.Time)) + " ago"
+	}
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil ||
+		time.Since(lease.Spec.RenewTime.Time) > time.Duration(*lease.Spec.LeaseDurationSeconds)*time.Second {
+		status = "expired"
+	}
+	return
+}
@@ -0,0 +1,48 @@
+package cmds
+
+import (
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/util/i18n"
+	"k8s.io/kubectl/pkg/util/templates"
+
+	"github.com/wencaiwulue/kubevpn/pkg/handler"
+	"github.com/wencaiwulue/kubevpn/pkg/util"
+)
+
+func CmdUninstall(f cmdutil.Factory) *cobra.Command {
+	var connect = &handler.ConnectOptions{}
+	var sshConf = &util.SshConfig{}
+	var force bool
+	cmd := &cobra.Command{
+		Use:   "uninstall",
+		Short: i18n.T("Uninstall traffic manager"),
+		Long:  templates.LongDesc(i18n.T(`Uninstall traffic manager and all its resources, whoever installed it, refuse while clients are using it`)),
+		Example: templates.Examples(i18n.T(`
+		# Uninstall traffic manager of default namespace
+		kubevpn uninstall
+
+		# Uninstall traffic manager of namespace test, even clients are using it
+		kubevpn uninstall -n test --force
+`)),
+		Args: cobra.NoArgs,
+		PreRunE: func(cmd *cobra.Command, args []string) error {
+			return handler.SshJump(sshConf, cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := connect.InitClient(f); err != nil {
+				return err
+			}
+			if err := connect.Uninstall(cmd.Context(), force); err != nil {
+				return err
+			}
+			log.Infof("traffic manager is uninstalled from namespace %s", connect.Namespace)
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&force, "force", false, "Uninstall even clients are using traffic manager")
+
+	addSshFlag(cmd, sshConf)
+	return cmd
+}
@@ -1,10 +1,13 @@
 package config
 
 import (
+	"fmt"
 	"net"
+	"os"
 	"sync"
 	"time"
 
+	log "github.com/sirupsen/logrus"
 	"sigs.k8s.io/kustomize/api/konfig"
 )
 
@@ -16,13 +19,21 @@ const (
 	KeyDHCP             = "DHCP"
 	KeyEnvoy            = "ENVOY_CONFIG"
 	KeyClusterIPv4POOLS = "IPv4_POOLS"
-	KeyRefCount         = "REF_COUNT"
+	// KeyRefCount clients of old version count themselves by it instead of registering session
+	KeyRefCount = "REF_COUNT"
+	// KeyTunnelCIDR inner tunnel address pool of this traffic manager, decided by whom creates traffic manager,
+	// cidr format with traffic manager tun ip, like 223.254.0.100/16
+	KeyTunnelCIDR = "TUNNEL_CIDR"
 
 	// secret keys
 	// TLSCertKey is the key for tls certificates in a TLS secret.
 	TLSCertKey = "tls_crt"
 	// TLSPrivateKeyKey is the key for the private key field in a TLS secret.
 	TLSPrivateKeyKey = "tls_key"
+	// TLSCAKey is the key for CA which signs webhook serving certificate, webhook renews certificate by it
+	TLSCAKey = "ca_crt"
+	// TLSCAPrivateKeyKey is the key for private key of CA
+	TLSCAPrivateKeyKey = "ca_key"
 
 	// container name
 	ContainerSidecarEnvoyProxy   = "envoy-proxy"
@@ -30,6 +41,10 @@ const (
 	ContainerSidecarVPN          = "vpn"
 
 	VolumeEnvoyConfig = "envoy-config"
+	// VolumeTLSSecret tls secret of workloads mounted into envoy-proxy sidecar, kubelet keeps it up to date
+	VolumeTLSSecret = "kubevpn-tls"
+	// TLSSecretMountPath envoy-proxy watches this directory, reloads certificate after secret rotated
+	TLSSecretMountPath = "/etc/kubevpn/tls"
 
 	innerIPv4Pool = "223.254.0.100/16"
 
@@ -44,6 +59,14 @@ const (
 	EnvInboundPodTunIP = "InboundPodTunIP"
 	EnvPodName         = "POD_NAME"
 	EnvPodNamespace    = "POD_NAMESPACE"
+	// EnvTrafficManagerNamespace namespace of cluster-wide traffic manager, injected into sidecars in other namespaces
+	EnvTrafficManagerNamespace = "KUBEVPN_TRAFFIC_MANAGER_NAMESPACE"
+	// EnvPodIP ip of traffic manager pod, replicas link to each other by pod ip
+	EnvPodIP = "POD_IP"
+	// EnvTunnelCIDR inner tunnel address pool, injected into traffic manager and sidecars
+	EnvTunnelCIDR = "TUNNEL_CIDR"
+	// EnvImage image of traffic manager, webhook injects sidecars with the same image
+	EnvImage = "KUBEVPN_IMAGE"
 
 	// header name
 	HeaderPodName      = "POD_NAME"
@@ -53,11 +76,59 @@ const (
 	// api
 	APIRentIP    = "/rent/ip"
 	APIReleaseIP = "/release/ip"
+	// APIRenewIP sidecar renew ip lease periodically
+	APIRenewIP = "/renew/ip"
+	// APIDNSQueryLog served by local pprof server, stream query log of local dns server
+	APIDNSQueryLog = "/dns/log"
+	// APIAccessLog served by control-plane, stream access log of envoy-proxy sidecar
+	APIAccessLog = "/accesslog"
+
+	// PortAccessLog port of control-plane serve access log
+	PortAccessLog = 9004
+	// PortReplica port of traffic manager which other replicas link to
+	PortReplica = 10801
 
 	KUBECONFIG = "kubeconfig"
 
 	// labels
 	ManageBy = konfig.ManagedbyLabelKey
+
+	// annotations of intercepted workloads
+	// AnnotationOwner who intercepts this workload, json format of controlplane.Owner
+	AnnotationOwner = "kubevpn.io/owner"
+	// AnnotationStartTime when intercepts this workload, RFC3339 format
+	AnnotationStartTime = "kubevpn.io/start-time"
+	// AnnotationExpire traffic manager will unpatch this workload after this time, RFC3339 format
+	AnnotationExpire = "kubevpn.io/expire"
+	// AnnotationProbe json patch to restore probes of this workload
+	AnnotationProbe = "probe"
+
+	// annotations of pod template, webhook injects sidecars into pods by them, workloads spec is untouched
+	// AnnotationProxy redirect all inbound traffic of pods to this local tun ip, inject vpn sidecar
+	AnnotationProxy = "kubevpn.io/proxy"
+	// AnnotationMesh envoy node id of workloads, route traffic by rules of it in configmap, inject vpn and envoy-proxy sidecars
+	AnnotationMesh = "kubevpn.io/mesh"
+	// LabelInject label of pod template of intercepted workloads, cluster-wide webhook only receives pods with it
+	LabelInject = "kubevpn.io/inject"
+	// AnnotationTLSSecret tls secret of workloads, mounted into envoy-proxy sidecar to terminate tls
+	AnnotationTLSSecret = "kubevpn.io/tls-secret"
+
+	// LabelIPLease label of ip lease, value is kind of holder, laptop or pod
+	LabelIPLease = "kubevpn.io/ip-lease"
+	// AnnotationIP ip of ip lease, cidr format
+	AnnotationIP = "kubevpn.io/ip"
+	// AnnotationHostname hostname of ip lease or session holder
+	AnnotationHostname = "kubevpn.io/hostname"
+	// AnnotationNamespace namespace of pod which rents ip from cluster-wide traffic manager
+	AnnotationNamespace = "kubevpn.io/namespace"
+	// LabelSession label of session lease, every client registers one while using traffic manager
+	LabelSession = "kubevpn.io/session"
+	// LabelInstalled label of traffic manager deployment installed by kubevpn install, managed by administrator,
+	// clients reuse it and never delete or recreate it
+	LabelInstalled = "kubevpn.io/installed"
+	// LabelClusterWide label of traffic manager deployment installed by kubevpn install --cluster-wide, serves
+	// workloads in all namespaces without their own traffic manager
+	LabelClusterWide = "kubevpn.io/cluster-wide"
 )
 
 var (
@@ -71,6 +142,57 @@ var RouterIP net.IP
 
 func init() {
 	RouterIP, CIDR, _ = net.ParseCIDR(innerIPv4Pool)
+	if image := os.Getenv(EnvImage); image != "" {
+		Image = image
+	}
+	loadEnv()
+}
+
+// loadEnv load options passed to traffic manager and sidecars by env, malformed env is ignored
+// instead of crashing the process, it is set by client, not by who runs this binary
+func loadEnv() {
+	if str := os.Getenv(EnvPodOptions); str != "" {
+		if o, err := ParsePodOptions(str); err != nil {
+			log.Errorf("ignore env %s, use default pod options, err: %v", EnvPodOptions, err)
+		} else {
+			Pod = o
+		}
+	}
+	// traffic manager and sidecars use the same tunnel address pool as client who creates traffic manager
+	if pool := os.Getenv(EnvTunnelCIDR); pool != "" {
+		if err := SetTunnelCIDR(pool); err != nil {
+			log.Errorf("ignore env %s, use default tunnel cidr %s, err: %v", EnvTunnelCIDR, TunnelCIDR(), err)
+		}
+	}
+}
+
+// DefaultTunnelCIDR default inner tunnel address pool
+func DefaultTunnelCIDR() string {
+	return innerIPv4Pool
+}
+
+// TunnelCIDR inner tunnel address pool in use, cidr format with traffic manager tun ip, like 223.254.0.100/16
+func TunnelCIDR() string {
+	return (&net.IPNet{IP: RouterIP, Mask: CIDR.Mask}).String()
+}
+
+// SetTunnelCIDR set inner tunnel address pool, ip of pool is tun ip of traffic manager, like 198.18.0.100/16
+func SetTunnelCIDR(pool string) error {
+	ip, cidr, err := net.ParseCIDR(pool)
+	if err != nil {
+		return fmt.Errorf("invalid tunnel cidr %s, err: %v", pool, err)
+	}
+	if ip.To4() == nil {
+		return fmt.Errorf("invalid tunnel cidr %s, only support ipv4", pool)
+	}
+	if ones, bits := cidr.Mask.Size(); bits-ones < 8 {
+		return fmt.Errorf("invalid tunnel cidr %s, mask is too long, at least /24", pool)
+	}
+	if ip.Equal(cidr.IP) {
+		return fmt.Errorf("invalid tunnel cidr %s, ip should be tun ip of traffic manager, not network address, like %s", pool, innerIPv4Pool)
+	}
+	RouterIP, CIDR = ip.To4(), cidr
+	return nil
 }
 
 var Debug bool
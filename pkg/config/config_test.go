@@ -0,0 +1,18 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestLoadEnvMalformed(t *testing.T) {
+	pod := Pod
+	t.Setenv(EnvPodOptions, "{")
+	t.Setenv(EnvTunnelCIDR, "223.254.0.0/16")
+	loadEnv()
+	if Pod != pod {
+		t.Errorf("expect default pod options, but got %v", Pod)
+	}
+	if cidr := TunnelCIDR(); cidr != DefaultTunnelCIDR() {
+		t.Errorf("expect default tunnel cidr %s, but got %s", DefaultTunnelCIDR(), cidr)
+	}
+}
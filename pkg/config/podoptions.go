@@ -0,0 +1,136 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// EnvPodOptions json format of PodOptions, injected into traffic manager, webhook injects sidecars with the same options
+const EnvPodOptions = "KUBEVPN_POD_OPTIONS"
+
+// PodOptions scheduling and resource options of traffic manager and injected vpn/envoy-proxy sidecars, some clusters
+// require node selector, tolerations or priority class, or annotations like sidecar.istio.io/inject=false
+type PodOptions struct {
+	// Resources of traffic manager containers, default is DefaultResources
+	Resources *v1.ResourceRequirements `json:"resources,omitempty"`
+	// SidecarResources of vpn and envoy-proxy sidecars, default is DefaultSidecarResources
+	SidecarResources *v1.ResourceRequirements `json:"sidecarResources,omitempty"`
+	// NodeSelector and Tolerations of traffic manager, pods of workloads are scheduled as before
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	Tolerations  []v1.Toleration   `json:"tolerations,omitempty"`
+	// PriorityClassName of traffic manager, default is system-cluster-critical, empty means no priority class,
+	// priority of pods which sidecars are injected into is resolved before injection, so it is never changed
+	PriorityClassName *string `json:"priorityClassName,omitempty"`
+	// ImagePullSecrets ImagePullSecrets, Labels and Annotations of traffic manager, and added to pods which sidecars are
+	// injected into, existing labels and annotations of pods are kept, image pull secrets are only added to pods in
+	// namespace of traffic manager, secrets do not exist in other namespaces
+	ImagePullSecrets []v1.LocalObjectReference `json:"imagePullSecrets,omitempty"`
+	Labels           map[string]string         `json:"labels,omitempty"`
+	Annotations      map[string]string         `json:"annotations,omitempty"`
+}
+
+// Pod scheduling and resource options in use, set by flags of client, or env KUBEVPN_POD_OPTIONS in traffic manager
+var Pod = &PodOptions{}
+
+// DefaultResources default resources of traffic manager containers
+func DefaultResources() v1.ResourceRequirements {
+	return v1.ResourceRequirements{
+		Requests: map[v1.ResourceName]resource.Quantity{
+			v1.ResourceCPU:    resource.MustParse("1000m"),
+			v1.ResourceMemory: resource.MustParse("1024Mi"),
+		},
+		Limits: map[v1.ResourceName]resource.Quantity{
+			v1.ResourceCPU:    resource.MustParse("2000m"),
+			v1.ResourceMemory: resource.MustParse("2048Mi"),
+		},
+	}
+}
+
+// DefaultSidecarResources default resources of vpn and envoy-proxy sidecars
+func DefaultSidecarResources() v1.ResourceRequirements {
+	return v1.ResourceRequirements{
+		Requests: map[v1.ResourceName]resource.Quantity{
+			v1.ResourceCPU:    resource.MustParse("128m"),
+			v1.ResourceMemory: resource.MustParse("128Mi"),
+		},
+		Limits: map[v1.ResourceName]resource.Quantity{
+			v1.ResourceCPU:    resource.MustParse("256m"),
+			v1.ResourceMemory: resource.MustParse("256Mi"),
+		},
+	}
+}
+
+func (o *PodOptions) GetResources() v1.ResourceRequirements {
+	if o.Resources == nil {
+		return DefaultResources()
+	}
+	return *o.Resources
+}
+
+func (o *PodOptions) GetSidecarResources() v1.ResourceRequirements {
+	if o.SidecarResources == nil {
+		return DefaultSidecarResources()
+	}
+	return *o.SidecarResources
+}
+
+func (o *PodOptions) GetPriorityClassName() string {
+	if o.PriorityClassName == nil {
+		return "system-cluster-critical"
+	}
+	return *o.PriorityClassName
+}
+
+// ApplyToPod add image pull secrets, labels and annotations to pod which sidecars are injected into,
+// image pull secrets are added only if pod is in namespace of traffic manager
+func (o *PodOptions) ApplyToPod(meta *metav1.ObjectMeta, spec *v1.PodSpec, sameNamespace bool) {
+	for _, secret := range o.ImagePullSecrets {
+		var found bool
+		for _, s := range spec.ImagePullSecrets {
+			if s.Name == secret.Name {
+				found = true
+				break
+			}
+		}
+		if !found && sameNamespace {
+			spec.ImagePullSecrets = append(spec.ImagePullSecrets, secret)
+		}
+	}
+	meta.Labels = mergeKeep(meta.Labels, o.Labels)
+	meta.Annotations = mergeKeep(meta.Annotations, o.Annotations)
+}
+
+// mergeKeep add pairs of from into to, existing keys are kept
+func mergeKeep(to, from map[string]string) map[string]string {
+	if len(from) == 0 {
+		return to
+	}
+	if to == nil {
+		to = make(map[string]string, len(from))
+	}
+	for k, v := range from {
+		if _, found := to[k]; !found {
+			to[k] = v
+		}
+	}
+	return to
+}
+
+// String json format, used as env KUBEVPN_POD_OPTIONS
+func (o *PodOptions) String() string {
+	bytes, _ := json.Marshal(o)
+	return string(bytes)
+}
+
+// ParsePodOptions parse json format of PodOptions
+func ParsePodOptions(str string) (*PodOptions, error) {
+	var o PodOptions
+	if err := json.Unmarshal([]byte(str), &o); err != nil {
+		return nil, fmt.Errorf("invalid pod options %s, err: %v", str, err)
+	}
+	return &o, nil
+}
@@ -0,0 +1,40 @@
+package config
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPodOptionsApplyToPod(t *testing.T) {
+	o, err := ParsePodOptions((&PodOptions{
+		ImagePullSecrets: []v1.LocalObjectReference{{Name: "regcred"}},
+		Labels:           map[string]string{"app": "kubevpn", "team": "infra"},
+		Annotations:      map[string]string{"sidecar.istio.io/inject": "false"},
+	}).String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta := metav1.ObjectMeta{Labels: map[string]string{"app": "productpage"}}
+	spec := v1.PodSpec{ImagePullSecrets: []v1.LocalObjectReference{{Name: "regcred"}}}
+	o.ApplyToPod(&meta, &spec, true)
+	if len(spec.ImagePullSecrets) != 1 {
+		t.Errorf("image pull secret is added twice: %v", spec.ImagePullSecrets)
+	}
+	other := v1.PodSpec{}
+	o.ApplyToPod(&metav1.ObjectMeta{}, &other, false)
+	if len(other.ImagePullSecrets) != 0 {
+		t.Errorf("image pull secret does not exist in other namespace: %v", other.ImagePullSecrets)
+	}
+	if meta.Labels["app"] != "productpage" || meta.Labels["team"] != "infra" {
+		t.Errorf("existing labels should be kept and others added: %v", meta.Labels)
+	}
+	if meta.Annotations["sidecar.istio.io/inject"] != "false" {
+		t.Errorf("annotations not added: %v", meta.Annotations)
+	}
+	resources := o.GetSidecarResources()
+	if o.GetPriorityClassName() != "system-cluster-critical" || resources.Limits.Cpu().String() != "256m" {
+		t.Errorf("default priority class or sidecar resources not used")
+	}
+}
@@ -0,0 +1,136 @@
+package controlplane
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	accesslogv3 "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
+	log "github.com/sirupsen/logrus"
+)
+
+// AccessLog one http request handled by envoy-proxy sidecar
+type AccessLog struct {
+	Time time.Time `json:"time"`
+	// Node is envoy node id, format: group.resource.name
+	Node      string `json:"node"`
+	Method    string `json:"method"`
+	Authority string `json:"authority"`
+	Path      string `json:"path"`
+	// Route name of matched route, cluster name if matched rule of local PC, otherwise origin_cluster
+	Route string `json:"route"`
+	// Cluster is upstream cluster really used, differ from route if using weight
+	Cluster string        `json:"cluster"`
+	Status  uint32        `json:"status"`
+	Latency time.Duration `json:"latency"`
+}
+
+// String print matched route, and upstream cluster if differ from route, like: weight split traffic to origin_cluster
+func (l *AccessLog) String() string {
+	to := l.Route
+	if l.Cluster != "" && l.Cluster != l.Route {
+		to = fmt.Sprintf("%s(%s)", l.Route, l.Cluster)
+	}
+	return fmt.Sprintf("%s %s %s %s%s -> %s %d %v", l.Time.Format("15:04:05.000"), l.Node, l.Method, l.Authority, l.Path, to, l.Status, l.Latency.Round(time.Microsecond))
+}
+
+// AccessLogServer receive access log from envoy by gRPC ALS, and stream it to kubevpn proxy --access-log
+type AccessLogServer struct {
+	lock        sync.Mutex
+	subscribers map[chan *AccessLog]struct{}
+}
+
+func NewAccessLogServer() *AccessLogServer {
+	return &AccessLogServer{subscribers: map[chan *AccessLog]struct{}{}}
+}
+
+func (s *AccessLogServer) StreamAccessLogs(stream accesslogv3.AccessLogService_StreamAccessLogsServer) error {
+	var node string
+	for {
+		msg, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		// identifier only send on the first message of stream
+		if msg.GetIdentifier() != nil {
+			node = msg.GetIdentifier().GetNode().GetId()
+		}
+		for _, entry := range msg.GetHttpLogs().GetLogEntry() {
+			common := entry.GetCommonProperties()
+			s.publish(&AccessLog{
+				Time:      common.GetStartTime().AsTime(),
+				Node:      node,
+				Method:    entry.GetRequest().GetRequestMethod().String(),
+				Authority: entry.GetRequest().GetAuthority(),
+				Path:      entry.GetRequest().GetPath(),
+				Route:     common.GetRouteName(),
+				Cluster:   common.GetUpstreamCluster(),
+				Status:    entry.GetResponse().GetResponseCode().GetValue(),
+				Latency:   common.GetTimeToLastDownstreamTxByte().AsDuration(),
+			})
+		}
+	}
+}
+
+func (s *AccessLogServer) publish(l *AccessLog) {
+	log.Debugln(l.String())
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for c := range s.subscribers {
+		select {
+		case c <- l:
+		default:
+			// slow subscriber, drop it
+		}
+	}
+}
+
+// ServeHTTP stream access log as json lines, query parameter node is optional, multiple node split by comma
+func (s *AccessLogServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	nodes := strings.Split(r.URL.Query().Get("node"), ",")
+	c := make(chan *AccessLog, 100)
+	s.lock.Lock()
+	s.subscribers[c] = struct{}{}
+	s.lock.Unlock()
+	defer func() {
+		s.lock.Lock()
+		delete(s.subscribers, c)
+		s.lock.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case l := <-c:
+			if !matchNode(nodes, l.Node) {
+				continue
+			}
+			if err := encoder.Encode(l); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+func matchNode(nodes []string, node string) bool {
+	for _, n := range nodes {
+		if n == "" || n == node {
+			return true
+		}
+	}
+	return false
+}
@@ -2,21 +2,26 @@ package controlplane
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
+	accesslogv3 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
 	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	endpoint "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
 	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
 	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	grpcaccesslogv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/grpc/v3"
 	corsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/cors/v3"
+	faultv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
 	grpcwebv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/grpc_web/v3"
 	routerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
 	httpinspector "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/http_inspector/v3"
+	tlsinspector "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/tls_inspector/v3"
 	httpconnectionmanager "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
 	tcpproxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
 	httpv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
-	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
 	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
@@ -25,17 +30,95 @@ import (
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/wrapperspb"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type Virtual struct {
-	Uid   string // group.resource.name
-	Ports []corev1.ContainerPort
-	Rules []*Rule
+	Uid string // group.resource.name
+	// Namespace of workload, only set by cluster-wide traffic manager which serves workloads in many namespaces
+	Namespace string `json:"Namespace,omitempty"`
+	Ports     []corev1.ContainerPort
+	Rules     []*Rule
+}
+
+// NodeID envoy node id of workload, format: group.resource.name, or group.resource.name.namespace if namespace is set
+func (a *Virtual) NodeID() string {
+	return NodeID(a.Uid, a.Namespace)
+}
+
+// NodeID envoy node id of workload uid in namespace, namespace is empty for traffic manager of workload namespace
+func NodeID(uid, namespace string) string {
+	if namespace == "" {
+		return uid
+	}
+	return uid + "." + namespace
 }
 
 type Rule struct {
+	// Headers value support exact match, prefix:<prefix>, regex:<regex> and present
 	Headers    map[string]string
 	LocalTunIP string
+	// PathPrefix only request path with this prefix will match, default is /
+	PathPrefix string `json:"PathPrefix,omitempty"`
+	// QueryParams same value format as Headers
+	QueryParams map[string]string `json:"QueryParams,omitempty"`
+	// Weight percentage of matched traffic to local PC, others go to origin workloads, 0 means 100
+	// if Mirror is true, it means percentage of matched traffic to mirror
+	Weight uint32 `json:"Weight,omitempty"`
+	// Mirror origin workloads still serve matched traffic, local PC receive a copy, and its response is ignored
+	Mirror bool `json:"Mirror,omitempty"`
+	// AccessLog send access log of this workload to control-plane
+	AccessLog bool `json:"AccessLog,omitempty"`
+	// Owner who add this rule
+	Owner *Owner `json:"Owner,omitempty"`
+	// CreationTimestamp when this rule added
+	CreationTimestamp *metav1.Time `json:"CreationTimestamp,omitempty"`
+	// Expire after this time, traffic manager will remove this rule automatically, nil means never
+	Expire *metav1.Time `json:"Expire,omitempty"`
+	// Baggage also match headers as W3C baggage entries, like: baggage: a=1,b=2
+	Baggage bool `json:"Baggage,omitempty"`
+	// GrpcMethod only gRPC request of this method will match, format: pkg.Service/Method or pkg.Service for all methods,
+	// gRPC metadata can be matched by Headers
+	GrpcMethod string `json:"GrpcMethod,omitempty"`
+	// SNI raw tls traffic with these server names will go to local PC without terminating tls, can not be used with http matchers
+	SNI []string `json:"SNI,omitempty"`
+	// TLSSecret kubernetes.io/tls secret of workloads, envoy-proxy terminate tls with it, route by http matchers, and re-encrypt to upstream
+	TLSSecret string `json:"TLSSecret,omitempty"`
+	// Fault inject abort or delay into matched traffic, matched traffic go to origin workloads instead of local PC
+	Fault *Fault `json:"Fault,omitempty"`
+}
+
+// Owner who intercepts workloads
+type Owner struct {
+	// User is kubernetes user name, get from SelfSubjectReview or kubeconfig
+	User     string `json:"User,omitempty"`
+	Hostname string `json:"Hostname,omitempty"`
+}
+
+func (o *Owner) String() string {
+	if o == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s@%s", o.User, o.Hostname)
+}
+
+// Expired rule is expired or not
+func (r *Rule) Expired(now time.Time) bool {
+	return r.Expire != nil && r.Expire.Time.Before(now)
+}
+
+// HasMatcher rule without any matcher will match all traffic
+func (r *Rule) HasMatcher() bool {
+	return len(r.Headers) != 0 || r.PathPrefix != "" || len(r.QueryParams) != 0 || r.GrpcMethod != "" || len(r.SNI) != 0
+}
+
+// SortRules rule without matcher must be the last one, otherwise other rules will never be matched
+func SortRules(rules []*Rule) []*Rule {
+	result := append([]*Rule{}, rules...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].HasMatcher() && !result[j].HasMatcher()
+	})
+	return result
 }
 
 func (a *Virtual) To() (
@@ -45,34 +128,66 @@ func (a *Virtual) To() (
 	endpoints []types.Resource,
 ) {
 	//clusters = append(clusters, OriginCluster())
+	var accessLog bool
+	for _, rule := range a.Rules {
+		accessLog = accessLog || rule.AccessLog
+	}
+	// terminate tls, route by http matchers, and re-encrypt to local PC or origin workloads
+	tlsSecret := a.TLSSecret()
+	if tlsSecret != "" {
+		clusters = append(clusters, ToTLSCluster(OriginCluster()))
+	}
 	for _, port := range a.Ports {
 		listenerName := fmt.Sprintf("%s_%v_%s", a.Uid, port.ContainerPort, port.Protocol)
 		routeName := listenerName
-		listeners = append(listeners, ToListener(listenerName, routeName, port.ContainerPort, port.Protocol))
 
 		var rr []*route.Route
-		for _, rule := range a.Rules {
+		var sniChains []*listener.FilterChain
+		for _, rule := range SortRules(a.Rules) {
 			clusterName := fmt.Sprintf("%s_%v", rule.LocalTunIP, port.ContainerPort)
 			clusters = append(clusters, ToCluster(clusterName))
 			endpoints = append(endpoints, ToEndPoint(clusterName, rule.LocalTunIP, port.ContainerPort))
-			rr = append(rr, ToRoute(clusterName, rule.Headers))
+			if tlsSecret != "" {
+				clusters = append(clusters, ToTLSCluster(ToCluster(clusterName)))
+			}
+			// raw tls traffic, not http
+			if len(rule.SNI) != 0 {
+				sniChains = append(sniChains, ToSNIFilterChain(clusterName, rule.SNI))
+				continue
+			}
+			rr = append(rr, ToRoute(clusterName, rule))
+			if rule.Baggage && len(rule.Headers) != 0 {
+				rr = append(rr, ToBaggageRoute(clusterName, rule))
+			}
 		}
 		rr = append(rr, DefaultRoute())
-		routes = append(routes, &route.RouteConfiguration{
-			Name: routeName,
-			VirtualHosts: []*route.VirtualHost{
-				{
-					Name:    "local_service",
-					Domains: []string{"*"},
-					Routes:  rr,
-				},
-			},
-			MaxDirectResponseBodySizeBytes: nil,
-		})
+		routes = append(routes, ToRouteConfiguration(routeName, rr))
+		if tlsSecret != "" {
+			var tlsRoutes []*route.Route
+			for _, r := range rr {
+				tlsRoutes = append(tlsRoutes, ToTLSRoute(r))
+			}
+			routes = append(routes, ToRouteConfiguration(routeName+TLSClusterSuffix, tlsRoutes))
+		}
+		listeners = append(listeners, ToListener(listenerName, routeName, port.ContainerPort, port.Protocol, accessLog, tlsSecret, sniChains))
 	}
 	return
 }
 
+func ToRouteConfiguration(routeName string, rr []*route.Route) *route.RouteConfiguration {
+	return &route.RouteConfiguration{
+		Name: routeName,
+		VirtualHosts: []*route.VirtualHost{
+			{
+				Name:    "local_service",
+				Domains: []string{"*"},
+				Routes:  rr,
+			},
+		},
+		MaxDirectResponseBodySizeBytes: nil,
+	}
+}
+
 func ToEndPoint(clusterName string, localTunIP string, port int32) *endpoint.ClusterLoadAssignment {
 	return &endpoint.ClusterLoadAssignment{
 		ClusterName: clusterName,
@@ -116,17 +231,27 @@ func ToCluster(clusterName string) *cluster.Cluster {
 		ConnectTimeout: durationpb.New(5 * time.Second),
 		LbPolicy:       cluster.Cluster_ROUND_ROBIN,
 		TypedExtensionProtocolOptions: map[string]*anypb.Any{
-			"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": anyFunc(&httpv3.HttpProtocolOptions{
-				UpstreamProtocolOptions: &httpv3.HttpProtocolOptions_UseDownstreamProtocolConfig{
-					UseDownstreamProtocolConfig: &httpv3.HttpProtocolOptions_UseDownstreamHttpConfig{},
-				},
-			}),
+			"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": anyFunc(DownstreamProtocolOptions()),
 		},
 		DnsLookupFamily: cluster.Cluster_V4_ONLY,
 	}
 }
 
+// DownstreamProtocolOptions upstream use same protocol as downstream, http2 options must be set explicitly,
+// otherwise envoy always use http1 to upstream, gRPC over h2c will be broken
+func DownstreamProtocolOptions() *httpv3.HttpProtocolOptions {
+	return &httpv3.HttpProtocolOptions{
+		UpstreamProtocolOptions: &httpv3.HttpProtocolOptions_UseDownstreamProtocolConfig{
+			UseDownstreamProtocolConfig: &httpv3.HttpProtocolOptions_UseDownstreamHttpConfig{
+				HttpProtocolOptions:  &core.Http1ProtocolOptions{},
+				Http2ProtocolOptions: &core.Http2ProtocolOptions{},
+			},
+		},
+	}
+}
+
 func OriginCluster() *cluster.Cluster {
+	options, _ := anypb.New(DownstreamProtocolOptions())
 	return &cluster.Cluster{
 		Name:           "origin_cluster",
 		ConnectTimeout: durationpb.New(time.Second * 5),
@@ -134,48 +259,95 @@ func OriginCluster() *cluster.Cluster {
 		ClusterDiscoveryType: &cluster.Cluster_Type{
 			Type: cluster.Cluster_ORIGINAL_DST,
 		},
+		TypedExtensionProtocolOptions: map[string]*anypb.Any{
+			"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": options,
+		},
 	}
 }
 
-func ToRoute(clusterName string, headers map[string]string) *route.Route {
-	var r []*route.HeaderMatcher
-	for k, v := range headers {
-		r = append(r, &route.HeaderMatcher{
-			Name: k,
-			HeaderMatchSpecifier: &route.HeaderMatcher_StringMatch{
-				StringMatch: &matcher.StringMatcher{
-					MatchPattern: &matcher.StringMatcher_Exact{
-						Exact: v,
-					},
-				},
-			},
-		})
+func ToRoute(clusterName string, rule *Rule) *route.Route {
+	prefix := rule.PathPrefix
+	if prefix == "" {
+		prefix = "/"
 	}
-	return &route.Route{
-		Match: &route.RouteMatch{
-			PathSpecifier: &route.RouteMatch_Prefix{
-				Prefix: "/",
-			},
-			Headers: r,
+	action := &route.RouteAction{
+		ClusterSpecifier: &route.RouteAction_Cluster{
+			Cluster: clusterName,
 		},
-		Action: &route.Route_Route{
-			Route: &route.RouteAction{
-				ClusterSpecifier: &route.RouteAction_Cluster{
-					Cluster: clusterName,
+		Timeout:     durationpb.New(0),
+		IdleTimeout: durationpb.New(0),
+		MaxStreamDuration: &route.RouteAction_MaxStreamDuration{
+			MaxStreamDuration:    durationpb.New(0),
+			GrpcTimeoutHeaderMax: durationpb.New(0),
+		},
+	}
+	var perFilterConfig map[string]*anypb.Any
+	if rule.Fault != nil {
+		// test resilience of client, matched traffic still go to origin workloads, but with fault
+		action.ClusterSpecifier = &route.RouteAction_Cluster{Cluster: "origin_cluster"}
+		fault, _ := anypb.New(ToHTTPFault(rule.Fault))
+		perFilterConfig = map[string]*anypb.Any{wellknown.Fault: fault}
+	} else if rule.Mirror {
+		action.ClusterSpecifier = &route.RouteAction_Cluster{Cluster: "origin_cluster"}
+		policy := &route.RouteAction_RequestMirrorPolicy{Cluster: clusterName}
+		if rule.Weight > 0 && rule.Weight < 100 {
+			policy.RuntimeFraction = &core.RuntimeFractionalPercent{
+				DefaultValue: &typev3.FractionalPercent{
+					Numerator:   rule.Weight,
+					Denominator: typev3.FractionalPercent_HUNDRED,
 				},
-				Timeout:     durationpb.New(0),
-				IdleTimeout: durationpb.New(0),
-				MaxStreamDuration: &route.RouteAction_MaxStreamDuration{
-					MaxStreamDuration:    durationpb.New(0),
-					GrpcTimeoutHeaderMax: durationpb.New(0),
+			}
+		}
+		action.RequestMirrorPolicies = []*route.RouteAction_RequestMirrorPolicy{policy}
+	} else if rule.Weight > 0 && rule.Weight < 100 {
+		// split weight percent of matched traffic to local PC, others to origin cluster
+		action.ClusterSpecifier = &route.RouteAction_WeightedClusters{
+			WeightedClusters: &route.WeightedCluster{
+				Clusters: []*route.WeightedCluster_ClusterWeight{
+					{Name: clusterName, Weight: wrapperspb.UInt32(rule.Weight)},
+					{Name: "origin_cluster", Weight: wrapperspb.UInt32(100 - rule.Weight)},
 				},
 			},
+		}
+	}
+	match := &route.RouteMatch{
+		PathSpecifier: &route.RouteMatch_Prefix{
+			Prefix: prefix,
+		},
+		Headers:         ToHeaderMatchers(rule.Headers),
+		QueryParameters: ToQueryParameterMatchers(rule.QueryParams),
+	}
+	if rule.GrpcMethod != "" {
+		if path, exact := ToGrpcPath(rule.GrpcMethod); exact {
+			match.PathSpecifier = &route.RouteMatch_Path{Path: path}
+		} else {
+			match.PathSpecifier = &route.RouteMatch_Prefix{Prefix: path}
+		}
+		// only match request with content-type application/grpc
+		match.Grpc = &route.RouteMatch_GrpcRouteMatchOptions{}
+	}
+	return &route.Route{
+		Name:  clusterName,
+		Match: match,
+		Action: &route.Route_Route{
+			Route: action,
 		},
+		TypedPerFilterConfig: perFilterConfig,
 	}
 }
 
+// ToBaggageRoute same as ToRoute, but match headers in W3C baggage header,
+// services which propagate baggage (like OpenTelemetry) will pass it to downstream automatically
+func ToBaggageRoute(clusterName string, rule *Rule) *route.Route {
+	r := ToRoute(clusterName, rule)
+	r.Name = clusterName + "_baggage"
+	r.Match.Headers = ToBaggageMatchers(rule.Headers)
+	return r
+}
+
 func DefaultRoute() *route.Route {
 	return &route.Route{
+		Name: "origin_cluster",
 		Match: &route.RouteMatch{
 			PathSpecifier: &route.RouteMatch_Prefix{
 				Prefix: "/",
@@ -197,7 +369,9 @@ func DefaultRoute() *route.Route {
 	}
 }
 
-func ToListener(listenerName string, routeName string, port int32, p corev1.Protocol) *listener.Listener {
+// ToListener plain http traffic is routed by rds routeName, if tlsSecret is not empty, tls traffic is terminated and routed by
+// rds routeName_tls, raw tls traffic matched sniChains go to local PC, other traffic go to origin_cluster
+func ToListener(listenerName string, routeName string, port int32, p corev1.Protocol, accessLog bool, tlsSecret string, sniChains []*listener.FilterChain) *listener.Listener {
 	var protocol core.SocketAddress_Protocol
 	switch p {
 	case corev1.ProtocolTCP:
@@ -223,7 +397,7 @@ func ToListener(listenerName string, routeName string, port int32, p corev1.Prot
 					ConfigSourceSpecifier: &core.ConfigSource_ApiConfigSource{
 						ApiConfigSource: &core.ApiConfigSource{
 							TransportApiVersion:       resource.DefaultAPIVersion,
-							ApiType:                   core.ApiConfigSource_GRPC,
+							ApiType:                   core.ApiConfigSource_DELTA_GRPC,
 							SetNodeOnFirstMessageOnly: true,
 							GrpcServices: []*core.GrpcService{{
 								TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
@@ -238,6 +412,13 @@ func ToListener(listenerName string, routeName string, port int32, p corev1.Prot
 		},
 		// "details": "Error: terminal filter named envoy.filters.http.router of type envoy.filters.http.router must be the last filter in a http filter chain."
 		HttpFilters: []*httpconnectionmanager.HttpFilter{
+			{
+				// do nothing unless route has fault config
+				Name: wellknown.Fault,
+				ConfigType: &httpconnectionmanager.HttpFilter_TypedConfig{
+					TypedConfig: anyFunc(&faultv3.HTTPFault{}),
+				},
+			},
 			{
 				Name: wellknown.GRPCWeb,
 				ConfigType: &httpconnectionmanager.HttpFilter_TypedConfig{
@@ -263,6 +444,26 @@ func ToListener(listenerName string, routeName string, port int32, p corev1.Prot
 		}},
 	}
 
+	// send access log to control-plane by gRPC ALS
+	if accessLog {
+		httpManager.AccessLog = []*accesslogv3.AccessLog{{
+			Name: wellknown.HTTPGRPCAccessLog,
+			ConfigType: &accesslogv3.AccessLog_TypedConfig{
+				TypedConfig: anyFunc(&grpcaccesslogv3.HttpGrpcAccessLogConfig{
+					CommonConfig: &grpcaccesslogv3.CommonGrpcAccessLogConfig{
+						LogName:             listenerName,
+						TransportApiVersion: resource.DefaultAPIVersion,
+						GrpcService: &core.GrpcService{
+							TargetSpecifier: &core.GrpcService_EnvoyGrpc_{
+								EnvoyGrpc: &core.GrpcService_EnvoyGrpc{ClusterName: "xds_cluster"},
+							},
+						},
+					},
+				}),
+			},
+		}}
+	}
+
 	tcpConfig := &tcpproxy.TcpProxy{
 		StatPrefix: "tcp",
 		ClusterSpecifier: &tcpproxy.TcpProxy_Cluster{
@@ -270,6 +471,56 @@ func ToListener(listenerName string, routeName string, port int32, p corev1.Prot
 		},
 	}
 
+	filterChains := []*listener.FilterChain{
+		{
+			FilterChainMatch: &listener.FilterChainMatch{
+				// tls traffic with alpn http/1.1 should not match this filter chain
+				TransportProtocol:    "raw_buffer",
+				ApplicationProtocols: []string{"http/1.0", "http/1.1", "h2c"},
+			},
+			Filters: []*listener.Filter{
+				{
+					Name: wellknown.HTTPConnectionManager,
+					ConfigType: &listener.Filter_TypedConfig{
+						TypedConfig: anyFunc(httpManager),
+					},
+				},
+			},
+		},
+	}
+	// server names is more specific than transport protocol, so sni filter chains take precedence over tls termination
+	filterChains = append(filterChains, sniChains...)
+	if tlsSecret != "" {
+		tlsManager := proto.Clone(httpManager).(*httpconnectionmanager.HttpConnectionManager)
+		tlsManager.StatPrefix = "https"
+		tlsManager.GetRds().RouteConfigName = routeName + TLSClusterSuffix
+		filterChains = append(filterChains, &listener.FilterChain{
+			Name: listenerName + TLSClusterSuffix,
+			FilterChainMatch: &listener.FilterChainMatch{
+				TransportProtocol: "tls",
+			},
+			TransportSocket: ToDownstreamTLSContext(),
+			Filters: []*listener.Filter{
+				{
+					Name: wellknown.HTTPConnectionManager,
+					ConfigType: &listener.Filter_TypedConfig{
+						TypedConfig: anyFunc(tlsManager),
+					},
+				},
+			},
+		})
+	}
+	filterChains = append(filterChains, &listener.FilterChain{
+		Filters: []*listener.Filter{
+			{
+				Name: wellknown.TCPProxy,
+				ConfigType: &listener.Filter_TypedConfig{
+					TypedConfig: anyFunc(tcpConfig),
+				},
+			},
+		},
+	})
+
 	return &listener.Listener{
 		Name:             listenerName,
 		TrafficDirection: core.TrafficDirection_INBOUND,
@@ -287,32 +538,14 @@ func ToListener(listenerName string, routeName string, port int32, p corev1.Prot
 				},
 			},
 		},
-		FilterChains: []*listener.FilterChain{
-			{
-				FilterChainMatch: &listener.FilterChainMatch{
-					ApplicationProtocols: []string{"http/1.0", "http/1.1", "h2c"},
-				},
-				Filters: []*listener.Filter{
-					{
-						Name: wellknown.HTTPConnectionManager,
-						ConfigType: &listener.Filter_TypedConfig{
-							TypedConfig: anyFunc(httpManager),
-						},
-					},
-				},
-			},
+		FilterChains: filterChains,
+		ListenerFilters: []*listener.ListenerFilter{
 			{
-				Filters: []*listener.Filter{
-					{
-						Name: wellknown.TCPProxy,
-						ConfigType: &listener.Filter_TypedConfig{
-							TypedConfig: anyFunc(tcpConfig),
-						},
-					},
+				Name: wellknown.TlsInspector,
+				ConfigType: &listener.ListenerFilter_TypedConfig{
+					TypedConfig: anyFunc(&tlsinspector.TlsInspector{}),
 				},
 			},
-		},
-		ListenerFilters: []*listener.ListenerFilter{
 			{
 				Name: wellknown.HttpInspector,
 				ConfigType: &listener.ListenerFilter_TypedConfig{
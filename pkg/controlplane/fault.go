@@ -0,0 +1,107 @@
+package controlplane
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	faultcommonv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/common/fault/v3"
+	faultv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	typev3 "github.com/envoyproxy/go-control-plane/envoy/type/v3"
+	"google.golang.org/protobuf/types/known/durationpb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// format of fault
+// abort=503:10%   --> 10% of matched requests abort with http status 503
+// abort=503       --> all matched requests abort with http status 503
+// delay=2s:5%     --> 5% of matched requests delay 2s before forward to origin workloads
+const (
+	FaultAbort = "abort"
+	FaultDelay = "delay"
+)
+
+// Fault inject fault into matched traffic, matched traffic still go to origin workloads
+type Fault struct {
+	// AbortStatus http status of aborted request, 0 means no abort
+	AbortStatus  uint32 `json:"AbortStatus,omitempty"`
+	AbortPercent uint32 `json:"AbortPercent,omitempty"`
+	// Delay fixed delay of request, nil means no delay
+	Delay        *metav1.Duration `json:"Delay,omitempty"`
+	DelayPercent uint32           `json:"DelayPercent,omitempty"`
+}
+
+// ParseFault parse fault like abort=503:10% and delay=2s:5%, percentage is 100% if not specified
+func ParseFault(faults []string) (*Fault, error) {
+	if len(faults) == 0 {
+		return nil, nil
+	}
+	var result = &Fault{}
+	for _, f := range faults {
+		kind, value, found := strings.Cut(f, "=")
+		if !found {
+			return nil, fmt.Errorf("invalid fault %s, format is abort=503:10%% or delay=2s:5%%", f)
+		}
+		value, percentage, _ := strings.Cut(value, ":")
+		var percent uint64 = 100
+		if percentage != "" {
+			var err error
+			if percent, err = strconv.ParseUint(strings.TrimSuffix(percentage, "%"), 10, 32); err != nil || percent == 0 || percent > 100 {
+				return nil, fmt.Errorf("invalid percentage of fault %s, must be in range 1%%-100%%", f)
+			}
+		}
+		switch kind {
+		case FaultAbort:
+			status, err := strconv.ParseUint(value, 10, 32)
+			if err != nil || status < 200 || status >= 600 {
+				return nil, fmt.Errorf("invalid http status of fault %s", f)
+			}
+			result.AbortStatus, result.AbortPercent = uint32(status), uint32(percent)
+		case FaultDelay:
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return nil, fmt.Errorf("invalid delay of fault %s", f)
+			}
+			result.Delay, result.DelayPercent = &metav1.Duration{Duration: duration}, uint32(percent)
+		default:
+			return nil, fmt.Errorf("unknown fault type %s, only support %s and %s", kind, FaultAbort, FaultDelay)
+		}
+	}
+	return result, nil
+}
+
+func (f *Fault) String() string {
+	if f == nil {
+		return ""
+	}
+	var result []string
+	if f.AbortStatus != 0 {
+		result = append(result, fmt.Sprintf("%s=%d:%d%%", FaultAbort, f.AbortStatus, f.AbortPercent))
+	}
+	if f.Delay != nil {
+		result = append(result, fmt.Sprintf("%s=%s:%d%%", FaultDelay, f.Delay.Duration, f.DelayPercent))
+	}
+	return strings.Join(result, ",")
+}
+
+// ToHTTPFault per route config of fault filter, fault filter in http connection manager do nothing without it
+func ToHTTPFault(f *Fault) *faultv3.HTTPFault {
+	percentage := func(percent uint32) *typev3.FractionalPercent {
+		return &typev3.FractionalPercent{Numerator: percent, Denominator: typev3.FractionalPercent_HUNDRED}
+	}
+	var result = &faultv3.HTTPFault{}
+	if f.AbortStatus != 0 {
+		result.Abort = &faultv3.FaultAbort{
+			ErrorType:  &faultv3.FaultAbort_HttpStatus{HttpStatus: f.AbortStatus},
+			Percentage: percentage(f.AbortPercent),
+		}
+	}
+	if f.Delay != nil {
+		result.Delay = &faultcommonv3.FaultDelay{
+			FaultDelaySecifier: &faultcommonv3.FaultDelay_FixedDelay{FixedDelay: durationpb.New(f.Delay.Duration)},
+			Percentage:         percentage(f.DelayPercent),
+		}
+	}
+	return result
+}
@@ -3,22 +3,21 @@ package controlplane
 import (
 	"context"
 	"fmt"
+	"net/http"
+
 	"github.com/fsnotify/fsnotify"
 
 	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
 	log "github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
 )
 
-func Main(filename string, port uint, logger *log.Logger) {
-	snapshotCache := cache.NewSnapshotCache(false, cache.IDHash{}, logger)
-	proc := NewProcessor(snapshotCache, logger)
-
-	go func() {
-		ctx := context.Background()
-		server := serverv3.NewServer(ctx, snapshotCache, nil)
-		RunServer(ctx, server, port)
-	}()
+// Main watch envoy config from mounted file, useful for offline use
+func Main(filename string, port uint, accessLogPort uint, logger *log.Logger) {
+	proc := startServer(port, accessLogPort, logger)
 
 	notifyCh := make(chan NotifyMessage, 100)
 
@@ -46,3 +45,45 @@ func Main(filename string, port uint, logger *log.Logger) {
 		}
 	}
 }
+
+// MainConfigMap watch envoy config from configmap kubevpn-traffic-manager by informer,
+// not like mounted file which needs to wait kubelet sync period, route changes will take effect immediately
+func MainConfigMap(clientset kubernetes.Interface, namespace string, port uint, accessLogPort uint, logger *log.Logger) {
+	proc := startServer(port, accessLogPort, logger)
+
+	notifyCh := make(chan string, 100)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	WatchConfigMap(clientset, namespace, notifyCh, stopCh)
+
+	for {
+		select {
+		case content := <-notifyCh:
+			log.Infof("configmap %s in namespace %s changed", config.ConfigMapPodTrafficManager, namespace)
+			proc.ProcessContent(content)
+		}
+	}
+}
+
+// startServer start xds server and access log server
+func startServer(port uint, accessLogPort uint, logger *log.Logger) *Processor {
+	snapshotCache := cache.NewSnapshotCache(false, cache.IDHash{}, logger)
+	proc := NewProcessor(snapshotCache, logger)
+	als := NewAccessLogServer()
+
+	go func() {
+		ctx := context.Background()
+		server := serverv3.NewServer(ctx, snapshotCache, nil)
+		RunServer(ctx, server, als, port)
+	}()
+
+	go func() {
+		mux := http.NewServeMux()
+		mux.Handle(config.APIAccessLog, als)
+		log.Infof("access log server listening on %d", accessLogPort)
+		if err := http.ListenAndServe(fmt.Sprintf(":%d", accessLogPort), mux); err != nil {
+			log.Fatal(err)
+		}
+	}()
+	return proc
+}
@@ -0,0 +1,252 @@
+package controlplane
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	matcher "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	"k8s.io/apimachinery/pkg/util/sets"
+)
+
+// value format of headers and query parameters
+// a=1           --> exact match 1
+// a=exact:1     --> exact match 1, useful if value starts with prefix: or regex:
+// a=prefix:1    --> prefix match 1
+// a=regex:1.*   --> regex match 1.*, using RE2 syntax
+// a=present     --> header or query parameter a exists
+const (
+	MatchPrefix  = "prefix:"
+	MatchRegex   = "regex:"
+	MatchExact   = "exact:"
+	MatchPresent = "present"
+)
+
+func ToStringMatcher(v string) *matcher.StringMatcher {
+	switch {
+	case strings.HasPrefix(v, MatchPrefix):
+		return &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Prefix{Prefix: strings.TrimPrefix(v, MatchPrefix)},
+		}
+	case strings.HasPrefix(v, MatchRegex):
+		return &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_SafeRegex{SafeRegex: &matcher.RegexMatcher{
+				EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+				Regex:      strings.TrimPrefix(v, MatchRegex),
+			}},
+		}
+	default:
+		return &matcher.StringMatcher{
+			MatchPattern: &matcher.StringMatcher_Exact{Exact: strings.TrimPrefix(v, MatchExact)},
+		}
+	}
+}
+
+// ToHeaderMatchers sort by key, make sure same rule generate same route
+func ToHeaderMatchers(headers map[string]string) (result []*route.HeaderMatcher) {
+	for _, k := range sortedKeys(headers) {
+		if headers[k] == MatchPresent {
+			result = append(result, &route.HeaderMatcher{
+				Name:                 k,
+				HeaderMatchSpecifier: &route.HeaderMatcher_PresentMatch{PresentMatch: true},
+			})
+			continue
+		}
+		result = append(result, &route.HeaderMatcher{
+			Name:                 k,
+			HeaderMatchSpecifier: &route.HeaderMatcher_StringMatch{StringMatch: ToStringMatcher(headers[k])},
+		})
+	}
+	return
+}
+
+// HeaderBaggage W3C baggage header, format: key1=value1;property1,key2=value2
+const HeaderBaggage = "baggage"
+
+// ToBaggageMatchers every header must be an entry of baggage header
+func ToBaggageMatchers(headers map[string]string) (result []*route.HeaderMatcher) {
+	for _, k := range sortedKeys(headers) {
+		result = append(result, &route.HeaderMatcher{
+			Name: HeaderBaggage,
+			HeaderMatchSpecifier: &route.HeaderMatcher_StringMatch{StringMatch: &matcher.StringMatcher{
+				MatchPattern: &matcher.StringMatcher_SafeRegex{SafeRegex: &matcher.RegexMatcher{
+					EngineType: &matcher.RegexMatcher_GoogleRe2{GoogleRe2: &matcher.RegexMatcher_GoogleRE2{}},
+					Regex:      toBaggageRegex(k, headers[k]),
+				}},
+			}},
+		})
+	}
+	return
+}
+
+// toBaggageRegex regex of baggage entry, whole header value must be matched
+func toBaggageRegex(key, value string) string {
+	var v string
+	switch {
+	case value == MatchPresent:
+		v = `[^,;]*`
+	case strings.HasPrefix(value, MatchPrefix):
+		v = regexp.QuoteMeta(strings.TrimPrefix(value, MatchPrefix)) + `[^,;]*`
+	case strings.HasPrefix(value, MatchRegex):
+		v = "(?:" + strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(value, MatchRegex), "^"), "$") + ")"
+	default:
+		v = regexp.QuoteMeta(strings.TrimPrefix(value, MatchExact))
+	}
+	return `(?:.*,)?\s*` + regexp.QuoteMeta(key) + `\s*=\s*` + v + `\s*(?:;[^,]*)?(?:,.*)?`
+}
+
+func ToQueryParameterMatchers(params map[string]string) (result []*route.QueryParameterMatcher) {
+	for _, k := range sortedKeys(params) {
+		if params[k] == MatchPresent {
+			result = append(result, &route.QueryParameterMatcher{
+				Name:                         k,
+				QueryParameterMatchSpecifier: &route.QueryParameterMatcher_PresentMatch{PresentMatch: true},
+			})
+			continue
+		}
+		result = append(result, &route.QueryParameterMatcher{
+			Name:                         k,
+			QueryParameterMatchSpecifier: &route.QueryParameterMatcher_StringMatch{StringMatch: ToStringMatcher(params[k])},
+		})
+	}
+	return
+}
+
+// ToGrpcPath gRPC request path is /pkg.Service/Method, exact match one method, or prefix match all methods of service
+func ToGrpcPath(method string) (path string, exact bool) {
+	method = strings.TrimPrefix(method, "/")
+	if strings.Contains(method, "/") {
+		return "/" + method, true
+	}
+	return "/" + method + "/", false
+}
+
+// Validate check rule is valid or not before write it to configmap, otherwise envoy will reject whole route config
+func (r *Rule) Validate() error {
+	if r.PathPrefix != "" && !strings.HasPrefix(r.PathPrefix, "/") {
+		return fmt.Errorf("path prefix must starts with /, but got: %s", r.PathPrefix)
+	}
+	if r.GrpcMethod != "" {
+		if r.PathPrefix != "" {
+			return fmt.Errorf("path prefix and gRPC method can not be used together")
+		}
+		if parts := strings.Split(strings.TrimPrefix(r.GrpcMethod, "/"), "/"); len(parts) > 2 || parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+			return fmt.Errorf("gRPC method format is pkg.Service/Method or pkg.Service, but got: %s", r.GrpcMethod)
+		}
+	}
+	if len(r.SNI) != 0 {
+		if len(r.Headers) != 0 || r.PathPrefix != "" || len(r.QueryParams) != 0 || r.GrpcMethod != "" || r.Weight != 0 || r.Mirror {
+			return fmt.Errorf("sni route raw tls traffic, can not be used with http matchers, weight or mirror")
+		}
+		for _, name := range r.SNI {
+			if name == "" {
+				return fmt.Errorf("server name can not be empty")
+			}
+		}
+	}
+	if r.Fault != nil && (r.Mirror || r.Weight != 0 || len(r.SNI) != 0) {
+		return fmt.Errorf("fault can not be used with mirror, weight or sni, using percentage of fault instead")
+	}
+	if r.Fault != nil && !r.HasMatcher() {
+		return fmt.Errorf("fault without matcher will inject into all traffic of workload, add headers, path prefix, query params or gRPC method")
+	}
+	if r.AccessLog && !r.HasMatcher() && r.Weight == 0 && !r.Mirror && r.Fault == nil {
+		return fmt.Errorf("access log without matcher, weight or mirror will route all traffic of workload to local PC, add matcher to specify which traffic to route")
+	}
+	if r.Weight > 100 {
+		return fmt.Errorf("weight must be in range 0-100, but got: %d", r.Weight)
+	}
+	for _, m := range []map[string]string{r.Headers, r.QueryParams} {
+		for k, v := range m {
+			if k == "" {
+				return fmt.Errorf("name of matcher can not be empty")
+			}
+			if strings.HasPrefix(v, MatchRegex) {
+				if _, err := regexp.Compile(strings.TrimPrefix(v, MatchRegex)); err != nil {
+					return fmt.Errorf("invalid regex of %s, err: %v", k, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Conflict check traffic matched by this rule can be matched by other rule or not,
+// identical means two rules have same matchers, only first one will take effect,
+// overlap means some traffic can be matched by both rules, route result depends on order of rules
+func (r *Rule) Conflict(other *Rule) (identical bool, overlap bool) {
+	// raw tls traffic only conflict with raw tls traffic
+	if len(r.SNI) != 0 || len(other.SNI) != 0 {
+		a, b := sets.New[string](r.SNI...), sets.New[string](other.SNI...)
+		return a.Equal(b), a.HasAny(other.SNI...)
+	}
+	a, exactA := r.path()
+	b, exactB := other.path()
+	if a == b && exactA == exactB &&
+		equalMatchers(r.Headers, other.Headers) && equalMatchers(r.QueryParams, other.QueryParams) {
+		return true, true
+	}
+	switch {
+	case exactA && exactB && a != b,
+		exactA && !exactB && !strings.HasPrefix(a, b),
+		!exactA && exactB && !strings.HasPrefix(b, a),
+		!exactA && !exactB && !strings.HasPrefix(a, b) && !strings.HasPrefix(b, a):
+		return false, false
+	}
+	return false, !disjoint(r.Headers, other.Headers) && !disjoint(r.QueryParams, other.QueryParams)
+}
+
+// path request path matched by rule, gRPC method or path prefix
+func (r *Rule) path() (string, bool) {
+	if r.GrpcMethod != "" {
+		return ToGrpcPath(r.GrpcMethod)
+	}
+	return normalizePrefix(r.PathPrefix), false
+}
+
+func normalizePrefix(prefix string) string {
+	if prefix == "" {
+		return "/"
+	}
+	return prefix
+}
+
+func equalMatchers(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if value, ok := b[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// disjoint no value can match both, only sure if both are exact match with different value
+func disjoint(a, b map[string]string) bool {
+	isExact := func(v string) bool {
+		return v != MatchPresent && !strings.HasPrefix(v, MatchPrefix) && !strings.HasPrefix(v, MatchRegex)
+	}
+	for k, v := range a {
+		value, ok := b[k]
+		if !ok || !isExact(v) || !isExact(value) {
+			continue
+		}
+		if strings.TrimPrefix(v, MatchExact) != strings.TrimPrefix(value, MatchExact) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys = make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
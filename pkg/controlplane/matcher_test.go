@@ -0,0 +1,181 @@
+package controlplane
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	faultv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/fault/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+)
+
+func TestToRoute(t *testing.T) {
+	r := ToRoute("223.254.0.101_9080", &Rule{
+		Headers:     map[string]string{"b": "prefix:test-", "a": "1", "c": "regex:^v[0-9]+$", "d": "present", "e": "exact:prefix:x"},
+		PathPrefix:  "/api/v2",
+		QueryParams: map[string]string{"version": "2"},
+	})
+	if prefix := r.Match.GetPrefix(); prefix != "/api/v2" {
+		t.Fatalf("expect path prefix /api/v2, but got %s", prefix)
+	}
+	headers := r.Match.Headers
+	if len(headers) != 5 {
+		t.Fatalf("expect 5 header matchers, but got %d", len(headers))
+	}
+	// sorted by name
+	if headers[0].GetStringMatch().GetExact() != "1" {
+		t.Errorf("header a should be exact match")
+	}
+	if headers[1].GetStringMatch().GetPrefix() != "test-" {
+		t.Errorf("header b should be prefix match")
+	}
+	if headers[2].GetStringMatch().GetSafeRegex().GetRegex() != "^v[0-9]+$" {
+		t.Errorf("header c should be regex match")
+	}
+	if _, ok := headers[3].HeaderMatchSpecifier.(*route.HeaderMatcher_PresentMatch); !ok {
+		t.Errorf("header d should be present match")
+	}
+	if headers[4].GetStringMatch().GetExact() != "prefix:x" {
+		t.Errorf("header e should be exact match")
+	}
+	if len(r.Match.QueryParameters) != 1 || r.Match.QueryParameters[0].GetStringMatch().GetExact() != "2" {
+		t.Errorf("query parameter version should be exact match")
+	}
+}
+
+func TestRuleValidate(t *testing.T) {
+	for _, rule := range []*Rule{
+		{PathPrefix: "api"},
+		{Headers: map[string]string{"a": "regex:("}},
+		{QueryParams: map[string]string{"": "1"}},
+		{GrpcMethod: "pkg.Service/Method", PathPrefix: "/api"},
+		{GrpcMethod: "pkg.Service/Method/x"},
+		{GrpcMethod: "pkg.Service/"},
+		{SNI: []string{"a.example.com"}, Headers: map[string]string{"a": "1"}},
+		{Fault: &Fault{}},
+		{AccessLog: true},
+	} {
+		if err := rule.Validate(); err == nil {
+			t.Errorf("rule %v should be invalid", rule)
+		}
+	}
+	if err := (&Rule{Headers: map[string]string{"a": "1"}, PathPrefix: "/api"}).Validate(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestToRouteWeight(t *testing.T) {
+	r := ToRoute("223.254.0.101_9080", &Rule{Headers: map[string]string{"a": "1"}, Weight: 5})
+	clusters := r.GetRoute().GetWeightedClusters().GetClusters()
+	if len(clusters) != 2 {
+		t.Fatalf("expect 2 weighted clusters, but got %d", len(clusters))
+	}
+	if clusters[0].Name != "223.254.0.101_9080" || clusters[0].Weight.GetValue() != 5 {
+		t.Errorf("expect 5%% traffic to local, but got %v", clusters[0])
+	}
+	if clusters[1].Name != "origin_cluster" || clusters[1].Weight.GetValue() != 95 {
+		t.Errorf("expect 95%% traffic to origin, but got %v", clusters[1])
+	}
+	if c := ToRoute("223.254.0.101_9080", &Rule{}).GetRoute().GetCluster(); c != "223.254.0.101_9080" {
+		t.Errorf("expect all traffic to local, but got %s", c)
+	}
+}
+
+func TestToRouteMirror(t *testing.T) {
+	action := ToRoute("223.254.0.101_9080", &Rule{Mirror: true, Weight: 10}).GetRoute()
+	if action.GetCluster() != "origin_cluster" {
+		t.Errorf("expect origin cluster serve traffic, but got %s", action.GetCluster())
+	}
+	policies := action.GetRequestMirrorPolicies()
+	if len(policies) != 1 || policies[0].Cluster != "223.254.0.101_9080" {
+		t.Fatalf("expect mirror traffic to local, but got %v", policies)
+	}
+	if policies[0].GetRuntimeFraction().GetDefaultValue().GetNumerator() != 10 {
+		t.Errorf("expect mirror 10%% traffic, but got %v", policies[0].GetRuntimeFraction())
+	}
+}
+
+func TestRuleConflict(t *testing.T) {
+	for _, c := range []struct {
+		a, b               *Rule
+		identical, overlap bool
+	}{
+		{&Rule{Headers: map[string]string{"a": "1"}}, &Rule{Headers: map[string]string{"a": "1"}}, true, true},
+		{&Rule{Headers: map[string]string{"a": "1"}}, &Rule{Headers: map[string]string{"a": "2"}}, false, false},
+		{&Rule{Headers: map[string]string{"a": "1"}}, &Rule{Headers: map[string]string{"b": "1"}}, false, true},
+		{&Rule{Headers: map[string]string{"a": "1"}}, &Rule{}, false, true},
+		{&Rule{Headers: map[string]string{"a": "1"}}, &Rule{Headers: map[string]string{"a": "prefix:1"}}, false, true},
+		{&Rule{PathPrefix: "/api/v1"}, &Rule{PathPrefix: "/api/v2"}, false, false},
+		{&Rule{PathPrefix: "/api"}, &Rule{PathPrefix: "/api/v2"}, false, true},
+		{&Rule{GrpcMethod: "pkg.Service/Get"}, &Rule{GrpcMethod: "pkg.Service/GetAll"}, false, false},
+		{&Rule{GrpcMethod: "pkg.Service/Get"}, &Rule{GrpcMethod: "pkg.Service"}, false, true},
+		{&Rule{GrpcMethod: "pkg.Service/Get"}, &Rule{GrpcMethod: "/pkg.Service/Get"}, true, true},
+		{&Rule{GrpcMethod: "pkg.Service"}, &Rule{GrpcMethod: "pkg.ServiceV2"}, false, false},
+		{&Rule{SNI: []string{"a.example.com"}}, &Rule{}, false, false},
+		{&Rule{SNI: []string{"a.example.com"}}, &Rule{SNI: []string{"a.example.com", "b.example.com"}}, false, true},
+		{&Rule{SNI: []string{"a.example.com"}}, &Rule{SNI: []string{"a.example.com"}}, true, true},
+	} {
+		identical, overlap := c.a.Conflict(c.b)
+		if identical != c.identical || overlap != c.overlap {
+			t.Errorf("rule %v and %v, expect identical: %v, overlap: %v, but got: %v, %v", c.a, c.b, c.identical, c.overlap, identical, overlap)
+		}
+	}
+}
+
+func TestToBaggageMatchers(t *testing.T) {
+	matchers := ToBaggageMatchers(map[string]string{"a": "1", "b": "prefix:v", "c": "present"})
+	for _, baggage := range []string{"a=1,b=v2,c=x", "x=y, a = 1;p=q, b=v, c=", "c=1,b=v,a=1"} {
+		for _, m := range matchers {
+			if !regexp.MustCompile("^(?:" + m.GetStringMatch().GetSafeRegex().GetRegex() + ")$").MatchString(baggage) {
+				t.Errorf("baggage %s should match %s", baggage, m.GetStringMatch().GetSafeRegex().GetRegex())
+			}
+		}
+	}
+	regex := regexp.MustCompile("^(?:" + matchers[0].GetStringMatch().GetSafeRegex().GetRegex() + ")$")
+	for _, baggage := range []string{"a=11", "aa=1", "b=a=1", "x=1"} {
+		if regex.MatchString(baggage) {
+			t.Errorf("baggage %s should not match %s", baggage, regex)
+		}
+	}
+}
+
+func TestToRouteGrpcMethod(t *testing.T) {
+	r := ToRoute("223.254.0.101_9080", &Rule{GrpcMethod: "pkg.Service/Method", Headers: map[string]string{"user": "test"}})
+	if path := r.Match.GetPath(); path != "/pkg.Service/Method" {
+		t.Errorf("expect exact path /pkg.Service/Method, but got %s", path)
+	}
+	if r.Match.Grpc == nil {
+		t.Errorf("expect only match gRPC request")
+	}
+	r = ToRoute("223.254.0.101_9080", &Rule{GrpcMethod: "pkg.Service"})
+	if prefix := r.Match.GetPrefix(); prefix != "/pkg.Service/" {
+		t.Errorf("expect path prefix /pkg.Service/, but got %s", prefix)
+	}
+}
+
+func TestToRouteFault(t *testing.T) {
+	fault, err := ParseFault([]string{"abort=503:10%", "delay=2s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := ToRoute("223.254.0.101_9080", &Rule{Headers: map[string]string{"a": "1"}, Fault: fault})
+	if c := r.GetRoute().GetCluster(); c != "origin_cluster" {
+		t.Errorf("expect traffic go to origin cluster, but got %s", c)
+	}
+	var config faultv3.HTTPFault
+	if err = r.TypedPerFilterConfig[wellknown.Fault].UnmarshalTo(&config); err != nil {
+		t.Fatal(err)
+	}
+	if config.Abort.GetHttpStatus() != 503 || config.Abort.GetPercentage().GetNumerator() != 10 {
+		t.Errorf("expect 10%% abort with 503, but got %v", config.Abort)
+	}
+	if config.Delay.GetFixedDelay().AsDuration() != 2*time.Second || config.Delay.GetPercentage().GetNumerator() != 100 {
+		t.Errorf("expect all delay 2s, but got %v", config.Delay)
+	}
+	for _, f := range []string{"abort=503:0%", "abort=99", "delay=-1s", "delay", "reset=1"} {
+		if _, err = ParseFault([]string{f}); err == nil {
+			t.Errorf("fault %s should be invalid", f)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"math"
 	"math/rand"
 	"os"
+	"reflect"
 	"strconv"
 
 	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
@@ -19,6 +20,9 @@ type Processor struct {
 	cache   cache.SnapshotCache
 	logger  *logrus.Logger
 	version int64
+	// expect config of each node last processed, only set snapshot for node which config changed,
+	// avoid envoy of other nodes reload config
+	expect map[string]*Virtual
 }
 
 func NewProcessor(cache cache.SnapshotCache, log *logrus.Logger) *Processor {
@@ -26,6 +30,7 @@ func NewProcessor(cache cache.SnapshotCache, log *logrus.Logger) *Processor {
 		cache:   cache,
 		logger:  log,
 		version: rand.Int63n(1000),
+		expect:  make(map[string]*Virtual),
 	}
 }
 
@@ -43,36 +48,74 @@ func (p *Processor) ProcessFile(file NotifyMessage) {
 		p.logger.Errorf("error parsing yaml file: %+v", err)
 		return
 	}
+	p.ProcessVirtuals(configList)
+}
+
+// ProcessContent process content of configmap key ENVOY_CONFIG
+func (p *Processor) ProcessContent(content string) {
+	var configList = make([]*Virtual, 0)
+	if err := yaml.Unmarshal([]byte(content), &configList); err != nil {
+		p.logger.Errorf("error parsing yaml content: %+v", err)
+		return
+	}
+	p.ProcessVirtuals(configList)
+}
+
+func (p *Processor) ProcessVirtuals(configList []*Virtual) {
+	var nodes = make(map[string]struct{})
 	for _, config := range configList {
 		if len(config.Uid) == 0 {
 			continue
 		}
-		listeners, clusters, routes, endpoints := config.To()
-		resources := map[resource.Type][]types.Resource{
-			resource.ListenerType: listeners, // listeners
-			resource.RouteType:    routes,    // routes
-			resource.ClusterType:  clusters,  // clusters
-			resource.EndpointType: endpoints, // endpoints
-			resource.RuntimeType:  {},        // runtimes
-			resource.SecretType:   {},        // secrets
+		nodeID := config.NodeID()
+		nodes[nodeID] = struct{}{}
+		if reflect.DeepEqual(p.expect[nodeID], config) {
+			continue
 		}
-		snapshot, err := cache.NewSnapshot(p.newVersion(), resources)
-
-		if err != nil {
-			p.logger.Errorf("snapshot inconsistency: %v, err: %v", snapshot, err)
-			return
+		listeners, clusters, routes, endpoints := config.To()
+		if err := p.setSnapshot(nodeID, listeners, clusters, routes, endpoints); err != nil {
+			continue
 		}
+		p.expect[nodeID] = config
+	}
 
-		if err = snapshot.Consistent(); err != nil {
-			p.logger.Errorf("snapshot inconsistency: %v, err: %v", snapshot, err)
-			return
+	// node was removed from config, clean up all resources, traffic will go to default listener
+	for nodeID := range p.expect {
+		if _, ok := nodes[nodeID]; ok {
+			continue
 		}
-		p.logger.Debugf("will serve snapshot %+v, nodeID: %s", snapshot, config.Uid)
-		if err = p.cache.SetSnapshot(context.Background(), config.Uid, snapshot); err != nil {
-			p.logger.Errorf("snapshot error %q for %v", err, snapshot)
-			p.logger.Fatal(err)
+		if err := p.setSnapshot(nodeID, nil, nil, nil, nil); err != nil {
+			continue
 		}
+		delete(p.expect, nodeID)
+	}
+}
+
+func (p *Processor) setSnapshot(nodeID string, listeners, clusters, routes, endpoints []types.Resource) error {
+	resources := map[resource.Type][]types.Resource{
+		resource.ListenerType: listeners, // listeners
+		resource.RouteType:    routes,    // routes
+		resource.ClusterType:  clusters,  // clusters
+		resource.EndpointType: endpoints, // endpoints
+		resource.RuntimeType:  {},        // runtimes
+	}
+	snapshot, err := cache.NewSnapshot(p.newVersion(), resources)
+
+	if err != nil {
+		p.logger.Errorf("snapshot inconsistency: %v, err: %v", snapshot, err)
+		return err
+	}
+
+	if err = snapshot.Consistent(); err != nil {
+		p.logger.Errorf("snapshot inconsistency: %v, err: %v", snapshot, err)
+		return err
+	}
+	p.logger.Debugf("will serve snapshot %+v, nodeID: %s", snapshot, nodeID)
+	if err = p.cache.SetSnapshot(context.Background(), nodeID, snapshot); err != nil {
+		p.logger.Errorf("snapshot error %q for %v", err, snapshot)
+		p.logger.Fatal(err)
 	}
+	return nil
 }
 
 func ParseYaml(file string) ([]*Virtual, error) {
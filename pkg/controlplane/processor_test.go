@@ -0,0 +1,116 @@
+package controlplane
+
+import (
+	"testing"
+
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	"github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
+func TestProcessVirtualsOnlyChangedNode(t *testing.T) {
+	snapshotCache := cache.NewSnapshotCache(false, cache.IDHash{}, logrus.StandardLogger())
+	proc := NewProcessor(snapshotCache, logrus.StandardLogger())
+	newVirtual := func(uid string, headers map[string]string) *Virtual {
+		return &Virtual{
+			Uid:   uid,
+			Ports: []corev1.ContainerPort{{ContainerPort: 9080, Protocol: corev1.ProtocolTCP}},
+			Rules: []*Rule{{Headers: headers, LocalTunIP: "223.254.0.101"}},
+		}
+	}
+	version := func(node string) string {
+		snapshot, err := snapshotCache.GetSnapshot(node)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return snapshot.GetVersion(resource.RouteType)
+	}
+
+	proc.ProcessVirtuals([]*Virtual{newVirtual("deployments.apps.a", map[string]string{"a": "1"}), newVirtual("deployments.apps.b", map[string]string{"b": "1"})})
+	a, b := version("deployments.apps.a"), version("deployments.apps.b")
+
+	proc.ProcessVirtuals([]*Virtual{newVirtual("deployments.apps.a", map[string]string{"a": "2"}), newVirtual("deployments.apps.b", map[string]string{"b": "1"})})
+	if version("deployments.apps.a") == a {
+		t.Errorf("version of node a should be changed")
+	}
+	if version("deployments.apps.b") != b {
+		t.Errorf("version of node b should not be changed")
+	}
+
+	proc.ProcessVirtuals([]*Virtual{newVirtual("deployments.apps.a", map[string]string{"a": "2"})})
+	snapshot, err := snapshotCache.GetSnapshot("deployments.apps.b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.GetResources(resource.ListenerType)) != 0 {
+		t.Errorf("listeners of removed node b should be cleaned up")
+	}
+}
+
+func TestProcessVirtualsTLS(t *testing.T) {
+	snapshotCache := cache.NewSnapshotCache(false, cache.IDHash{}, logrus.StandardLogger())
+	proc := NewProcessor(snapshotCache, logrus.StandardLogger())
+	proc.ProcessVirtuals([]*Virtual{{
+		Uid:   "deployments.apps.a",
+		Ports: []corev1.ContainerPort{{ContainerPort: 9443, Protocol: corev1.ProtocolTCP}},
+		Rules: []*Rule{
+			{Headers: map[string]string{"a": "1"}, LocalTunIP: "223.254.0.101", TLSSecret: "a-tls"},
+			{SNI: []string{"a.example.com"}, LocalTunIP: "223.254.0.102"},
+		},
+	}})
+	snapshot, err := snapshotCache.GetSnapshot("deployments.apps.a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := snapshot.GetResources(resource.RouteType)["deployments.apps.a_9443_TCP_tls"]; !ok {
+		t.Errorf("expect route of tls termination")
+	}
+	if _, ok := snapshot.GetResources(resource.ClusterType)["origin_cluster_tls"]; !ok {
+		t.Errorf("expect origin cluster re-encrypt traffic")
+	}
+	l := snapshot.GetResources(resource.ListenerType)["deployments.apps.a_9443_TCP"].(*listener.Listener)
+	// http, sni, tls termination, tcp proxy
+	if len(l.FilterChains) != 4 {
+		t.Fatalf("expect 4 filter chains, but got %d", len(l.FilterChains))
+	}
+	if names := l.FilterChains[1].FilterChainMatch.ServerNames; len(names) != 1 || names[0] != "a.example.com" {
+		t.Errorf("expect sni filter chain, but got %v", names)
+	}
+	// certificate is mounted into envoy-proxy sidecar, never sent by control-plane
+	tlsContext := &tlsv3.DownstreamTlsContext{}
+	if err = l.FilterChains[2].TransportSocket.GetTypedConfig().UnmarshalTo(tlsContext); err != nil {
+		t.Fatal(err)
+	}
+	certificates := tlsContext.GetCommonTlsContext().GetTlsCertificates()
+	if len(certificates) != 1 || certificates[0].GetWatchedDirectory().GetPath() != config.TLSSecretMountPath {
+		t.Errorf("expect certificate in mounted tls secret, but got %v", certificates)
+	}
+}
+
+func TestProcessVirtualsKeyedByNamespace(t *testing.T) {
+	snapshotCache := cache.NewSnapshotCache(false, cache.IDHash{}, logrus.StandardLogger())
+	proc := NewProcessor(snapshotCache, logrus.StandardLogger())
+	newVirtual := func(namespace, localTunIP string) *Virtual {
+		return &Virtual{
+			Uid:       "deployments.apps.a",
+			Namespace: namespace,
+			Ports:     []corev1.ContainerPort{{ContainerPort: 9080, Protocol: corev1.ProtocolTCP}},
+			Rules:     []*Rule{{Headers: map[string]string{"a": "1"}, LocalTunIP: localTunIP}},
+		}
+	}
+	proc.ProcessVirtuals([]*Virtual{newVirtual("team-a", "223.254.0.101"), newVirtual("team-b", "223.254.0.102")})
+	for _, node := range []string{"deployments.apps.a.team-a", "deployments.apps.a.team-b"} {
+		snapshot, err := snapshotCache.GetSnapshot(node)
+		if err != nil {
+			t.Fatalf("workload in each namespace should has its own node %s, err: %v", node, err)
+		}
+		if len(snapshot.GetResources(resource.ListenerType)) == 0 {
+			t.Errorf("listeners of node %s should not be empty", node)
+		}
+	}
+}
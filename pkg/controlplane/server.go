@@ -6,6 +6,7 @@ import (
 	"log"
 	"net"
 
+	accesslogservice "github.com/envoyproxy/go-control-plane/envoy/service/accesslog/v3"
 	clusterservice "github.com/envoyproxy/go-control-plane/envoy/service/cluster/v3"
 	discoverygrpc "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	endpointservice "github.com/envoyproxy/go-control-plane/envoy/service/endpoint/v3"
@@ -21,7 +22,7 @@ const (
 	grpcMaxConcurrentStreams = 1000000
 )
 
-func RunServer(ctx context.Context, server serverv3.Server, port uint) {
+func RunServer(ctx context.Context, server serverv3.Server, als accesslogservice.AccessLogServiceServer, port uint) {
 	grpcServer := grpc.NewServer(grpc.MaxConcurrentStreams(grpcMaxConcurrentStreams))
 
 	var lc net.ListenConfig
@@ -37,6 +38,7 @@ func RunServer(ctx context.Context, server serverv3.Server, port uint) {
 	listenerservice.RegisterListenerDiscoveryServiceServer(grpcServer, server)
 	secretservice.RegisterSecretDiscoveryServiceServer(grpcServer, server)
 	runtimeservice.RegisterRuntimeDiscoveryServiceServer(grpcServer, server)
+	accesslogservice.RegisterAccessLogServiceServer(grpcServer, als)
 
 	log.Printf("management server listening on %d\n", port)
 	if err = grpcServer.Serve(listener); err != nil {
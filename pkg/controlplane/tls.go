@@ -0,0 +1,122 @@
+package controlplane
+
+import (
+	"path"
+
+	cluster "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listener "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	route "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	tcpproxy "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/wellknown"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
+// TLSClusterSuffix cluster with this suffix re-encrypt traffic to upstream after tls terminated by envoy
+const TLSClusterSuffix = "_tls"
+
+// TLSSecret first tls secret of rules, tls is terminated per listener, so only one secret can take effect
+func (a *Virtual) TLSSecret() string {
+	for _, rule := range SortRules(a.Rules) {
+		if rule.TLSSecret != "" {
+			return rule.TLSSecret
+		}
+	}
+	return ""
+}
+
+// ToSNIFilterChain route raw tls traffic with special server names to local PC, tls is not terminated
+func ToSNIFilterChain(clusterName string, serverNames []string) *listener.FilterChain {
+	anyFunc := func(m proto.Message) *anypb.Any {
+		pbst, _ := anypb.New(m)
+		return pbst
+	}
+	return &listener.FilterChain{
+		Name: clusterName + "_sni",
+		FilterChainMatch: &listener.FilterChainMatch{
+			ServerNames:       serverNames,
+			TransportProtocol: "tls",
+		},
+		Filters: []*listener.Filter{{
+			Name: wellknown.TCPProxy,
+			ConfigType: &listener.Filter_TypedConfig{
+				TypedConfig: anyFunc(&tcpproxy.TcpProxy{
+					StatPrefix:       "sni",
+					ClusterSpecifier: &tcpproxy.TcpProxy_Cluster{Cluster: clusterName},
+				}),
+			},
+		}},
+	}
+}
+
+// ToDownstreamTLSContext terminate tls with kubernetes secret of workloads mounted into envoy-proxy sidecar,
+// envoy watches mount directory, so rotated secret takes effect without config change
+func ToDownstreamTLSContext() *core.TransportSocket {
+	tlsContext, _ := anypb.New(&tlsv3.DownstreamTlsContext{
+		CommonTlsContext: &tlsv3.CommonTlsContext{
+			AlpnProtocols: []string{"h2", "http/1.1"},
+			TlsCertificates: []*tlsv3.TlsCertificate{{
+				CertificateChain: &core.DataSource{
+					Specifier: &core.DataSource_Filename{Filename: path.Join(config.TLSSecretMountPath, corev1.TLSCertKey)},
+				},
+				PrivateKey: &core.DataSource{
+					Specifier: &core.DataSource_Filename{Filename: path.Join(config.TLSSecretMountPath, corev1.TLSPrivateKeyKey)},
+				},
+				// kubelet updates secret volume by swapping symlink ..data in mount directory
+				WatchedDirectory: &core.WatchedDirectory{Path: config.TLSSecretMountPath},
+			}},
+		},
+	})
+	return &core.TransportSocket{
+		Name:       wellknown.TransportSocketTls,
+		ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: tlsContext},
+	}
+}
+
+// ToUpstreamTLSContext re-encrypt traffic to upstream, certificate of upstream is not verified,
+// local PC usually use self-signed certificate
+func ToUpstreamTLSContext() *core.TransportSocket {
+	tlsContext, _ := anypb.New(&tlsv3.UpstreamTlsContext{
+		CommonTlsContext: &tlsv3.CommonTlsContext{
+			AlpnProtocols: []string{"h2", "http/1.1"},
+		},
+	})
+	return &core.TransportSocket{
+		Name:       wellknown.TransportSocketTls,
+		ConfigType: &core.TransportSocket_TypedConfig{TypedConfig: tlsContext},
+	}
+}
+
+// ToTLSCluster same as cluster, but re-encrypt traffic to upstream, share endpoints with origin cluster
+func ToTLSCluster(c *cluster.Cluster) *cluster.Cluster {
+	if c.EdsClusterConfig != nil {
+		c.EdsClusterConfig.ServiceName = c.Name
+	}
+	c.Name = c.Name + TLSClusterSuffix
+	c.TransportSocket = ToUpstreamTLSContext()
+	return c
+}
+
+// ToTLSRoute same as route, but all clusters are replaced by re-encrypt clusters
+func ToTLSRoute(r *route.Route) *route.Route {
+	r = proto.Clone(r).(*route.Route)
+	action := r.GetRoute()
+	if action == nil {
+		return r
+	}
+	if c := action.GetCluster(); c != "" {
+		action.ClusterSpecifier = &route.RouteAction_Cluster{Cluster: c + TLSClusterSuffix}
+	}
+	for _, weighted := range action.GetWeightedClusters().GetClusters() {
+		weighted.Name = weighted.Name + TLSClusterSuffix
+	}
+	for _, policy := range action.GetRequestMirrorPolicies() {
+		policy.Cluster = policy.Cluster + TLSClusterSuffix
+	}
+	return r
+}
@@ -5,6 +5,14 @@ import (
 	"time"
 
 	"github.com/fsnotify/fsnotify"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
 )
 
 type OperationType int
@@ -58,3 +66,32 @@ func Watch(watcher *fsnotify.Watcher, filename string, notifyCh chan<- NotifyMes
 		}
 	}
 }
+
+// WatchConfigMap using informer to watch configmap kubevpn-traffic-manager, send content of key ENVOY_CONFIG to notifyCh
+func WatchConfigMap(clientset kubernetes.Interface, namespace string, notifyCh chan<- string, stopCh <-chan struct{}) {
+	factory := informers.NewSharedInformerFactoryWithOptions(clientset, 0,
+		informers.WithNamespace(namespace),
+		informers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.FieldSelector = fields.OneTermEqualSelector("metadata.name", config.ConfigMapPodTrafficManager).String()
+		}),
+	)
+	notify := func(obj interface{}) {
+		if cm, ok := obj.(*corev1.ConfigMap); ok {
+			notifyCh <- cm.Data[config.KeyEnvoy]
+		}
+	}
+	informer := factory.Core().V1().ConfigMaps().Informer()
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: notify,
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldCM, ok1 := oldObj.(*corev1.ConfigMap)
+			newCM, ok2 := newObj.(*corev1.ConfigMap)
+			if ok1 && ok2 && oldCM.Data[config.KeyEnvoy] == newCM.Data[config.KeyEnvoy] {
+				return
+			}
+			notify(newObj)
+		},
+	})
+	factory.Start(stopCh)
+	factory.WaitForCacheSync(stopCh)
+}
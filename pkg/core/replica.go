@@ -0,0 +1,184 @@
+package core
+
+import (
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
+// replicaRouteTTL routes learned from replica links expire if no packet comes from them, destination may register
+// with another replica after reconnecting
+const replicaRouteTTL = 10 * time.Second
+
+// ReplicaHandler handle links from other replicas of traffic manager, same as TCPHandler, but packets from replica
+// links are never forwarded to other replicas
+func ReplicaHandler() Handler {
+	return &fakeUdpHandler{
+		nat:     RouteNAT,
+		replica: true,
+	}
+}
+
+func seenKey(ip string, addr net.Addr) string {
+	return ip + "|" + addr.String()
+}
+
+// AddReplica mark addr as replica link, packets without route are flooded to links dialed by this replica
+func (n *NAT) AddReplica(addr net.Addr, dialed bool) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	n.replicas[addr.String()] = dialed
+}
+
+func (n *NAT) RemoveReplica(addr net.Addr) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	delete(n.replicas, addr.String())
+}
+
+func (n *NAT) IsReplica(addr net.Addr) bool {
+	if addr == nil {
+		return false
+	}
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	_, ok := n.replicas[addr.String()]
+	return ok
+}
+
+// ReplicaLinks links dialed by this replica, one for each other replica
+func (n *NAT) ReplicaLinks() (links []net.Addr) {
+	n.lock.RLock()
+	defer n.lock.RUnlock()
+	for addr, dialed := range n.replicas {
+		if dialed {
+			if udpAddr, err := net.ResolveUDPAddr("udp", addr); err == nil {
+				links = append(links, udpAddr)
+			}
+		}
+	}
+	return
+}
+
+// ReapReplicaRoutes remove routes learned from replica links which no packet comes from in ttl
+func (n *NAT) ReapReplicaRoutes(ttl time.Duration) (count int) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for key, t := range n.seen {
+		if time.Since(t) < ttl {
+			continue
+		}
+		delete(n.seen, key)
+		ip, addr, _ := strings.Cut(key, "|")
+		addrList := n.routes[ip]
+		for i := 0; i < len(addrList); i++ {
+			if addrList[i].String() == addr {
+				addrList = append(addrList[:i], addrList[i+1:]...)
+				i--
+				count++
+			}
+		}
+		n.routes[ip] = addrList
+	}
+	return
+}
+
+// flood write packet to all other replicas, replica which destination registered with delivers it, and route of
+// destination is learned from reply
+func (n *NAT) flood(conn net.PacketConn, data []byte) error {
+	for _, addr := range n.ReplicaLinks() {
+		if _, err := conn.WriteTo(data, addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shouldFlood only packets to peers of tunnel may be registered with other replicas
+func shouldFlood(dst net.IP) bool {
+	return config.CIDR.Contains(dst) && !dst.Equal(config.RouterIP)
+}
+
+// KeepReplicaLinks link to all other replicas of traffic manager discovered periodically, until ctx is done
+func KeepReplicaLinks(ctx context.Context, discover func(context.Context) ([]string, error)) {
+	links := make(map[string]context.CancelFunc)
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		addrs, err := discover(ctx)
+		if err != nil {
+			log.Warnf("[replica] discover replicas failed: %v", err)
+		} else {
+			alive := make(map[string]bool, len(addrs))
+			for _, addr := range addrs {
+				alive[addr] = true
+				if _, ok := links[addr]; !ok {
+					linkCtx, cancelFunc := context.WithCancel(ctx)
+					links[addr] = cancelFunc
+					go dialReplica(linkCtx, addr)
+				}
+			}
+			for addr, cancelFunc := range links {
+				if !alive[addr] {
+					cancelFunc()
+					delete(links, addr)
+				}
+			}
+		}
+		if count := RouteNAT.ReapReplicaRoutes(replicaRouteTTL); count != 0 {
+			log.Debugf("[replica] reap %d expired routes", count)
+		}
+		select {
+		case <-ctx.Done():
+			for _, cancelFunc := range links {
+				cancelFunc()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dialReplica keep link to replica, redial if disconnected
+func dialReplica(ctx context.Context, addr string) {
+	for ctx.Err() == nil {
+		if err := linkReplica(ctx, addr); err != nil {
+			log.Debugf("[replica] link to %s: %v", addr, err)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(2 * time.Second):
+		}
+	}
+}
+
+func linkReplica(ctx context.Context, addr string) error {
+	tcpConn, err := (&net.Dialer{Timeout: 5 * time.Second}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer tcpConn.Close()
+	udpConn, err := net.DialUDP("udp", nil, Server8422)
+	if err != nil {
+		return err
+	}
+	defer udpConn.Close()
+
+	RouteNAT.AddReplica(udpConn.LocalAddr(), true)
+	defer RouteNAT.RemoveAddr(udpConn.LocalAddr())
+	defer RouteNAT.RemoveReplica(udpConn.LocalAddr())
+
+	go func() {
+		<-ctx.Done()
+		_ = tcpConn.Close()
+	}()
+	log.Infof("[replica] link %s <-> %s", udpConn.LocalAddr(), addr)
+	err = relay(tcpConn, udpConn)
+	log.Infof("[replica] link %s >-< %s", udpConn.LocalAddr(), addr)
+	return err
+}
@@ -0,0 +1,39 @@
+package core
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestNATReplicaRoutes(t *testing.T) {
+	nat := NewNAT()
+	direct := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 10001}
+	stale := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 10002}
+	latest := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 10003}
+	nat.AddReplica(stale, true)
+	nat.AddReplica(latest, false)
+
+	ip := net.ParseIP("223.254.0.101")
+	nat.LoadOrStore(ip, stale)
+	time.Sleep(10 * time.Millisecond)
+	nat.LoadOrStore(ip, latest)
+	if addr := nat.RouteTo(ip); addr.String() != latest.String() {
+		t.Errorf("expect route to replica %s which packets come from recently, but got %s", latest, addr)
+	}
+	nat.LoadOrStore(ip, direct)
+	if addr := nat.RouteTo(ip); addr.String() != direct.String() {
+		t.Errorf("expect route to peer registered with this replica, but got %s", addr)
+	}
+	if links := nat.ReplicaLinks(); len(links) != 1 || links[0].String() != stale.String() {
+		t.Errorf("expect only dialed replica link, but got %v", links)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if count := nat.ReapReplicaRoutes(5 * time.Millisecond); count != 2 {
+		t.Errorf("expect reap 2 replica routes, but reaped %d", count)
+	}
+	if addr := nat.RouteTo(ip); addr.String() != direct.String() {
+		t.Errorf("route to peer registered with this replica should not be reaped, but got %v", addr)
+	}
+}
@@ -17,6 +17,7 @@ var (
 
 // Route example:
 // -L "tcp://:10800" -L "tun://:8422?net=223.254.0.100/16"
+// -L "tcp://:10800" -L "tun://:8422?net=223.254.0.100/16" -L "replica://:10801"
 // -L "tun:/10.233.24.133:8422?net=223.254.0.102/16&route=223.254.0.0/16"
 // -L "tun:/127.0.0.1:8422?net=223.254.0.102/16&route=223.254.0.0/16,10.233.0.0/16" -F "tcp://127.0.0.1:10800"
 type Route struct {
@@ -76,6 +77,12 @@ func (r *Route) GenerateServers() ([]Server, error) {
 			if err != nil {
 				return nil, err
 			}
+		case "replica":
+			handler = ReplicaHandler()
+			ln, err = TCPListener(node.Addr)
+			if err != nil {
+				return nil, err
+			}
 		default:
 			handler = TCPHandler()
 			ln, err = TCPListener(node.Addr)
@@ -40,6 +40,8 @@ func (c *fakeUDPTunnelConnector) ConnectContext(ctx context.Context, conn net.Co
 
 type fakeUdpHandler struct {
 	nat *NAT
+	// replica link from other replica of traffic manager
+	replica bool
 }
 
 func TCPHandler() Handler {
@@ -64,8 +66,22 @@ func (h *fakeUdpHandler) Handle(ctx context.Context, tcpConn net.Conn) {
 		n := h.nat.RemoveAddr(addr)
 		log.Debugf("delete addr %s from globle route, deleted count %d", addr, n)
 	}(udpConn.LocalAddr())
+	if h.replica {
+		h.nat.AddReplica(udpConn.LocalAddr(), false)
+		defer h.nat.RemoveReplica(udpConn.LocalAddr())
+	}
 
 	log.Debugf("[tcpserver] udp-tun %s <-> %s", tcpConn.RemoteAddr(), udpConn.LocalAddr())
+	err = relay(tcpConn, udpConn)
+	if err != nil {
+		log.Error(err)
+	}
+	log.Debugf("[tcpserver] udp-tun %s >-< %s", tcpConn.RemoteAddr(), udpConn.LocalAddr())
+	return
+}
+
+// relay pipe datagram packets between tcp connection and udp connection to tun server, until one of them is closed
+func relay(tcpConn net.Conn, udpConn *net.UDPConn) error {
 	errChan := make(chan error, 2)
 	go func() {
 		b := config.LPool.Get().([]byte)
@@ -110,12 +126,7 @@ func (h *fakeUdpHandler) Handle(ctx context.Context, tcpConn net.Conn) {
 			log.Debugf("[tcpserver] udp-tun %s <<< %s length: %d", tcpConn.RemoteAddr(), dgram.Addr(), len(dgram.Data))
 		}
 	}()
-	err = <-errChan
-	if err != nil {
-		log.Error(err)
-	}
-	log.Debugf("[tcpserver] udp-tun %s >-< %s", tcpConn.RemoteAddr(), udpConn.LocalAddr())
-	return
+	return <-errChan
 }
 
 // fake udp connect over tcp
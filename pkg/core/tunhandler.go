@@ -33,12 +33,18 @@ type tunHandler struct {
 type NAT struct {
 	lock   *sync.RWMutex
 	routes map[string][]net.Addr
+	// replicas links to other replicas of traffic manager, value is true if it is dialed by this replica
+	replicas map[string]bool
+	// seen last time of packet from ip via replica link, key is ip and address of link
+	seen map[string]time.Time
 }
 
 func NewNAT() *NAT {
 	return &NAT{
-		lock:   &sync.RWMutex{},
-		routes: map[string][]net.Addr{},
+		lock:     &sync.RWMutex{},
+		routes:   map[string][]net.Addr{},
+		replicas: map[string]bool{},
+		seen:     map[string]time.Time{},
 	}
 }
 
@@ -54,6 +60,7 @@ func (n *NAT) RemoveAddr(addr net.Addr) (count int) {
 			}
 		}
 		n.routes[k] = v
+		delete(n.seen, seenKey(k, addr))
 	}
 	return
 }
@@ -61,6 +68,9 @@ func (n *NAT) RemoveAddr(addr net.Addr) (count int) {
 func (n *NAT) LoadOrStore(to net.IP, addr net.Addr) (result net.Addr, load bool) {
 	n.lock.Lock()
 	defer n.lock.Unlock()
+	if _, ok := n.replicas[addr.String()]; ok {
+		n.seen[seenKey(to.String(), addr)] = time.Now()
+	}
 	addrList := n.routes[to.String()]
 	for _, add := range addrList {
 		if add.String() == addr.String() {
@@ -88,9 +98,23 @@ func (n *NAT) RouteTo(ip net.IP) net.Addr {
 	if len(addrList) == 0 {
 		return nil
 	}
+	// prefer peers registered with this replica, otherwise replica link which packets from ip come from recently
+	var direct []net.Addr
+	var latest net.Addr
+	var latestSeen time.Time
+	for _, addr := range addrList {
+		if _, ok := n.replicas[addr.String()]; !ok {
+			direct = append(direct, addr)
+		} else if t := n.seen[seenKey(ip.String(), addr)]; latest == nil || t.After(latestSeen) {
+			latest, latestSeen = addr, t
+		}
+	}
+	if len(direct) == 0 {
+		return latest
+	}
 	// for load balance
-	index := rand.Intn(len(n.routes[ip.String()]))
-	return addrList[index]
+	index := rand.Intn(len(direct))
+	return direct[index]
 }
 
 func (n *NAT) Remove(ip net.IP, addr net.Addr) {
@@ -108,6 +132,7 @@ func (n *NAT) Remove(ip net.IP, addr net.Addr) {
 		}
 	}
 	n.routes[ip.String()] = addrList
+	delete(n.seen, seenKey(ip.String(), addr))
 	return
 }
 
@@ -434,7 +459,13 @@ func (p *Peer) parseHeader() {
 
 func (p *Peer) route() {
 	for e := range p.parsedConnInfo {
+		fromReplica := p.routes.IsReplica(e.from)
 		if routeToAddr := p.routes.RouteTo(e.dst); routeToAddr != nil {
+			// never forward packets between replicas, replica which destination registered with delivers it
+			if fromReplica && p.routes.IsReplica(routeToAddr) {
+				config.LPool.Put(e.data[:])
+				continue
+			}
 			log.Debugf("[tun] find route: %s -> %s", e.dst, routeToAddr)
 			_, err := p.conn.WriteTo(e.data[:e.length], routeToAddr)
 			config.LPool.Put(e.data[:])
@@ -442,6 +473,13 @@ func (p *Peer) route() {
 				p.sendErr(err)
 				return
 			}
+		} else if !fromReplica && shouldFlood(e.dst) {
+			err := p.routes.flood(p.conn, e.data[:e.length])
+			config.LPool.Put(e.data[:])
+			if err != nil {
+				p.sendErr(err)
+				return
+			}
 		} else {
 			if !p.tun.closed.Load() {
 				p.tun.tunOutbound <- &DataElem{
@@ -495,6 +533,15 @@ func (h *tunHandler) transportTun(ctx context.Context, tun *Device, conn net.Pac
 			}
 
 			addr := h.routes.RouteTo(e.dst)
+			if addr == nil && shouldFlood(e.dst) {
+				err := h.routes.flood(conn, e.data[:e.length])
+				config.LPool.Put(e.data[:])
+				if err != nil {
+					errChan <- err
+					return
+				}
+				continue
+			}
 			if addr == nil {
 				config.LPool.Put(e.data[:])
 				log.Debug(fmt.Errorf("[tun] no route for %s -> %s", e.src, e.dst))
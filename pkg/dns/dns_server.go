@@ -5,6 +5,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"net"
 	"os"
 	"strings"
 	"sync"
@@ -14,20 +16,86 @@ import (
 	miekgdns "github.com/miekg/dns"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/cache"
+	"k8s.io/apimachinery/pkg/util/wait"
 )
 
 type server struct {
 	dnsCache   *cache.LRUExpireCache
 	forwardDNS *miekgdns.ClientConfig
-	c          *miekgdns.Client
+	// udp is used first, tcp is only used if answer is truncated
+	udp *miekgdns.Client
+	tcp *miekgdns.Client
 }
 
-func NewDNSServer(network, address string, forwardDNS *miekgdns.ClientConfig) error {
-	return miekgdns.ListenAndServe(address, network, &server{
+func newServer(forwardDNS *miekgdns.ClientConfig) *server {
+	return &server{
 		dnsCache:   cache.NewLRUExpireCache(1000),
 		forwardDNS: forwardDNS,
-		c:          &miekgdns.Client{Net: "udp", SingleInflight: false},
-	})
+		udp:        &miekgdns.Client{Net: "udp", Timeout: time.Second * 30, SingleInflight: false},
+		tcp:        &miekgdns.Client{Net: "tcp", Timeout: time.Second * 30, SingleInflight: false},
+	}
+}
+
+// ListenDNS bind udp and tcp on same address, port 0 means random port which is available for both of them
+func ListenDNS(address string) (net.PacketConn, net.Listener, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, nil, err
+	}
+	// random udp port may be used by tcp, try again
+	for i := 0; ; i++ {
+		pc, err := net.ListenPacket("udp", address)
+		if err != nil {
+			return nil, nil, err
+		}
+		l, err := net.Listen("tcp", pc.LocalAddr().String())
+		if err == nil {
+			return pc, l, nil
+		}
+		_ = pc.Close()
+		if port != "0" || i >= 10 {
+			return nil, nil, fmt.Errorf("can not listen tcp on %s, err: %v", net.JoinHostPort(host, port), err)
+		}
+	}
+}
+
+// NewDNSServer serve dns on udp and tcp listened by ListenDNS, if one of them exit, another one will be shutdown too,
+// both of them are closed after return
+func NewDNSServer(pc net.PacketConn, l net.Listener, forwardDNS *miekgdns.ClientConfig) error {
+	s := newServer(forwardDNS)
+	udpServer := &miekgdns.Server{PacketConn: pc, Handler: s}
+	tcpServer := &miekgdns.Server{Listener: l, Handler: s}
+	errChan := make(chan error, 2)
+	go func() { errChan <- udpServer.ActivateAndServe() }()
+	go func() { errChan <- tcpServer.ActivateAndServe() }()
+	err := <-errChan
+	if e := udpServer.Shutdown(); e != nil {
+		log.Debugf("failed to shutdown dns server on udp, err: %v", e)
+	}
+	if e := tcpServer.Shutdown(); e != nil {
+		log.Debugf("failed to shutdown dns server on tcp, err: %v", e)
+	}
+	// shutdown does nothing if server is not started yet
+	_ = pc.Close()
+	_ = l.Close()
+	return err
+}
+
+// ServeDNSForever serve dns on address, listen it again with backoff if server exit
+func ServeDNSForever(pc net.PacketConn, l net.Listener, forwardDNS *miekgdns.ClientConfig) {
+	address := pc.LocalAddr().String()
+	backoff := wait.Backoff{Duration: time.Millisecond * 100, Factor: 2, Jitter: 0.1, Steps: math.MaxInt32, Cap: time.Second * 10}
+	for {
+		log.Errorf("dns server on %s exited, err: %v", address, NewDNSServer(pc, l, forwardDNS))
+		var err error
+		for {
+			time.Sleep(backoff.Step())
+			if pc, l, err = ListenDNS(address); err == nil {
+				break
+			}
+			log.Errorf("failed to listen dns on %s, err: %v", address, err)
+		}
+	}
 }
 
 // ServeDNS consider using a cache
@@ -48,6 +116,13 @@ func (s *server) ServeDNS(w miekgdns.ResponseWriter, r *miekgdns.Msg) {
 	done.Store(false)
 	var q = r.Question[0]
 	var originName = q.Name
+	// max size of answer which client can receive
+	var size = miekgdns.MinMsgSize
+	if w.LocalAddr().Network() == "tcp" {
+		size = miekgdns.MaxMsgSize
+	} else if opt := r.IsEdns0(); opt != nil && int(opt.UDPSize()) > size {
+		size = int(opt.UDPSize())
+	}
 
 	searchList := fix(originName, s.forwardDNS.Search)
 	if v, ok := s.dnsCache.Get(originName); ok {
@@ -55,11 +130,7 @@ func (s *server) ServeDNS(w miekgdns.ResponseWriter, r *miekgdns.Msg) {
 	}
 
 	for _, name := range searchList {
-		// only should have dot [5,6]
-		// productpage.default.svc.cluster.local.
-		// mongo-headless.mongodb.default.svc.cluster.local.
-		count := strings.Count(name, ".")
-		if count < 5 || count > 6 {
+		if skip(name) {
 			continue
 		}
 
@@ -76,11 +147,16 @@ func (s *server) ServeDNS(w miekgdns.ResponseWriter, r *miekgdns.Msg) {
 				}
 				msg.Ns = nil
 				msg.Extra = nil
+				// keep edns0 buffer size of client, so large answer can be returned by udp directly
+				if opt := r.IsEdns0(); opt != nil {
+					msg.SetEdns0(opt.UDPSize(), opt.Do())
+				}
 
 				//msg.Id = uint16(rand.Intn(math.MaxUint16 + 1))
-				client := miekgdns.Client{Net: "udp", Timeout: time.Second * 30}
-				//r, _, err = client.ExchangeContext(ctx, m, a)
-				answer, _, err := client.ExchangeContext(context.Background(), &msg, fmt.Sprintf("%s:%s", dnsAddr, s.forwardDNS.Port))
+				address := fmt.Sprintf("%s:%s", dnsAddr, s.forwardDNS.Port)
+				start := time.Now()
+				answer, err := s.exchange(&msg, address)
+				publish(newQueryLog(originName, name, address, start, answer, err))
 
 				if err == nil && len(answer.Answer) != 0 {
 					s.dnsCache.Add(originName, name, time.Hour*24*365*100) // never expire
@@ -109,6 +185,8 @@ func (s *server) ServeDNS(w miekgdns.ResponseWriter, r *miekgdns.Msg) {
 						return
 					default:
 						done.Store(true)
+						// if answer is larger than client can receive, set truncated flag, client will retry with tcp
+						r.Truncate(size)
 						err = w.WriteMsg(r)
 						cancelFunc()
 						return
@@ -134,6 +212,29 @@ func (s *server) ServeDNS(w miekgdns.ResponseWriter, r *miekgdns.Msg) {
 	}
 }
 
+// exchange query dns server with udp, if answer is truncated, retry with tcp
+func (s *server) exchange(msg *miekgdns.Msg, address string) (*miekgdns.Msg, error) {
+	return s.exchangeContext(context.Background(), msg, address)
+}
+
+func (s *server) exchangeContext(ctx context.Context, msg *miekgdns.Msg, address string) (*miekgdns.Msg, error) {
+	answer, _, err := s.udp.ExchangeContext(ctx, msg, address)
+	if err != nil || !answer.Truncated {
+		return answer, err
+	}
+	log.Debugf("answer of %s from %s is truncated, retry with tcp", msg.Question[0].Name, address)
+	answer, _, err = s.tcp.ExchangeContext(ctx, msg, address)
+	return answer, err
+}
+
+// skip only should have dot [5,6]
+// productpage.default.svc.cluster.local.
+// mongo-headless.mongodb.default.svc.cluster.local.
+func skip(name string) bool {
+	count := strings.Count(name, ".")
+	return count < 5 || count > 6
+}
+
 func fix(domain string, suffix []string) (result []string) {
 	result = []string{domain}
 	for _, s := range suffix {
@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	miekgdns "github.com/miekg/dns"
+)
+
+// upstream answer truncated message over udp, and full message over tcp
+func TestExchangeRetryTCPIfTruncated(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pc, err := net.ListenPacket("udp", l.Addr().String())
+	if err != nil {
+		t.Skipf("can not listen udp on same port, err: %v", err)
+	}
+	handler := miekgdns.HandlerFunc(func(w miekgdns.ResponseWriter, r *miekgdns.Msg) {
+		m := new(miekgdns.Msg)
+		m.SetReply(r)
+		if w.LocalAddr().Network() == "udp" {
+			m.Truncated = true
+		} else {
+			for i := 0; i < 100; i++ {
+				m.Answer = append(m.Answer, &miekgdns.A{
+					Hdr: miekgdns.RR_Header{Name: r.Question[0].Name, Rrtype: miekgdns.TypeA, Class: miekgdns.ClassINET, Ttl: 30},
+					A:   net.IPv4(10, 0, 0, byte(i)),
+				})
+			}
+		}
+		_ = w.WriteMsg(m)
+	})
+	tcpServer := &miekgdns.Server{Listener: l, Handler: handler}
+	udpServer := &miekgdns.Server{PacketConn: pc, Handler: handler}
+	go tcpServer.ActivateAndServe()
+	go udpServer.ActivateAndServe()
+	defer tcpServer.Shutdown()
+	defer udpServer.Shutdown()
+
+	s := newServer(&miekgdns.ClientConfig{})
+	msg := new(miekgdns.Msg)
+	msg.SetQuestion("headless.default.svc.cluster.local.", miekgdns.TypeA)
+	answer, err := s.exchange(msg, l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if answer.Truncated {
+		t.Fatal("answer should not be truncated")
+	}
+	if len(answer.Answer) != 100 {
+		t.Fatalf("expect 100 answers, but got %d", len(answer.Answer))
+	}
+}
+
+// udp and tcp listen on same random port, and both of them are closed after server exit
+func TestListenDNS(t *testing.T) {
+	pc, l, err := ListenDNS("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if udp, tcp := pc.LocalAddr().(*net.UDPAddr).Port, l.Addr().(*net.TCPAddr).Port; udp != tcp {
+		t.Fatalf("expect same port, but got udp %d and tcp %d", udp, tcp)
+	}
+	address := pc.LocalAddr().String()
+	done := make(chan error)
+	go func() { done <- NewDNSServer(pc, l, &miekgdns.ClientConfig{}) }()
+	time.Sleep(time.Millisecond * 100)
+	_ = l.Close()
+	<-done
+	pc, l, err = ListenDNS(address)
+	if err != nil {
+		t.Fatalf("address should be released after server exit, err: %v", err)
+	}
+	_ = pc.Close()
+	_ = l.Close()
+}
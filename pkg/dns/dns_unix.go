@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"io/fs"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -18,8 +19,6 @@ import (
 	miekgdns "github.com/miekg/dns"
 	log "github.com/sirupsen/logrus"
 	"k8s.io/apimachinery/pkg/util/sets"
-
-	"github.com/wencaiwulue/kubevpn/pkg/util"
 )
 
 var cancel context.CancelFunc
@@ -56,12 +55,13 @@ func usingResolver(clientConfig *miekgdns.ClientConfig, ns []string) {
 	_ = os.WriteFile(filename, []byte(toString(config)), 0644)
 
 	// for support like: service.namespace:port, service.namespace.svc:port, service.namespace.svc.cluster:port
-	port := util.GetAvailableUDPPortOrDie()
-	go func(port int, clientConfig *miekgdns.ClientConfig) {
-		for {
-			log.Errorln(NewDNSServer("udp", "127.0.0.1:"+strconv.Itoa(port), clientConfig))
-		}
-	}(port, clientConfig)
+	pc, l, err := ListenDNS("127.0.0.1:0")
+	if err != nil {
+		log.Errorf("failed to listen dns, err: %v", err)
+		return
+	}
+	port := pc.LocalAddr().(*net.UDPAddr).Port
+	go ServeDNSForever(pc, l, clientConfig)
 	config = miekgdns.ClientConfig{
 		Servers: []string{"127.0.0.1"},
 		Search:  clientConfig.Search,
@@ -0,0 +1,146 @@
+package dns
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	miekgdns "github.com/miekg/dns"
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
+// QueryLog one candidate name tried by dns server
+type QueryLog struct {
+	Time time.Time `json:"time"`
+	// Name is the name client asked for
+	Name string `json:"name"`
+	// Candidate is the name after search list expansion
+	Candidate string        `json:"candidate"`
+	Server    string        `json:"server"`
+	Latency   time.Duration `json:"latency"`
+	Rcode     string        `json:"rcode,omitempty"`
+	Answer    []string      `json:"answer,omitempty"`
+	Error     string        `json:"error,omitempty"`
+	// Skipped means candidate is not sent to server, because of it can not be a kubernetes domain
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+func newQueryLog(name, candidate, server string, start time.Time, answer *miekgdns.Msg, err error) *QueryLog {
+	l := &QueryLog{
+		Time:      start,
+		Name:      name,
+		Candidate: candidate,
+		Server:    server,
+		Latency:   time.Since(start),
+	}
+	if err != nil {
+		l.Error = err.Error()
+	}
+	if answer != nil {
+		l.Rcode = miekgdns.RcodeToString[answer.Rcode]
+		for _, rr := range answer.Answer {
+			l.Answer = append(l.Answer, strings.TrimPrefix(rr.String(), rr.Header().String()))
+		}
+	}
+	return l
+}
+
+func (l *QueryLog) String() string {
+	if l.Skipped {
+		return fmt.Sprintf("%s %s -> %s skipped", l.Time.Format("15:04:05.000"), l.Name, l.Candidate)
+	}
+	result := l.Rcode
+	if l.Error != "" {
+		result = l.Error
+	}
+	return fmt.Sprintf("%s %s -> %s @%s %v %s %s", l.Time.Format("15:04:05.000"), l.Name, l.Candidate, l.Server, l.Latency.Round(time.Microsecond), result, strings.Join(l.Answer, ", "))
+}
+
+var subscribers = struct {
+	sync.Mutex
+	m map[chan *QueryLog]struct{}
+}{m: map[chan *QueryLog]struct{}{}}
+
+func publish(l *QueryLog) {
+	log.Debugln(l.String())
+	subscribers.Lock()
+	defer subscribers.Unlock()
+	for c := range subscribers.m {
+		select {
+		case c <- l:
+		default:
+			// slow subscriber, drop it
+		}
+	}
+}
+
+func subscribe() (<-chan *QueryLog, func()) {
+	c := make(chan *QueryLog, 100)
+	subscribers.Lock()
+	subscribers.m[c] = struct{}{}
+	subscribers.Unlock()
+	return c, func() {
+		subscribers.Lock()
+		delete(subscribers.m, c)
+		subscribers.Unlock()
+	}
+}
+
+// ServeQueryLog stream query log of local dns server as json lines
+func ServeQueryLog(w http.ResponseWriter, r *http.Request) {
+	c, unsubscribe := subscribe()
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case l := <-c:
+			if err := encoder.Encode(l); err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		}
+	}
+}
+
+// Query resolve name like local dns server does, but try every candidate name on every server
+// useful for finding out which step is wrong
+func Query(ctx context.Context, clientConfig *miekgdns.ClientConfig, name string, qtype uint16) []*QueryLog {
+	s := newServer(clientConfig)
+	name = miekgdns.Fqdn(name)
+	var result []*QueryLog
+	for _, candidate := range fix(name, clientConfig.Search) {
+		if skip(candidate) {
+			result = append(result, &QueryLog{Time: time.Now(), Name: name, Candidate: candidate, Skipped: true})
+			continue
+		}
+		for _, server := range clientConfig.Servers {
+			msg := new(miekgdns.Msg)
+			msg.SetQuestion(candidate, qtype)
+			address := fmt.Sprintf("%s:%s", server, clientConfig.Port)
+			start := time.Now()
+			answer, err := s.exchangeContext(ctx, msg, address)
+			result = append(result, newQueryLog(name, candidate, address, start, answer, err))
+		}
+	}
+	return result
+}
+
+func init() {
+	http.HandleFunc(config.APIDNSQueryLog, ServeQueryLog)
+}
@@ -2,7 +2,6 @@ package exchange
 
 import (
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/utils/pointer"
 
 	"github.com/wencaiwulue/kubevpn/pkg/config"
@@ -22,16 +21,10 @@ func AddContainer(spec *corev1.PodSpec, c util.PodRouteConfig) {
 	// remove vpn container if already exist
 	RemoveContainer(spec)
 	spec.Containers = append(spec.Containers, corev1.Container{
-		Name:  config.ContainerSidecarVPN,
-		Image: config.Image,
-		EnvFrom: []corev1.EnvFromSource{{
-			SecretRef: &corev1.SecretEnvSource{
-				LocalObjectReference: corev1.LocalObjectReference{
-					Name: config.ConfigMapPodTrafficManager,
-				},
-			},
-		}},
-		Env: []corev1.EnvVar{
+		Name:    config.ContainerSidecarVPN,
+		Image:   config.Image,
+		EnvFrom: c.SidecarEnvFrom(),
+		Env: append([]corev1.EnvVar{
 			{
 				Name:  "LocalTunIP",
 				Value: c.LocalTunIP,
@@ -48,7 +41,11 @@ func AddContainer(spec *corev1.PodSpec, c util.PodRouteConfig) {
 				Name:  "CIDR",
 				Value: config.CIDR.String(),
 			},
-		},
+			{
+				Name:  config.EnvTunnelCIDR,
+				Value: config.TunnelCIDR(),
+			},
+		}, c.SidecarEnv()...),
 		Command: []string{"/bin/sh", "-c"},
 		// https://www.netfilter.org/documentation/HOWTO/NAT-HOWTO-6.html#ss6.2
 		Args: []string{`
@@ -73,19 +70,10 @@ kubevpn serve -L "tun:/127.0.0.1:8422?net=${InboundPodTunIP}&route=${CIDR}" -F "
 			RunAsUser:  pointer.Int64(0),
 			Privileged: pointer.Bool(true),
 		},
-		Resources: corev1.ResourceRequirements{
-			Requests: map[corev1.ResourceName]resource.Quantity{
-				corev1.ResourceCPU:    resource.MustParse("128m"),
-				corev1.ResourceMemory: resource.MustParse("128Mi"),
-			},
-			Limits: map[corev1.ResourceName]resource.Quantity{
-				corev1.ResourceCPU:    resource.MustParse("256m"),
-				corev1.ResourceMemory: resource.MustParse("256Mi"),
-			},
-		},
+		Resources:       config.Pod.GetSidecarResources(),
 		ImagePullPolicy: corev1.PullIfNotPresent,
 	})
 	if len(spec.PriorityClassName) == 0 {
-		spec.PriorityClassName = "system-cluster-critical"
+		spec.PriorityClassName = config.Pod.GetPriorityClassName()
 	}
 }
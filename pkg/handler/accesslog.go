@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
+	"github.com/wencaiwulue/kubevpn/pkg/util"
+)
+
+// StreamAccessLog stream access log of proxied workloads from control-plane, retry until context done,
+// envoy-proxy sidecars send access log to any replica of traffic manager, so stream from all replicas
+func (c *ConnectOptions) StreamAccessLog(ctx context.Context, out io.Writer) {
+	var nodes []string
+	for _, workload := range c.Workloads {
+		object, err := util.GetUnstructuredObject(c.factory, c.Namespace, workload)
+		if err != nil {
+			log.Warnf("can not get workload %s, err: %v", workload, err)
+			continue
+		}
+		nodes = append(nodes, getNodeID(object))
+	}
+	port := strconv.Itoa(config.PortAccessLog)
+	var lock sync.Mutex
+	streaming := map[string]bool{}
+	for ctx.Err() == nil {
+		addrs, err := listReplicas(ctx, c.clientset, c.managerNamespace(), "", port)
+		if err != nil || len(addrs) == 0 {
+			log.Debugf("can not list replicas of traffic manager, stream access log by tunnel, err: %v", err)
+			addrs = []string{net.JoinHostPort(config.RouterIP.String(), port)}
+		}
+		for _, addr := range addrs {
+			lock.Lock()
+			if streaming[addr] {
+				lock.Unlock()
+				continue
+			}
+			streaming[addr] = true
+			lock.Unlock()
+			go func(addr string) {
+				u := fmt.Sprintf("http://%s%s?node=%s", addr, config.APIAccessLog, url.QueryEscape(strings.Join(nodes, ",")))
+				if err := c.streamAccessLog(ctx, u, out, &lock); err != nil {
+					log.Debugf("stream access log from %s occurs error, err: %v, retrying", addr, err)
+				}
+				lock.Lock()
+				delete(streaming, addr)
+				lock.Unlock()
+			}(addr)
+		}
+		select {
+		case <-ctx.Done():
+		case <-time.After(time.Second * 5):
+		}
+	}
+}
+
+func (c *ConnectOptions) streamAccessLog(ctx context.Context, u string, out io.Writer, lock sync.Locker) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("http status is %d", resp.StatusCode)
+	}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		var l controlplane.AccessLog
+		if err = json.Unmarshal(scanner.Bytes(), &l); err != nil {
+			continue
+		}
+		// route and cluster name of local PC is <LocalTunIP>_<port>
+		if c.localTunIP != nil {
+			for _, name := range []*string{&l.Route, &l.Cluster} {
+				if strings.HasPrefix(*name, c.localTunIP.IP.String()+"_") {
+					*name = "local"
+				}
+			}
+		}
+		lock.Lock()
+		_, _ = fmt.Fprintln(out, l.String())
+		lock.Unlock()
+	}
+	return scanner.Err()
+}
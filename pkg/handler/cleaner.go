@@ -5,17 +5,12 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
-	"strconv"
 	"syscall"
 
 	log "github.com/sirupsen/logrus"
-	corev1 "k8s.io/api/core/v1"
-	k8serrors "k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/kubernetes"
-	v12 "k8s.io/client-go/kubernetes/typed/core/v1"
-	"k8s.io/client-go/util/retry"
 	"k8s.io/utils/pointer"
 
 	"github.com/wencaiwulue/kubevpn/pkg/config"
@@ -43,13 +38,19 @@ func (c *ConnectOptions) addCleanUpResourceHandler(clientset *kubernetes.Clients
 				function()
 			}
 		}
-		_ = clientset.CoreV1().Pods(namespace).Delete(context.Background(), config.CniNetName, v1.DeleteOptions{GracePeriodSeconds: pointer.Int64(0)})
-		var count int
-		count, err = updateRefCount(clientset.CoreV1().ConfigMaps(namespace), config.ConfigMapPodTrafficManager, -1)
+		_ = clientset.CoreV1().Pods(c.Namespace).Delete(context.Background(), config.CniNetName, v1.DeleteOptions{GracePeriodSeconds: pointer.Int64(0)})
+		if c.session != nil {
+			if err = c.session.Close(); err != nil {
+				log.Error(err)
+			}
+		}
+		sessions, err := ListSessions(context.Background(), clientset, namespace)
 		if err == nil {
-			// if ref-count is less than zero or equals to zero, means nobody is using this traffic pod, so clean it
-			if count <= 0 {
-				log.Info("ref-count is zero, prepare to clean up resource")
+			// no session is alive, means nobody is using this traffic pod, so clean it
+			// traffic manager installed by administrator is kept
+			if countAliveSessions(sessions) == 0 && !isInstalled(context.Background(), clientset, namespace) &&
+				!usedByLegacyClients(context.Background(), clientset, namespace) {
+				log.Info("no session is alive, prepare to clean up resource")
 				cleanup(clientset, namespace, config.ConfigMapPodTrafficManager, true)
 			}
 		} else {
@@ -68,83 +69,33 @@ func Cleanup(s os.Signal) {
 	}
 }
 
-// vendor/k8s.io/kubectl/pkg/polymorphichelpers/rollback.go:99
-func updateRefCount(configMapInterface v12.ConfigMapInterface, name string, increment int) (current int, err error) {
-	err = retry.OnError(
-		retry.DefaultRetry,
-		func(err error) bool {
-			notFound := k8serrors.IsNotFound(err)
-			if notFound {
-				return false
-			}
-			conflict := k8serrors.IsConflict(err)
-			if conflict {
-				return true
-			}
-			return false
-		},
-		func() (err error) {
-			var cm *corev1.ConfigMap
-			cm, err = configMapInterface.Get(context.Background(), name, v1.GetOptions{})
-			if err != nil {
-				if k8serrors.IsNotFound(err) {
-					return err
-				}
-				err = fmt.Errorf("update ref-count failed, increment: %d, error: %v", increment, err)
-				return
-			}
-			curCount, _ := strconv.Atoi(cm.Data[config.KeyRefCount])
-			var newVal = curCount + increment
-			if newVal < 0 {
-				newVal = 0
-			}
-			p := []byte(fmt.Sprintf(`{"data":{"%s":"%s"}}`, config.KeyRefCount, strconv.Itoa(newVal)))
-			_, err = configMapInterface.Patch(context.Background(), name, types.MergePatchType, p, v1.PatchOptions{})
-			if err != nil {
-				if k8serrors.IsNotFound(err) {
-					return err
-				}
-				err = fmt.Errorf("update ref count error, error: %v", err)
-				return
-			}
-			return
-		})
-	if err != nil {
-		return
-	}
-	log.Info("update ref count successfully")
-	var cm *corev1.ConfigMap
-	cm, err = configMapInterface.Get(context.Background(), name, v1.GetOptions{})
-	if err != nil {
-		err = fmt.Errorf("failed to get cm: %s, err: %v", name, err)
-		return
-	}
-	current, err = strconv.Atoi(cm.Data[config.KeyRefCount])
-	if err != nil {
-		err = fmt.Errorf("failed to get ref-count, err: %v", err)
-	}
-	return
-}
-
-func cleanup(clientset *kubernetes.Clientset, namespace, name string, keepCidr bool) {
+// cleanup delete traffic manager and its resources, also used by traffic manager to clean up itself,
+// so rbac resources are deleted at last
+func cleanup(clientset kubernetes.Interface, namespace, name string, keepCidr bool) {
 	options := v1.DeleteOptions{GracePeriodSeconds: pointer.Int64(0)}
 
 	if keepCidr {
 		// keep configmap
 		p := []byte(fmt.Sprintf(`[{"op": "remove", "path": "/data/%s"}]`, config.KeyDHCP))
 		_, _ = clientset.CoreV1().ConfigMaps(namespace).Patch(context.Background(), name, types.JSONPatchType, p, v1.PatchOptions{})
-		p = []byte(fmt.Sprintf(`{"data":{"%s":"%s"}}`, config.KeyRefCount, strconv.Itoa(0)))
+		// tunnel cidr is decided by next one who creates traffic manager
+		p = []byte(fmt.Sprintf(`{"data":{"%s":null}}`, config.KeyTunnelCIDR))
 		_, _ = clientset.CoreV1().ConfigMaps(namespace).Patch(context.Background(), name, types.MergePatchType, p, v1.PatchOptions{})
 	} else {
 		_ = clientset.CoreV1().ConfigMaps(namespace).Delete(context.Background(), name, options)
 	}
+	// all ips are released and all sessions are gone
+	_ = clientset.CoordinationV1().Leases(namespace).DeleteCollection(context.Background(), options, v1.ListOptions{LabelSelector: config.LabelIPLease})
+	_ = clientset.CoordinationV1().Leases(namespace).DeleteCollection(context.Background(), options, v1.ListOptions{LabelSelector: config.LabelSession})
 
 	_ = clientset.CoreV1().Pods(namespace).Delete(context.Background(), config.CniNetName, options)
 	_ = clientset.CoreV1().Secrets(namespace).Delete(context.Background(), name, options)
 	_ = clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Delete(context.Background(), name+"."+namespace, options)
+	_ = clientset.CoreV1().Services(namespace).Delete(context.Background(), name, options)
+	_ = clientset.AppsV1().Deployments(namespace).Delete(context.Background(), name, options)
 	_ = clientset.RbacV1().RoleBindings(namespace).Delete(context.Background(), name, options)
 	_ = clientset.CoreV1().ServiceAccounts(namespace).Delete(context.Background(), name, options)
 	_ = clientset.RbacV1().Roles(namespace).Delete(context.Background(), name, options)
-	_ = clientset.CoreV1().Services(namespace).Delete(context.Background(), name, options)
-	_ = clientset.AppsV1().Deployments(namespace).Delete(context.Background(), name, options)
+	_ = clientset.RbacV1().ClusterRoleBindings().Delete(context.Background(), name+"."+namespace, options)
+	_ = clientset.RbacV1().ClusterRoles().Delete(context.Background(), name+"."+namespace, options)
 }
@@ -14,6 +14,7 @@ import (
 
 	"github.com/containernetworking/cni/pkg/types"
 	netroute "github.com/libp2p/go-netroute"
+	miekgdns "github.com/miekg/dns"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
@@ -39,6 +40,7 @@ import (
 	"k8s.io/utils/pointer"
 
 	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
 	"github.com/wencaiwulue/kubevpn/pkg/core"
 	"github.com/wencaiwulue/kubevpn/pkg/dns"
 	"github.com/wencaiwulue/kubevpn/pkg/driver"
@@ -47,10 +49,30 @@ import (
 )
 
 type ConnectOptions struct {
-	Namespace string
-	Headers   map[string]string
+	Namespace   string
+	Headers     map[string]string
+	PathPrefix  string
+	QueryParams map[string]string
+	Weight      uint32
+	Mirror      bool
+	AccessLog   bool
+	Baggage     bool
+	GrpcMethod  string
+	SNI         []string
+	TLSSecret   string
+	// Faults like abort=503:10% and delay=2s:5%, matched traffic go to origin workloads with fault
+	Faults []string
+	// TTL traffic manager will unpatch workloads after ttl, zero means never
+	TTL time.Duration
+	// Force take over workloads intercepted by others without mesh
+	Force     bool
 	Workloads []string
 	ExtraCIDR []string
+	// TunnelCIDR inner tunnel address pool of traffic manager, only works while creating traffic manager
+	TunnelCIDR string
+	// ManagerNamespace namespace of cluster-wide traffic manager installed by administrator, empty means traffic
+	// manager in Namespace, created by first client
+	ManagerNamespace string
 
 	clientset  *kubernetes.Clientset
 	restclient *rest.RESTClient
@@ -62,13 +84,21 @@ type ConnectOptions struct {
 	usedIPs    []*net.IPNet
 	routerIP   net.IP
 	localTunIP *net.IPNet
+	owner      *controlplane.Owner
+	expire     *metav1.Time
+	fault      *controlplane.Fault
+	session    *Session
 }
 
 func (c *ConnectOptions) createRemoteInboundPod(ctx1 context.Context) (err error) {
-	c.localTunIP, err = c.dhcp.RentIPBaseNICAddress()
+	holder := &IPLeaseHolder{Kind: IPLeaseKindLaptop, Holder: c.owner.String(), Hostname: c.owner.Hostname}
+	c.localTunIP, err = c.dhcp.RentIPBaseNICAddress(holder)
 	if err != nil {
 		return
 	}
+	// release it while cleanup, if kubevpn exits without cleanup, traffic manager will reclaim it after lease expired
+	c.usedIPs = append(c.usedIPs, c.localTunIP)
+	go c.dhcp.KeepIPLease(ctx1, c.localTunIP, holder)
 
 	for _, workload := range c.Workloads {
 		if len(workload) > 0 {
@@ -76,11 +106,20 @@ func (c *ConnectOptions) createRemoteInboundPod(ctx1 context.Context) (err error
 				LocalTunIP:           c.localTunIP.IP.String(),
 				TrafficManagerRealIP: c.routerIP.String(),
 			}
+			if c.managerNamespace() != c.Namespace {
+				configInfo.TrafficManagerNamespace = c.managerNamespace()
+			}
 			// means mesh mode
-			if len(c.Headers) != 0 {
-				err = InjectVPNAndEnvoySidecar(ctx1, c.factory, c.clientset.CoreV1().ConfigMaps(c.Namespace), c.Namespace, workload, configInfo, c.Headers)
+			if c.isMeshMode() {
+				if c.TLSSecret != "" {
+					if err = checkTLSSecret(ctx1, c.clientset, c.Namespace, c.TLSSecret); err != nil {
+						return err
+					}
+					configInfo.TLSSecret = c.TLSSecret
+				}
+				err = InjectVPNAndEnvoySidecar(ctx1, c.factory, c.clientset.CoreV1().ConfigMaps(c.managerNamespace()), c.Namespace, workload, configInfo, c.meshRule())
 			} else {
-				err = InjectVPNSidecar(ctx1, c.factory, c.Namespace, workload, configInfo)
+				err = c.interceptWorkload(ctx1, workload, configInfo)
 			}
 			if err != nil {
 				return err
@@ -90,6 +129,59 @@ func (c *ConnectOptions) createRemoteInboundPod(ctx1 context.Context) (err error
 	return
 }
 
+// isMeshMode if any matcher, weight, mirror, access log, tls secret or fault is specified, using envoy to route traffic, otherwise redirect all traffic to local PC
+func (c *ConnectOptions) isMeshMode() bool {
+	return c.meshRule().HasMatcher() || c.Weight != 0 || c.Mirror || c.AccessLog || c.TLSSecret != "" || len(c.Faults) != 0
+}
+
+func (c *ConnectOptions) meshRule() *controlplane.Rule {
+	rule := &controlplane.Rule{
+		Headers:     c.Headers,
+		PathPrefix:  c.PathPrefix,
+		QueryParams: c.QueryParams,
+		Weight:      c.Weight,
+		Mirror:      c.Mirror,
+		AccessLog:   c.AccessLog,
+		Baggage:     c.Baggage,
+		GrpcMethod:  c.GrpcMethod,
+		SNI:         c.SNI,
+		TLSSecret:   c.TLSSecret,
+		Fault:       c.fault,
+		Owner:       c.owner,
+		Expire:      c.expire,
+	}
+	if c.localTunIP != nil {
+		rule.LocalTunIP = c.localTunIP.IP.String()
+	}
+	return rule
+}
+
+// interceptWorkload redirect all traffic of workload to local PC, holding exclusive lock of it
+func (c *ConnectOptions) interceptWorkload(ctx context.Context, workload string, configInfo util.PodRouteConfig) (err error) {
+	var lock *WorkloadLock
+	if lock, err = c.lockWorkload(ctx, workload); err != nil {
+		return err
+	}
+	// rollback which releases lock is not registered if failed before patching workload, release it here,
+	// otherwise lease and renew goroutine leak, releasing twice is fine
+	defer func() {
+		if err != nil {
+			lock.Release()
+		}
+	}()
+	return InjectVPNSidecar(ctx, c.factory, c.Namespace, workload, configInfo, c.owner, c.expire, lock)
+}
+
+// lockWorkload acquire exclusive lock of workload, only one person can intercept all traffic of it
+func (c *ConnectOptions) lockWorkload(ctx context.Context, workload string) (*WorkloadLock, error) {
+	object, err := util.GetUnstructuredObject(c.factory, c.Namespace, workload)
+	if err != nil {
+		return nil, err
+	}
+	holder := fmt.Sprintf("%s(%s)", c.owner, c.localTunIP.IP.String())
+	return AcquireWorkloadLock(ctx, c.clientset, c.Namespace, getNodeID(object), holder, c.Force)
+}
+
 func Rollback(f cmdutil.Factory, ns, workload string) {
 	r := f.NewBuilder().
 		WithScheme(scheme.Scheme, scheme.Scheme.PrioritizedVersionsAllGroups()...).
@@ -115,17 +207,33 @@ func Rollback(f cmdutil.Factory, ns, workload string) {
 }
 
 func (c *ConnectOptions) DoConnect() (err error) {
-	c.addCleanUpResourceHandler(c.clientset, c.Namespace)
-	trafficMangerNet := net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask}
-	c.dhcp = NewDHCPManager(c.clientset.CoreV1().ConfigMaps(c.Namespace), c.Namespace, &trafficMangerNet)
+	c.addCleanUpResourceHandler(c.clientset, c.managerNamespace())
+	c.dhcp = NewDHCPManager(c.clientset, c.managerNamespace(), &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask})
 	if err = c.dhcp.InitDHCP(ctx); err != nil {
 		return
 	}
+	// register session before reuse or create traffic manager, others won't clean it up while creating,
+	// connect without proxy does not pre check resource, so owner is nil
+	if c.owner == nil {
+		c.owner = c.getOwner(ctx)
+	}
+	if c.session, err = RegisterSession(ctx, c.clientset, c.managerNamespace(), c.owner); err != nil {
+		return
+	}
+	go c.session.Keep(ctx)
 	err = c.GetCIDR(ctx)
 	if err != nil {
 		return
 	}
-	c.routerIP, err = CreateOutboundPod(ctx, c.factory, c.clientset, c.Namespace, trafficMangerNet.String())
+	if err = c.resolveTunnelCIDR(ctx); err != nil {
+		return
+	}
+	trafficMangerNet := net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask}
+	c.dhcp = NewDHCPManager(c.clientset, c.managerNamespace(), &trafficMangerNet)
+	if c.managerNamespace() != c.Namespace && !isInstalled(ctx, c.clientset, c.managerNamespace()) {
+		return fmt.Errorf("cluster-wide traffic manager is not installed in namespace %s, please contact administrator to install it by kubevpn install --cluster-wide", c.managerNamespace())
+	}
+	c.routerIP, err = CreateOutboundPod(ctx, c.factory, c.clientset, c.managerNamespace(), trafficMangerNet.String())
 	if err != nil {
 		return
 	}
@@ -154,11 +262,11 @@ func (c *ConnectOptions) DoConnect() (err error) {
 	return
 }
 
-// detect pod is delete event, if pod is deleted, needs to redo port-forward immediately
+// detect pod is delete event, if pod is deleted or terminating, needs to redo port-forward immediately
 func (c *ConnectOptions) portForward(ctx context.Context, port string) error {
 	var readyChan = make(chan struct{}, 1)
 	var errChan = make(chan error, 1)
-	podInterface := c.clientset.CoreV1().Pods(c.Namespace)
+	podInterface := c.clientset.CoreV1().Pods(c.managerNamespace())
 	go func() {
 		var first = pointer.Bool(true)
 		for {
@@ -185,7 +293,7 @@ func (c *ConnectOptions) portForward(ctx context.Context, port string) error {
 					c.config,
 					c.restclient,
 					podName,
-					c.Namespace,
+					c.managerNamespace(),
 					port,
 					readyChan,
 					childCtx.Done(),
@@ -237,7 +345,13 @@ func checkPodStatus(cCtx context.Context, cFunc context.CancelFunc, podName stri
 				return
 			case watch.Error:
 				return
-			case watch.Added, watch.Modified, watch.Bookmark:
+			case watch.Added, watch.Modified:
+				// traffic manager is terminating while rolling update, port-forward to another replica before it is gone
+				if pod, ok := e.Object.(*v1.Pod); ok && pod.GetDeletionTimestamp() != nil {
+					cFunc()
+					return
+				}
+			case watch.Bookmark:
 				// do nothing
 			default:
 				return
@@ -414,20 +528,11 @@ func (c *ConnectOptions) deleteFirewallRule(ctx context.Context) {
 }
 
 func (c *ConnectOptions) setupDNS() error {
-	const port = 53
-	pod, err := c.GetRunningPodList()
+	relovConf, err := c.GetDNSConfig()
 	if err != nil {
 		log.Errorln(err)
 		return err
 	}
-	relovConf, err := dns.GetDNSServiceIPFromPod(c.clientset, c.restclient, c.config, pod[0].GetName(), c.Namespace)
-	if err != nil {
-		log.Errorln(err)
-		return err
-	}
-	if relovConf.Port == "" {
-		relovConf.Port = strconv.Itoa(port)
-	}
 	ns := sets.New[string]()
 	list, err := c.clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
 	if err == nil {
@@ -449,6 +554,32 @@ func (c *ConnectOptions) setupDNS() error {
 	return nil
 }
 
+// GetDNSConfig get resolv.conf of traffic manager pod, and use cluster dns service as nameserver
+func (c *ConnectOptions) GetDNSConfig() (*miekgdns.ClientConfig, error) {
+	const port = 53
+	pod, err := c.GetRunningPodList()
+	if err != nil {
+		return nil, err
+	}
+	relovConf, err := dns.GetDNSServiceIPFromPod(c.clientset, c.restclient, c.config, pod[0].GetName(), c.managerNamespace())
+	if err != nil {
+		return nil, err
+	}
+	if relovConf.Port == "" {
+		relovConf.Port = strconv.Itoa(port)
+	}
+	// search domains of cluster-wide traffic manager pod start with its own namespace, short names should be
+	// resolved in namespace of workloads
+	if c.managerNamespace() != c.Namespace {
+		for i, search := range relovConf.Search {
+			if strings.HasPrefix(search, c.managerNamespace()+".") {
+				relovConf.Search[i] = c.Namespace + strings.TrimPrefix(search, c.managerNamespace())
+			}
+		}
+	}
+	return relovConf, nil
+}
+
 func Start(ctx context.Context, r core.Route) error {
 	servers, err := r.GenerateServers()
 	if err != nil {
@@ -474,6 +605,14 @@ func Start(ctx context.Context, r core.Route) error {
 	return nil
 }
 
+// managerNamespace namespace of traffic manager, cluster-wide traffic manager serves workloads in Namespace
+func (c *ConnectOptions) managerNamespace() string {
+	if c.ManagerNamespace != "" {
+		return c.ManagerNamespace
+	}
+	return c.Namespace
+}
+
 func (c *ConnectOptions) InitClient(f cmdutil.Factory) (err error) {
 	c.factory = f
 	if c.config, err = c.factory.ToRESTConfig(); err != nil {
@@ -579,6 +718,17 @@ func SshJump(conf *util.SshConfig, flags *pflag.FlagSet) (err error) {
 // pod/productpage-without-controller --> pod/productpage-without-controller
 // service/productpage-without-pod --> controller/controllerName
 func (c *ConnectOptions) PreCheckResource() error {
+	c.owner = c.getOwner(context.Background())
+	if c.TTL > 0 {
+		c.expire = &metav1.Time{Time: time.Now().Add(c.TTL)}
+	}
+	var err error
+	if c.fault, err = controlplane.ParseFault(c.Faults); err != nil {
+		return err
+	}
+	if err = c.meshRule().Validate(); err != nil {
+		return err
+	}
 	list, err := util.GetUnstructuredObjectList(c.factory, c.Namespace, c.Workloads)
 	if err != nil {
 		return err
@@ -639,7 +789,7 @@ func (c *ConnectOptions) PreCheckResource() error {
 }
 
 func (c *ConnectOptions) GetRunningPodList() ([]v1.Pod, error) {
-	list, err := c.clientset.CoreV1().Pods(c.Namespace).List(ctx, metav1.ListOptions{
+	list, err := c.clientset.CoreV1().Pods(c.managerNamespace()).List(ctx, metav1.ListOptions{
 		LabelSelector: fields.OneTermEqualSelector("app", config.ConfigMapPodTrafficManager).String(),
 	})
 	if err != nil {
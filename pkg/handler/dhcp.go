@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"net"
+	"time"
 
 	"github.com/cilium/ipam/service/allocator"
 	"github.com/cilium/ipam/service/ipallocator"
@@ -12,20 +13,36 @@ import (
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	coordinationv1 "k8s.io/client-go/kubernetes/typed/coordination/v1"
 	corev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
 
 	"github.com/wencaiwulue/kubevpn/pkg/config"
 )
 
+// configMapRetry backoff of updating configmap kubevpn-traffic-manager while conflict, webhook rent ip for many pods
+// concurrently while rollout, so retry more times than retry.DefaultRetry with jitter to spread them out
+var configMapRetry = wait.Backoff{
+	Steps:    30,
+	Duration: 10 * time.Millisecond,
+	Factor:   1.5,
+	Jitter:   1,
+	Cap:      time.Second,
+}
+
 type DHCPManager struct {
 	client    corev1.ConfigMapInterface
+	leases    coordinationv1.LeaseInterface
 	cidr      *net.IPNet
 	namespace string
 }
 
-func NewDHCPManager(client corev1.ConfigMapInterface, namespace string, cidr *net.IPNet) *DHCPManager {
+func NewDHCPManager(clientset kubernetes.Interface, namespace string, cidr *net.IPNet) *DHCPManager {
 	return &DHCPManager{
-		client:    client,
+		client:    clientset.CoreV1().ConfigMaps(namespace),
+		leases:    clientset.CoordinationV1().Leases(namespace),
 		namespace: namespace,
 		cidr:      cidr,
 	}
@@ -56,8 +73,7 @@ func (d *DHCPManager) InitDHCP(ctx context.Context) error {
 			Labels:    map[string]string{},
 		},
 		Data: map[string]string{
-			config.KeyEnvoy:    "",
-			config.KeyRefCount: "0",
+			config.KeyEnvoy: "",
 		},
 	}
 	_, err = d.client.Create(ctx, cm, metav1.CreateOptions{})
@@ -67,7 +83,8 @@ func (d *DHCPManager) InitDHCP(ctx context.Context) error {
 	return nil
 }
 
-func (d *DHCPManager) RentIPBaseNICAddress() (*net.IPNet, error) {
+// RentIPBaseNICAddress rent ip for local PC, holder needs to renew ip lease, otherwise ip will be reclaimed by traffic manager
+func (d *DHCPManager) RentIPBaseNICAddress(holder *IPLeaseHolder) (*net.IPNet, error) {
 	var ip net.IP
 	err := d.updateDHCPConfigMap(func(allocator *ipallocator.Range) (err error) {
 		ip, err = allocator.AllocateNext()
@@ -76,10 +93,16 @@ func (d *DHCPManager) RentIPBaseNICAddress() (*net.IPNet, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &net.IPNet{IP: ip, Mask: d.cidr.Mask}, nil
+	ipNet := &net.IPNet{IP: ip, Mask: d.cidr.Mask}
+	if err = d.createIPLease(context.Background(), ipNet, holder); err != nil {
+		_ = d.ReleaseIpToDHCP(ipNet)
+		return nil, err
+	}
+	return ipNet, nil
 }
 
-func (d *DHCPManager) RentIPRandom() (*net.IPNet, error) {
+// RentIPRandom rent ip for pod, holder needs to renew ip lease, otherwise ip will be reclaimed by traffic manager
+func (d *DHCPManager) RentIPRandom(holder *IPLeaseHolder) (*net.IPNet, error) {
 	var ip net.IP
 	err := d.updateDHCPConfigMap(func(dhcp *ipallocator.Range) (err error) {
 		ip, err = dhcp.AllocateNext()
@@ -89,10 +112,27 @@ func (d *DHCPManager) RentIPRandom() (*net.IPNet, error) {
 		log.Errorf("failed to rent ip from DHCP server, err: %v", err)
 		return nil, err
 	}
-	return &net.IPNet{IP: ip, Mask: d.cidr.Mask}, nil
+	ipNet := &net.IPNet{IP: ip, Mask: d.cidr.Mask}
+	if err = d.createIPLease(context.Background(), ipNet, holder); err != nil {
+		_ = d.ReleaseIpToDHCP(ipNet)
+		return nil, err
+	}
+	return ipNet, nil
 }
 
+// ReleaseIpToDHCP release ips and delete ip leases of them
 func (d *DHCPManager) ReleaseIpToDHCP(ips ...*net.IPNet) error {
+	if err := d.releaseIPs(ips...); err != nil {
+		return err
+	}
+	for _, ip := range ips {
+		d.deleteIPLease(context.Background(), ip)
+	}
+	return nil
+}
+
+// releaseIPs release ips without deleting ip leases of them
+func (d *DHCPManager) releaseIPs(ips ...*net.IPNet) error {
 	return d.updateDHCPConfigMap(func(r *ipallocator.Range) error {
 		for _, ip := range ips {
 			if err := r.Release(ip.IP); err != nil {
@@ -103,37 +143,42 @@ func (d *DHCPManager) ReleaseIpToDHCP(ips ...*net.IPNet) error {
 	})
 }
 
+// updateDHCPConfigMap update with resource version, if others update it at the same time, like webhook rent ip for pods
+// concurrently while rollout, retry with latest version, otherwise same ip may be rented twice or release may be lost
 func (d *DHCPManager) updateDHCPConfigMap(f func(*ipallocator.Range) error) error {
-	cm, err := d.client.Get(context.Background(), config.ConfigMapPodTrafficManager, metav1.GetOptions{})
-	if err != nil {
-		log.Errorf("failed to get cm DHCP server, err: %v", err)
-		return err
-	}
-	if cm.Data == nil {
-		cm.Data = make(map[string]string)
-	}
-	dhcp, err := ipallocator.NewAllocatorCIDRRange(d.cidr, func(max int, rangeSpec string) (allocator.Interface, error) {
-		return allocator.NewContiguousAllocationMap(max, rangeSpec), nil
-	})
-	if err != nil {
-		return err
-	}
-	str, err := base64.StdEncoding.DecodeString(cm.Data[config.KeyDHCP])
-	if err == nil {
-		err = dhcp.Restore(d.cidr, str)
+	err := retry.RetryOnConflict(configMapRetry, func() error {
+		cm, err := d.client.Get(context.Background(), config.ConfigMapPodTrafficManager, metav1.GetOptions{})
 		if err != nil {
+			log.Errorf("failed to get cm DHCP server, err: %v", err)
 			return err
 		}
-	}
-	if err = f(dhcp); err != nil {
-		return err
-	}
-	_, bytes, err := dhcp.Snapshot()
-	if err != nil {
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		dhcp, err := ipallocator.NewAllocatorCIDRRange(d.cidr, func(max int, rangeSpec string) (allocator.Interface, error) {
+			return allocator.NewContiguousAllocationMap(max, rangeSpec), nil
+		})
+		if err != nil {
+			return err
+		}
+		str, err := base64.StdEncoding.DecodeString(cm.Data[config.KeyDHCP])
+		if err == nil {
+			err = dhcp.Restore(d.cidr, str)
+			if err != nil {
+				return err
+			}
+		}
+		if err = f(dhcp); err != nil {
+			return err
+		}
+		_, bytes, err := dhcp.Snapshot()
+		if err != nil {
+			return err
+		}
+		cm.Data[config.KeyDHCP] = base64.StdEncoding.EncodeToString(bytes)
+		_, err = d.client.Update(context.Background(), cm, metav1.UpdateOptions{})
 		return err
-	}
-	cm.Data[config.KeyDHCP] = base64.StdEncoding.EncodeToString(bytes)
-	_, err = d.client.Update(context.Background(), cm, metav1.UpdateOptions{})
+	})
 	if err != nil {
 		log.Errorf("update dhcp failed, err: %v", err)
 		return err
@@ -142,16 +187,19 @@ func (d *DHCPManager) updateDHCPConfigMap(f func(*ipallocator.Range) error) erro
 }
 
 func (d *DHCPManager) Set(key, value string) error {
-	cm, err := d.client.Get(context.Background(), config.ConfigMapPodTrafficManager, metav1.GetOptions{})
-	if err != nil {
-		log.Errorf("failed to get data, err: %v", err)
+	err := retry.RetryOnConflict(configMapRetry, func() error {
+		cm, err := d.client.Get(context.Background(), config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+		if err != nil {
+			log.Errorf("failed to get data, err: %v", err)
+			return err
+		}
+		if cm.Data == nil {
+			cm.Data = make(map[string]string)
+		}
+		cm.Data[key] = value
+		_, err = d.client.Update(context.Background(), cm, metav1.UpdateOptions{})
 		return err
-	}
-	if cm.Data == nil {
-		cm.Data = make(map[string]string)
-	}
-	cm.Data[key] = value
-	_, err = d.client.Update(context.Background(), cm, metav1.UpdateOptions{})
+	})
 	if err != nil {
 		log.Errorf("update data failed, err: %v", err)
 		return err
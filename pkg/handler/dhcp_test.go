@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/cilium/ipam/service/allocator"
+	"github.com/cilium/ipam/service/ipallocator"
+	v1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
+// newConflictClientset fake clientset not check resource version, reject update with stale resource version like api-server,
+// and every third update, simulate someone else updated it just before, so conflict always happens
+func newConflictClientset(conflicts *int64) *fake.Clientset {
+	clientset := fake.NewSimpleClientset()
+	var updates int
+	clientset.PrependReactor("update", "configmaps", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		cm := action.(k8stesting.UpdateAction).GetObject().(*v1.ConfigMap).DeepCopy()
+		obj, err := clientset.Tracker().Get(action.GetResource(), action.GetNamespace(), cm.Name)
+		if err != nil {
+			return true, nil, err
+		}
+		current := obj.(*v1.ConfigMap).ResourceVersion
+		if updates++; updates%3 == 0 {
+			version, _ := strconv.Atoi(current)
+			current = strconv.Itoa(version + 1)
+			stored := obj.(*v1.ConfigMap).DeepCopy()
+			stored.ResourceVersion = current
+			_ = clientset.Tracker().Update(action.GetResource(), stored, action.GetNamespace())
+		}
+		if cm.ResourceVersion != current {
+			atomic.AddInt64(conflicts, 1)
+			return true, nil, k8serrors.NewConflict(action.GetResource().GroupResource(), cm.Name, nil)
+		}
+		version, _ := strconv.Atoi(current)
+		cm.ResourceVersion = strconv.Itoa(version + 1)
+		return true, cm, clientset.Tracker().Update(action.GetResource(), cm, action.GetNamespace())
+	})
+	return clientset
+}
+
+func TestDHCPConcurrentRentAndRelease(t *testing.T) {
+	var conflicts int64
+	clientset := newConflictClientset(&conflicts)
+	cidr := &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask}
+	dhcp := NewDHCPManager(clientset, "default", cidr)
+	if err := dhcp.InitDHCP(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	const n = 50
+	var lock sync.Mutex
+	var rented = make(map[string]*net.IPNet)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ip, err := dhcp.RentIPRandom(&IPLeaseHolder{Kind: IPLeaseKindPod, Holder: strconv.Itoa(i)})
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			lock.Lock()
+			defer lock.Unlock()
+			if _, ok := rented[ip.IP.String()]; ok {
+				t.Errorf("ip %s is rented twice", ip.IP.String())
+			}
+			rented[ip.IP.String()] = ip
+		}(i)
+	}
+	wg.Wait()
+	if len(rented) != n {
+		t.Fatalf("expect %d ips rented, but got %d", n, len(rented))
+	}
+
+	// release half of them while renting others
+	var released int
+	for _, ip := range rented {
+		if released == n/2 {
+			break
+		}
+		released++
+		wg.Add(2)
+		go func(ip *net.IPNet) {
+			defer wg.Done()
+			if err := dhcp.ReleaseIpToDHCP(ip); err != nil {
+				t.Error(err)
+			}
+		}(ip)
+		go func() {
+			defer wg.Done()
+			if _, err := dhcp.RentIPRandom(&IPLeaseHolder{Kind: IPLeaseKindPod}); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if used := countUsedIPs(t, clientset, cidr); used != n {
+		t.Errorf("expect %d ips in use, but got %d, some rent or release is lost", n, used)
+	}
+	if atomic.LoadInt64(&conflicts) == 0 {
+		t.Errorf("no conflict happened, concurrency is not tested")
+	}
+}
+
+func countUsedIPs(t *testing.T, clientset *fake.Clientset, cidr *net.IPNet) int {
+	cm, err := clientset.CoreV1().ConfigMaps("default").Get(context.Background(), config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dhcp, err := ipallocator.NewAllocatorCIDRRange(cidr, func(max int, rangeSpec string) (allocator.Interface, error) {
+		return allocator.NewContiguousAllocationMap(max, rangeSpec), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	str, err := base64.StdEncoding.DecodeString(cm.Data[config.KeyDHCP])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dhcp.Restore(cidr, str); err != nil {
+		t.Fatal(err)
+	}
+	return dhcp.Used()
+}
+
+func TestReclaimExpiredIPs(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	// fake clientset ignores preconditions, holder renews lease just before reclaiming
+	var renewed bool
+	clientset.PrependReactor("delete", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if preconditions := action.(k8stesting.DeleteAction).GetDeleteOptions().Preconditions; renewed && preconditions != nil {
+			return true, nil, k8serrors.NewConflict(action.GetResource().GroupResource(), action.(k8stesting.DeleteAction).GetName(), nil)
+		}
+		return false, nil, nil
+	})
+	cidr := &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask}
+	dhcp := NewDHCPManager(clientset, "default", cidr)
+	if err := dhcp.InitDHCP(ctx); err != nil {
+		t.Fatal(err)
+	}
+	ip, err := dhcp.RentIPRandom(&IPLeaseHolder{Kind: IPLeaseKindPod, Holder: "productpage"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := countUsedIPs(t, clientset, cidr)
+	lease, err := clientset.CoordinationV1().Leases("default").Get(ctx, IPLeaseName(ip.IP), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: lease.Spec.RenewTime.Add(-2 * ipLeaseDuration)}
+	if _, err = clientset.CoordinationV1().Leases("default").Update(ctx, lease, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	renewed = true
+	if err = dhcp.ReclaimExpiredIPs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if countUsedIPs(t, clientset, cidr) != used {
+		t.Errorf("ip %s renewed by holder should not be released", ip.IP)
+	}
+
+	renewed = false
+	if err = dhcp.ReclaimExpiredIPs(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if countUsedIPs(t, clientset, cidr) != used-1 {
+		t.Errorf("expired ip %s is not released", ip.IP)
+	}
+	if _, err = clientset.CoordinationV1().Leases("default").Get(ctx, IPLeaseName(ip.IP), metav1.GetOptions{}); !k8serrors.IsNotFound(err) {
+		t.Errorf("expired lease of ip %s is not deleted", ip.IP)
+	}
+}
@@ -17,7 +17,9 @@ import (
 	"k8s.io/apimachinery/pkg/util/sets"
 	pkgresource "k8s.io/cli-runtime/pkg/resource"
 	runtimeresource "k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes"
 	v12 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/util/retry"
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 	"sigs.k8s.io/yaml"
 
@@ -30,7 +32,7 @@ import (
 // https://istio.io/latest/docs/ops/deployment/requirements/#ports-used-by-istio
 
 // InjectVPNAndEnvoySidecar patch a sidecar, using iptables to do port-forward let this pod decide should go to 233.254.254.100 or request to 127.0.0.1
-func InjectVPNAndEnvoySidecar(ctx1 context.Context, factory cmdutil.Factory, clientset v12.ConfigMapInterface, namespace, workloads string, c util.PodRouteConfig, headers map[string]string) (err error) {
+func InjectVPNAndEnvoySidecar(ctx1 context.Context, factory cmdutil.Factory, clientset v12.ConfigMapInterface, namespace, workloads string, c util.PodRouteConfig, rule *controlplane.Rule) (err error) {
 	var object *runtimeresource.Info
 	object, err = util.GetUnstructuredObject(factory, namespace, workloads)
 	if err != nil {
@@ -51,53 +53,71 @@ func InjectVPNAndEnvoySidecar(ctx1 context.Context, factory cmdutil.Factory, cli
 	for _, container := range templateSpec.Spec.Containers {
 		port = append(port, container.Ports...)
 	}
-	nodeID := fmt.Sprintf("%s.%s", object.Mapping.Resource.GroupResource().String(), object.Name)
+	managerNamespace := namespace
+	if c.TrafficManagerNamespace != "" {
+		managerNamespace = c.TrafficManagerNamespace
+	}
+	uid, virtualNamespace := getNodeID(object), getVirtualNamespace(namespace, managerNamespace)
+	nodeID := controlplane.NodeID(uid, virtualNamespace)
 
-	err = addEnvoyConfig(clientset, nodeID, c.LocalTunIP, headers, port)
+	err = addEnvoyConfig(clientset, uid, virtualNamespace, rule, port)
 	if err != nil {
 		log.Warnln(err)
 		return err
 	}
 
-	// already inject container vpn and envoy-proxy, do nothing
+	// already inject container vpn and envoy-proxy, by annotation or by old version, do nothing
 	containerNames := sets.New[string]()
 	for _, container := range templateSpec.Spec.Containers {
 		containerNames.Insert(container.Name)
 	}
-	if containerNames.HasAll(config.ContainerSidecarVPN, config.ContainerSidecarEnvoyProxy) {
+	if templateSpec.Annotations[config.AnnotationMesh] != "" || containerNames.HasAll(config.ContainerSidecarVPN, config.ContainerSidecarEnvoyProxy) {
 		// add rollback func to remove envoy config
 		RollbackFuncList = append(RollbackFuncList, func() {
-			err := UnPatchContainer(factory, clientset, namespace, workloads, headers)
+			err := UnPatchContainer(factory, clientset, managerNamespace, namespace, workloads, rule.LocalTunIP)
 			if err != nil {
 				log.Error(err)
 			}
 		})
-		return nil
-	}
-	// (1) add mesh container
-	removePatch, restorePatch := patch(*origin, path)
-	var b []byte
-	b, err = json.Marshal(restorePatch)
-	if err != nil {
-		return err
+		return patchTLSSecret(object, templateSpec, path, c.TLSSecret)
 	}
-
-	mesh.AddMeshContainer(templateSpec, nodeID, c)
 	helper := pkgresource.NewHelper(object.Client, object.Mapping)
-	ps := []P{
-		{
+	var ps []P
+	if len(path) == 0 {
+		// (1) add mesh container, pods without controller
+		removePatch, restorePatch := patch(*origin, path)
+		var b []byte
+		b, err = json.Marshal(restorePatch)
+		if err != nil {
+			return err
+		}
+		mesh.AddMeshContainer(templateSpec, nodeID, c)
+		ps = append(ps, P{
 			Op:    "replace",
 			Path:  "/" + strings.Join(append(path, "spec"), "/"),
 			Value: templateSpec.Spec,
-		},
-		{
-			Op:    "replace",
-			Path:  "/metadata/annotations/probe",
-			Value: b,
-		},
+		})
+		ps = append(ps, removePatch...)
+		// store restore patch
+		annotations := u.GetAnnotations()
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+		annotations[config.AnnotationProbe] = string(b)
+		if c.TLSSecret != "" {
+			annotations[config.AnnotationTLSSecret] = c.TLSSecret
+		}
+		u.SetAnnotations(annotations)
+	} else {
+		// (1) controllers, webhook injects mesh containers and removes probes of new pods by annotation, workloads spec is untouched
+		annotations := withAnnotation(templateSpec.Annotations, config.AnnotationMesh, nodeID)
+		ps = append(ps, templateAnnotationsPatch(path, withAnnotation(annotations, config.AnnotationTLSSecret, c.TLSSecret)))
+		ps = append(ps, templateLabelsPatch(path, templateSpec.Labels, true))
 	}
+	// store who intercepts this workload
+	ps = append(ps, annotationsPatch(setInterceptionAnnotations(u.GetAnnotations(), rule.Owner, nil)))
 	var bytes []byte
-	bytes, err = json.Marshal(append(ps, removePatch...))
+	bytes, err = json.Marshal(ps)
 	if err != nil {
 		return err
 	}
@@ -108,18 +128,20 @@ func InjectVPNAndEnvoySidecar(ctx1 context.Context, factory cmdutil.Factory, cli
 	}
 
 	RollbackFuncList = append(RollbackFuncList, func() {
-		if err := UnPatchContainer(factory, clientset, namespace, workloads, headers); err != nil {
+		if err := UnPatchContainer(factory, clientset, managerNamespace, namespace, workloads, rule.LocalTunIP); err != nil {
 			log.Error(err)
 		}
 	})
-	if err != nil {
+	err = util.RolloutStatus(ctx1, factory, namespace, workloads, time.Minute*60)
+	if err != nil || len(path) == 0 {
 		return err
 	}
-	err = util.RolloutStatus(ctx1, factory, namespace, workloads, time.Minute*60)
-	return err
+	return checkSidecarInjected(factory, object)
 }
 
-func UnPatchContainer(factory cmdutil.Factory, mapInterface v12.ConfigMapInterface, namespace, workloads string, headers map[string]string) error {
+// UnPatchContainer remove rule of localTunIP, if no rule left, remove sidecar containers, mapInterface is configmap of
+// traffic manager in managerNamespace, workloads are in namespace
+func UnPatchContainer(factory cmdutil.Factory, mapInterface v12.ConfigMapInterface, managerNamespace, namespace, workloads string, localTunIP string) error {
 	object, err := util.GetUnstructuredObject(factory, namespace, workloads)
 	if err != nil {
 		return err
@@ -131,32 +153,35 @@ func UnPatchContainer(factory cmdutil.Factory, mapInterface v12.ConfigMapInterfa
 		return err
 	}
 
-	nodeID := fmt.Sprintf("%s.%s", object.Mapping.Resource.GroupResource().String(), object.Name)
-
 	var empty bool
-	empty, err = removeEnvoyConfig(mapInterface, nodeID, headers)
+	empty, err = removeEnvoyConfig(mapInterface, getNodeID(object), getVirtualNamespace(namespace, managerNamespace), localTunIP)
 	if err != nil {
 		log.Warnln(err)
 		return err
 	}
 
 	if empty {
-		mesh.RemoveContainers(templateSpec)
 		helper := pkgresource.NewHelper(object.Client, object.Mapping)
+		var ps []P
+		// containers patched by old version
+		if len(depth) == 0 || templateSpec.Annotations[config.AnnotationMesh] == "" {
+			mesh.RemoveContainers(templateSpec)
+			ps = append(ps, P{
+				Op:    "replace",
+				Path:  "/" + strings.Join(append(depth, "spec"), "/"),
+				Value: templateSpec.Spec,
+			})
+		}
+		if len(depth) != 0 {
+			if _, ok := templateSpec.Annotations[config.AnnotationMesh]; ok {
+				annotations := withAnnotation(templateSpec.Annotations, config.AnnotationMesh, "")
+				ps = append(ps, templateAnnotationsPatch(depth, withAnnotation(annotations, config.AnnotationTLSSecret, "")))
+				ps = append(ps, templateLabelsPatch(depth, templateSpec.Labels, false))
+			}
+		}
+		ps = append(ps, annotationsPatch(removeInterceptionAnnotations(u.GetAnnotations())))
 		var bytes []byte
-		bytes, err = json.Marshal([]struct {
-			Op    string      `json:"op"`
-			Path  string      `json:"path"`
-			Value interface{} `json:"value"`
-		}{{
-			Op:    "replace",
-			Path:  "/" + strings.Join(append(depth, "spec"), "/"),
-			Value: templateSpec.Spec,
-		}, {
-			Op:    "replace",
-			Path:  "/metadata/annotations/probe",
-			Value: "",
-		}})
+		bytes, err = json.Marshal(ps)
 		if err != nil {
 			return err
 		}
@@ -168,102 +193,163 @@ func UnPatchContainer(factory cmdutil.Factory, mapInterface v12.ConfigMapInterfa
 	return err
 }
 
-func addEnvoyConfig(mapInterface v12.ConfigMapInterface, nodeID string, localTUNIP string, headers map[string]string, port []v1.ContainerPort) error {
-	configMap, err := mapInterface.Get(context.Background(), config.ConfigMapPodTrafficManager, metav1.GetOptions{})
-	if err != nil {
-		return err
-	}
-	var v = make([]*controlplane.Virtual, 0)
-	if str, ok := configMap.Data[config.KeyEnvoy]; ok {
-		if err = yaml.Unmarshal([]byte(str), &v); err != nil {
-			return err
-		}
+// patchTLSSecret workloads already injected without tls secret, webhook mounts it into envoy-proxy sidecar of new pods,
+// only one tls secret can be mounted, envoy-proxy terminates tls per listener
+func patchTLSSecret(object *runtimeresource.Info, templateSpec *v1.PodTemplateSpec, path []string, secretName string) error {
+	current := templateSpec.Annotations[config.AnnotationTLSSecret]
+	if secretName == "" || current == secretName {
+		return nil
 	}
-	var index = -1
-	for i, virtual := range v {
-		if nodeID == virtual.Uid {
-			index = i
-			break
-		}
+	if current != "" {
+		return fmt.Errorf("workloads %s already terminates tls with secret %s", object.Name, current)
 	}
-	if index < 0 {
-		v = append(v, &controlplane.Virtual{
-			Uid:   nodeID,
-			Ports: port,
-			Rules: []*controlplane.Rule{{
-				Headers:    headers,
-				LocalTunIP: localTUNIP,
-			}},
-		})
-	} else {
-		v[index].Rules = append(v[index].Rules, &controlplane.Rule{
-			Headers:    headers,
-			LocalTunIP: localTUNIP,
-		})
-		if v[index].Ports == nil {
-			v[index].Ports = port
-		}
+	if len(path) == 0 {
+		return fmt.Errorf("pod %s is already proxied without tls secret, can not mount tls secret %s into it", object.Name, secretName)
 	}
-
-	marshal, err := yaml.Marshal(v)
+	bytes, err := json.Marshal([]P{templateAnnotationsPatch(path, withAnnotation(templateSpec.Annotations, config.AnnotationTLSSecret, secretName))})
 	if err != nil {
 		return err
 	}
-	configMap.Data[config.KeyEnvoy] = string(marshal)
-	_, err = mapInterface.Update(context.Background(), configMap, metav1.UpdateOptions{})
+	_, err = pkgresource.NewHelper(object.Client, object.Mapping).Patch(object.Namespace, object.Name, types.JSONPatchType, bytes, &metav1.PatchOptions{})
 	return err
 }
 
-func removeEnvoyConfig(mapInterface v12.ConfigMapInterface, nodeID string, headers map[string]string) (bool, error) {
-	configMap, err := mapInterface.Get(context.Background(), config.ConfigMapPodTrafficManager, metav1.GetOptions{})
-	if k8serrors.IsNotFound(err) {
-		return true, nil
-	}
+// checkTLSSecret tls secret is read with credentials of user, traffic manager never reads it, so users can only
+// terminate tls with secrets they have permission of
+func checkTLSSecret(ctx context.Context, clientset kubernetes.Interface, namespace, name string) error {
+	secret, err := clientset.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{})
 	if err != nil {
-		return false, err
+		return fmt.Errorf("can not get tls secret %s in namespace %s, err: %v", name, namespace, err)
 	}
-	str, ok := configMap.Data[config.KeyEnvoy]
-	if !ok {
-		return false, errors.New("can not found value for key: envoy-config.yaml")
+	if len(secret.Data[v1.TLSCertKey]) == 0 || len(secret.Data[v1.TLSPrivateKeyKey]) == 0 {
+		return fmt.Errorf("secret %s in namespace %s has no %s or %s", name, namespace, v1.TLSCertKey, v1.TLSPrivateKeyKey)
 	}
-	var v []*controlplane.Virtual
-	if err = yaml.Unmarshal([]byte(str), &v); err != nil {
-		return false, err
+	return nil
+}
+
+// getNodeID envoy node id of workloads, format: group.resource.name, like: deployments.apps.productpage
+func getNodeID(object *runtimeresource.Info) string {
+	return fmt.Sprintf("%s.%s", object.Mapping.Resource.GroupResource().String(), object.Name)
+}
+
+// getVirtualNamespace envoy config of workloads served by cluster-wide traffic manager in other namespace is keyed by
+// namespace, workloads in namespace of traffic manager keep the same as before
+func getVirtualNamespace(namespace, managerNamespace string) string {
+	if namespace == managerNamespace {
+		return ""
 	}
-	for _, virtual := range v {
-		if nodeID == virtual.Uid {
-			for i := 0; i < len(virtual.Rules); i++ {
-				if contains(virtual.Rules[i].Headers, headers) {
-					virtual.Rules = append(virtual.Rules[:i], virtual.Rules[i+1:]...)
-					i--
+	return namespace
+}
+
+// addEnvoyConfig update with resource version, retry if others add or remove rules at the same time
+func addEnvoyConfig(mapInterface v12.ConfigMapInterface, uid, namespace string, rule *controlplane.Rule, port []v1.ContainerPort) error {
+	rule.CreationTimestamp = &metav1.Time{Time: time.Now()}
+	return retry.RetryOnConflict(configMapRetry, func() error {
+		configMap, err := mapInterface.Get(context.Background(), config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		var v = make([]*controlplane.Virtual, 0)
+		if str, ok := configMap.Data[config.KeyEnvoy]; ok {
+			if err = yaml.Unmarshal([]byte(str), &v); err != nil {
+				return err
+			}
+		}
+		var index = -1
+		for i, virtual := range v {
+			if uid == virtual.Uid && namespace == virtual.Namespace {
+				index = i
+				break
+			}
+		}
+		if index < 0 {
+			v = append(v, &controlplane.Virtual{
+				Uid:       uid,
+				Namespace: namespace,
+				Ports:     port,
+				Rules:     []*controlplane.Rule{rule},
+			})
+		} else {
+			var rules []*controlplane.Rule
+			for _, r := range v[index].Rules {
+				// rule of same local tun ip is added by myself last time, replace it
+				if r.LocalTunIP == rule.LocalTunIP {
+					continue
+				}
+				identical, overlap := rule.Conflict(r)
+				if identical {
+					return fmt.Errorf("rule is identical with rule of %s (local tun ip: %s), only one of them can take effect, please use other matchers", r.Owner, r.LocalTunIP)
 				}
+				if overlap {
+					log.Warnf("rule is overlap with rule of %s (local tun ip: %s), some traffic may be routed to %s", r.Owner, r.LocalTunIP, r.Owner)
+				}
+				rules = append(rules, r)
+			}
+			v[index].Rules = append(rules, rule)
+			if v[index].Ports == nil {
+				v[index].Ports = port
 			}
 		}
-	}
-	var empty bool
-	// remove default
-	for i := 0; i < len(v); i++ {
-		if nodeID == v[i].Uid && len(v[i].Rules) == 0 {
-			v = append(v[:i], v[i+1:]...)
-			i--
-			empty = true
+
+		marshal, err := yaml.Marshal(v)
+		if err != nil {
+			return err
 		}
-	}
-	var bytes []byte
-	bytes, err = yaml.Marshal(v)
-	if err != nil {
-		return false, err
-	}
-	configMap.Data[config.KeyEnvoy] = string(bytes)
-	_, err = mapInterface.Update(context.Background(), configMap, metav1.UpdateOptions{})
-	return empty, err
+		if configMap.Data == nil {
+			configMap.Data = make(map[string]string)
+		}
+		configMap.Data[config.KeyEnvoy] = string(marshal)
+		_, err = mapInterface.Update(context.Background(), configMap, metav1.UpdateOptions{})
+		return err
+	})
 }
 
-func contains(a map[string]string, sub map[string]string) bool {
-	for k, v := range sub {
-		if a[k] != v {
-			return false
+// removeEnvoyConfig update with resource version, retry if others add or remove rules at the same time
+func removeEnvoyConfig(mapInterface v12.ConfigMapInterface, uid, namespace string, localTunIP string) (empty bool, err error) {
+	err = retry.RetryOnConflict(configMapRetry, func() error {
+		empty = false
+		configMap, err := mapInterface.Get(context.Background(), config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			empty = true
+			return nil
 		}
-	}
-	return true
+		if err != nil {
+			return err
+		}
+		str, ok := configMap.Data[config.KeyEnvoy]
+		if !ok {
+			return errors.New("can not found value for key: envoy-config.yaml")
+		}
+		var v []*controlplane.Virtual
+		if err = yaml.Unmarshal([]byte(str), &v); err != nil {
+			return err
+		}
+		for _, virtual := range v {
+			if uid == virtual.Uid && namespace == virtual.Namespace {
+				for i := 0; i < len(virtual.Rules); i++ {
+					if virtual.Rules[i].LocalTunIP == localTunIP {
+						virtual.Rules = append(virtual.Rules[:i], virtual.Rules[i+1:]...)
+						i--
+					}
+				}
+			}
+		}
+		// remove default
+		for i := 0; i < len(v); i++ {
+			if uid == v[i].Uid && namespace == v[i].Namespace && len(v[i].Rules) == 0 {
+				v = append(v[:i], v[i+1:]...)
+				i--
+				empty = true
+			}
+		}
+		var bytes []byte
+		bytes, err = yaml.Marshal(v)
+		if err != nil {
+			return err
+		}
+		configMap.Data[config.KeyEnvoy] = string(bytes)
+		_, err = mapInterface.Update(context.Background(), configMap, metav1.UpdateOptions{})
+		return err
+	})
+	return
 }
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
+)
+
+// notInClusterTTL host not in cluster may be resolved to ip of cluster later, like service created after proxy started
+const notInClusterTTL = 30 * time.Second
+
+// hopHeaders headers of one connection, must not be forwarded by proxy, https://www.rfc-editor.org/rfc/rfc9110#section-7.6.1
+var hopHeaders = []string{
+	"Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Proxy-Connection",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// headerProxy a http forward proxy, inject headers of mesh rule to requests which send to cluster,
+// so requests from browser of developer can hit local PC in mesh mode
+type headerProxy struct {
+	headers map[string]string
+	baggage string
+	cidrs   []*net.IPNet
+	lookup  func(host string) ([]net.IP, error)
+	// cache of host is in cluster or not, value is time until host is not in cluster, zero means in cluster
+	cache sync.Map
+	// never use HTTP_PROXY, it usually points to this proxy itself
+	transport http.RoundTripper
+}
+
+// StartHeaderProxy start http proxy on addr, https requests can only be tunneled, can not inject headers
+func (c *ConnectOptions) StartHeaderProxy(ctx context.Context, addr string) error {
+	headers := make(map[string]string)
+	var entries []string
+	for k, v := range c.Headers {
+		value, ok := injectableValue(v)
+		if !ok {
+			log.Warnf("header %s=%s can not be injected, only support exact, prefix and present", k, v)
+			continue
+		}
+		headers[k] = value
+		entries = append(entries, fmt.Sprintf("%s=%s", k, value))
+	}
+	sort.Strings(entries)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	// hosts reached by --extra-cidr are in cluster too
+	cidrs := append(parseCIDRs(c.ExtraCIDR), c.cidrs...)
+	p := &headerProxy{headers: headers, cidrs: cidrs, lookup: net.LookupIP, transport: transport}
+	if c.Baggage {
+		p.baggage = strings.Join(entries, ",")
+	}
+	server := &http.Server{Addr: addr, Handler: p}
+	go func() {
+		<-ctx.Done()
+		_ = server.Close()
+	}()
+	log.Infof("header proxy listening on %s, set environment HTTP_PROXY=http://%s to use it", addr, addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// injectableValue value of header to inject, regex can not be injected
+func injectableValue(v string) (string, bool) {
+	switch {
+	case v == controlplane.MatchPresent:
+		return "1", true
+	case strings.HasPrefix(v, controlplane.MatchPrefix):
+		return strings.TrimPrefix(v, controlplane.MatchPrefix), true
+	case strings.HasPrefix(v, controlplane.MatchRegex):
+		return "", false
+	default:
+		return strings.TrimPrefix(v, controlplane.MatchExact), true
+	}
+}
+
+func (p *headerProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "only support proxy request", http.StatusBadRequest)
+		return
+	}
+	req := r.Clone(r.Context())
+	req.RequestURI = ""
+	removeHopHeaders(req.Header)
+	if p.inCluster(req.URL.Hostname()) {
+		for k, v := range p.headers {
+			req.Header.Set(k, v)
+		}
+		if p.baggage != "" {
+			if baggage := req.Header.Get(controlplane.HeaderBaggage); baggage != "" {
+				req.Header.Set(controlplane.HeaderBaggage, baggage+","+p.baggage)
+			} else {
+				req.Header.Set(controlplane.HeaderBaggage, p.baggage)
+			}
+		}
+	}
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+	removeHopHeaders(resp.Header)
+	for k, values := range resp.Header {
+		for _, v := range values {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	_, _ = io.Copy(w, resp.Body)
+}
+
+// removeHopHeaders remove hop-by-hop headers, and headers listed in Connection
+func removeHopHeaders(header http.Header) {
+	for _, value := range header.Values("Connection") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				header.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		header.Del(name)
+	}
+}
+
+// tunnel https requests, can not see content, only forward it
+func (p *headerProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	remote, err := net.Dial("tcp", r.Host)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		_ = remote.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	conn, _, err := hijacker.Hijack()
+	if err != nil {
+		_ = remote.Close()
+		return
+	}
+	go func() {
+		defer remote.Close()
+		defer conn.Close()
+		_, _ = io.Copy(remote, conn)
+	}()
+	go func() {
+		defer remote.Close()
+		defer conn.Close()
+		_, _ = io.Copy(conn, remote)
+	}()
+}
+
+// inCluster host is ip of cluster or domain can be resolved to ip of cluster
+func (p *headerProxy) inCluster(host string) bool {
+	if v, ok := p.cache.Load(host); ok {
+		if until := v.(time.Time); until.IsZero() || time.Now().Before(until) {
+			return until.IsZero()
+		}
+	}
+	var result bool
+	ips, err := p.lookup(host)
+	if err == nil {
+		for _, ip := range ips {
+			for _, cidr := range p.cidrs {
+				if cidr.Contains(ip) {
+					result = true
+				}
+			}
+		}
+	}
+	if result {
+		p.cache.Store(host, time.Time{})
+	} else {
+		p.cache.Store(host, time.Now().Add(notInClusterTTL))
+	}
+	return result
+}
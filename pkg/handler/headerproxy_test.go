@@ -0,0 +1,123 @@
+package handler
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
+)
+
+func newTestHeaderProxy(cidrs []*net.IPNet) *headerProxy {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	return &headerProxy{
+		headers:   map[string]string{"x-user": "naison"},
+		baggage:   "x-user=naison",
+		cidrs:     cidrs,
+		lookup:    net.LookupIP,
+		transport: transport,
+	}
+}
+
+func TestHeaderProxyInjectHeaders(t *testing.T) {
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Connection", "X-Upstream-Hop")
+		w.Header().Set("X-Upstream-Hop", "1")
+		w.Header().Set("Keep-Alive", "timeout=5")
+		w.Header().Set("X-Upstream", "1")
+	}))
+	defer upstream.Close()
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	_, other, _ := net.ParseCIDR("10.96.0.0/12")
+
+	testcases := []struct {
+		name        string
+		cidrs       []*net.IPNet
+		baggage     string
+		wantUser    string
+		wantBaggage string
+	}{
+		{name: "in cluster", cidrs: []*net.IPNet{loopback}, wantUser: "naison", wantBaggage: "x-user=naison"},
+		{name: "merge baggage", cidrs: []*net.IPNet{loopback}, baggage: "a=1", wantUser: "naison", wantBaggage: "a=1,x-user=naison"},
+		{name: "not in cluster", cidrs: []*net.IPNet{other}, baggage: "a=1", wantBaggage: "a=1"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			proxy := httptest.NewServer(newTestHeaderProxy(tc.cidrs))
+			defer proxy.Close()
+			proxyURL, _ := url.Parse(proxy.URL)
+			client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+			req, _ := http.NewRequest(http.MethodGet, upstream.URL, nil)
+			req.Header.Set("Connection", "X-Hop")
+			req.Header.Set("X-Hop", "1")
+			req.Header.Set("Proxy-Authorization", "Basic Zm9vOmJhcg==")
+			if tc.baggage != "" {
+				req.Header.Set(controlplane.HeaderBaggage, tc.baggage)
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_ = resp.Body.Close()
+
+			if v := got.Get("x-user"); v != tc.wantUser {
+				t.Errorf("expect header x-user %q, but got %q", tc.wantUser, v)
+			}
+			if v := got.Get(controlplane.HeaderBaggage); v != tc.wantBaggage {
+				t.Errorf("expect baggage %q, but got %q", tc.wantBaggage, v)
+			}
+			for _, h := range []string{"X-Hop", "Proxy-Authorization"} {
+				if got.Get(h) != "" {
+					t.Errorf("hop-by-hop header %s of request is forwarded", h)
+				}
+			}
+			for _, h := range []string{"X-Upstream-Hop", "Keep-Alive"} {
+				if resp.Header.Get(h) != "" {
+					t.Errorf("hop-by-hop header %s of response is forwarded", h)
+				}
+			}
+			if resp.Header.Get("X-Upstream") != "1" {
+				t.Errorf("end-to-end header of response is not forwarded")
+			}
+		})
+	}
+}
+
+func TestHeaderProxyInClusterCache(t *testing.T) {
+	_, cidr, _ := net.ParseCIDR("10.96.0.0/12")
+	p := newTestHeaderProxy([]*net.IPNet{cidr})
+	var lookups int
+	ip := net.ParseIP("192.168.1.1")
+	p.lookup = func(host string) ([]net.IP, error) {
+		lookups++
+		return []net.IP{ip}, nil
+	}
+
+	// not in cluster is cached for a while
+	if p.inCluster("productpage") || p.inCluster("productpage") {
+		t.Fatal("host should not be in cluster")
+	}
+	if lookups != 1 {
+		t.Fatalf("expect lookup once in ttl, but got %d", lookups)
+	}
+
+	// service created later, resolved to ip of cluster after ttl
+	ip = net.ParseIP("10.96.0.10")
+	p.cache.Store("productpage", time.Now().Add(-time.Second))
+	if !p.inCluster("productpage") {
+		t.Fatal("host should be in cluster after ttl")
+	}
+
+	// in cluster is cached forever
+	ip = net.ParseIP("192.168.1.1")
+	if !p.inCluster("productpage") || lookups != 2 {
+		t.Fatalf("in cluster should be cached, lookups: %d", lookups)
+	}
+}
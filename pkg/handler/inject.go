@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	runtimeresource "k8s.io/cli-runtime/pkg/resource"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/cmd/util/podcmd"
+	"k8s.io/kubectl/pkg/polymorphichelpers"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
+// templateAnnotationsPatch json patch add operation will replace whole annotations of pod template if exists,
+// webhook injects sidecars into new pods by them, so workloads spec is untouched
+func templateAnnotationsPatch(path []string, annotations map[string]string) P {
+	return P{
+		Op:    "add",
+		Path:  "/" + strings.Join(append(append([]string{}, path...), "metadata", "annotations"), "/"),
+		Value: annotations,
+	}
+}
+
+// templateLabelsPatch add or remove label inject of pod template, cluster-wide webhook only receives pods with it,
+// other pods are created without calling webhook
+func templateLabelsPatch(path []string, labels map[string]string, inject bool) P {
+	var value string
+	if inject {
+		value = "true"
+	}
+	return P{
+		Op:    "add",
+		Path:  "/" + strings.Join(append(append([]string{}, path...), "metadata", "labels"), "/"),
+		Value: withAnnotation(labels, config.LabelInject, value),
+	}
+}
+
+// withAnnotation copy annotations and set key to value, remove key if value is empty
+func withAnnotation(annotations map[string]string, key, value string) map[string]string {
+	result := make(map[string]string, len(annotations)+1)
+	for k, v := range annotations {
+		result[k] = v
+	}
+	if value == "" {
+		delete(result, key)
+	} else {
+		result[key] = value
+	}
+	return result
+}
+
+// interceptedNormal workload is intercepted without mesh, by annotation, or by vpn container patched by old version
+func interceptedNormal(spec *v1.PodTemplateSpec) bool {
+	if spec.Annotations[config.AnnotationProxy] != "" {
+		return true
+	}
+	containers := sets.New[string]()
+	for _, container := range spec.Spec.Containers {
+		containers.Insert(container.Name)
+	}
+	return containers.Has(config.ContainerSidecarVPN) && !containers.Has(config.ContainerSidecarEnvoyProxy)
+}
+
+// checkSidecarInjected webhook failure policy is ignore, if webhook is not working, pods are created without sidecar
+func checkSidecarInjected(factory cmdutil.Factory, object *runtimeresource.Info) error {
+	pod, err := polymorphichelpers.AttachablePodForObjectFn(factory, object.Object, 2*time.Second)
+	if err != nil {
+		return err
+	}
+	if container, _ := podcmd.FindContainerByName(pod, config.ContainerSidecarVPN); container == nil {
+		return fmt.Errorf("sidecar is not injected into pod %s, please check webhook of traffic manager", pod.Name)
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
+// Install install traffic manager by administrator, clients reuse it, never delete or recreate it,
+// so developers need no permission to create rbac
+func (c *ConnectOptions) Install(ctx2 context.Context, o *TrafficManagerOptions) error {
+	o.Namespace = c.managerNamespace()
+	o.Installed = true
+	_, err := InstallTrafficManager(ctx2, c.clientset, o)
+	return err
+}
+
+// Uninstall remove traffic manager and all its resources, whoever installed it, refuse while clients are using it
+func (c *ConnectOptions) Uninstall(ctx2 context.Context, force bool) error {
+	sessions, err := ListSessions(ctx2, c.clientset, c.managerNamespace())
+	if err != nil {
+		return err
+	}
+	if alive := countAliveSessions(sessions); alive != 0 && !force {
+		return fmt.Errorf("%d clients are using traffic manager in namespace %s, use --force to uninstall anyway", alive, c.managerNamespace())
+	}
+	// configmap not exist if nobody connected to traffic manager installed by administrator
+	if err = c.unpatchAll(ctx2, ""); err != nil && !k8serrors.IsNotFound(err) {
+		return err
+	}
+	// workloads intercepted without mesh are not recorded in configmap, only tracked by annotations and locks
+	for _, ns := range servedNamespaces(ctx2, c.clientset, c.managerNamespace()) {
+		unpatchNormal(ctx2, c.factory, c.clientset, ns, interceptedByKubevpn)
+	}
+	cleanup(c.clientset, c.managerNamespace(), config.ConfigMapPodTrafficManager, false)
+	return nil
+}
@@ -0,0 +1,172 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/cilium/ipam/service/ipallocator"
+	log "github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
+const (
+	// ipLeaseDuration if holder not renew ip lease in this duration, traffic manager will reclaim the ip,
+	// long enough to survive laptop sleep for a while
+	ipLeaseDuration = 5 * time.Minute
+	// IPLeaseRenewInterval interval of laptop and sidecar renew ip lease
+	IPLeaseRenewInterval = time.Minute
+
+	IPLeaseKindLaptop = "laptop"
+	IPLeaseKindPod    = "pod"
+)
+
+// IPLeaseHolder who rents ip, local PC or pod with vpn sidecar
+type IPLeaseHolder struct {
+	// Kind laptop or pod
+	Kind string
+	// Holder user@hostname of laptop, or pod name
+	Holder   string
+	Hostname string
+	// Namespace of pod, cluster-wide traffic manager rents ip to pods in many namespaces
+	Namespace string
+}
+
+// IPLeaseName name of lease object of ip, like: kubevpn-traffic-manager.ip-223-254-0-101
+func IPLeaseName(ip net.IP) string {
+	return fmt.Sprintf("%s.ip-%s", config.ConfigMapPodTrafficManager, strings.ReplaceAll(ip.String(), ".", "-"))
+}
+
+// createIPLease ip is just allocated, so existing lease of this ip must be stale, overwrite it
+func (d *DHCPManager) createIPLease(ctx context.Context, ip *net.IPNet, holder *IPLeaseHolder) error {
+	now := metav1.NewMicroTime(time.Now())
+	lease := &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      IPLeaseName(ip.IP),
+			Namespace: d.namespace,
+			Labels: map[string]string{
+				config.ManageBy:     config.ConfigMapPodTrafficManager,
+				config.LabelIPLease: holder.Kind,
+			},
+			Annotations: map[string]string{
+				config.AnnotationIP:        ip.String(),
+				config.AnnotationHostname:  holder.Hostname,
+				config.AnnotationNamespace: holder.Namespace,
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       pointer.String(holder.Holder),
+			LeaseDurationSeconds: pointer.Int32(int32(ipLeaseDuration.Seconds())),
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	}
+	_, err := d.leases.Create(ctx, lease, metav1.CreateOptions{})
+	if k8serrors.IsAlreadyExists(err) {
+		var old *coordinationv1.Lease
+		if old, err = d.leases.Get(ctx, lease.Name, metav1.GetOptions{}); err == nil {
+			lease.ResourceVersion = old.ResourceVersion
+			_, err = d.leases.Update(ctx, lease, metav1.UpdateOptions{})
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create lease of ip %s, err: %v", ip.String(), err)
+	}
+	return nil
+}
+
+// RenewIPLease renew ip lease, if lease is reclaimed by traffic manager, like laptop sleep too long,
+// try to rent the same ip again, otherwise return error, because ip is rented by others
+func (d *DHCPManager) RenewIPLease(ctx context.Context, ip *net.IPNet, holder *IPLeaseHolder) error {
+	lease, err := d.leases.Get(ctx, IPLeaseName(ip.IP), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		log.Warnf("lease of ip %s is reclaimed, try to rent it again", ip.String())
+		err = d.updateDHCPConfigMap(func(r *ipallocator.Range) error {
+			return r.Allocate(ip.IP)
+		})
+		if err != nil {
+			return fmt.Errorf("ip %s is reclaimed and can not rent it again, err: %v", ip.String(), err)
+		}
+		return d.createIPLease(ctx, ip, holder)
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = pointer.String(holder.Holder)
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: time.Now()}
+	_, err = d.leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// KeepIPLease renew ip lease periodically until ctx done
+func (d *DHCPManager) KeepIPLease(ctx context.Context, ip *net.IPNet, holder *IPLeaseHolder) {
+	ticker := time.NewTicker(IPLeaseRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := d.RenewIPLease(ctx, ip, holder); err != nil {
+				log.Errorf("failed to renew lease of ip %s, err: %v", ip.String(), err)
+			}
+		}
+	}
+}
+
+func (d *DHCPManager) deleteIPLease(ctx context.Context, ip *net.IPNet) {
+	err := d.leases.Delete(ctx, IPLeaseName(ip.IP), metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		log.Warnf("failed to delete lease of ip %s, err: %v", ip.String(), err)
+	}
+}
+
+// ListIPLeases list all ip leases
+func (d *DHCPManager) ListIPLeases(ctx context.Context) ([]coordinationv1.Lease, error) {
+	list, err := d.leases.List(ctx, metav1.ListOptions{LabelSelector: config.LabelIPLease})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// ReclaimExpiredIPs release ip which holder not renew lease for a long time, like laptop crash or kill -9,
+// lease is deleted first with preconditions, if holder renewed it, or other replicas reclaimed it and ip is rented
+// again, deletion fails and ip is kept
+func (d *DHCPManager) ReclaimExpiredIPs(ctx context.Context) error {
+	leases, err := d.ListIPLeases(ctx)
+	if err != nil {
+		return err
+	}
+	for i := range leases {
+		if !leaseExpired(&leases[i]) {
+			continue
+		}
+		ip, ipNet, err := net.ParseCIDR(leases[i].Annotations[config.AnnotationIP])
+		if err != nil {
+			continue
+		}
+		err = d.leases.Delete(ctx, leases[i].Name, metav1.DeleteOptions{Preconditions: &metav1.Preconditions{
+			UID:             &leases[i].UID,
+			ResourceVersion: &leases[i].ResourceVersion,
+		}})
+		if err != nil {
+			if !k8serrors.IsNotFound(err) && !k8serrors.IsConflict(err) {
+				log.Errorf("failed to delete expired lease of ip %s, err: %v", ip.String(), err)
+			}
+			continue
+		}
+		log.Infof("lease of ip %s held by %s expired, reclaim it", ip.String(), pointer.StringDeref(leases[i].Spec.HolderIdentity, ""))
+		if err = d.releaseIPs(&net.IPNet{IP: ip, Mask: ipNet.Mask}); err != nil {
+			log.Errorf("failed to reclaim ip %s, err: %v", ip.String(), err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/pointer"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
+const (
+	// lockLeaseDuration if holder not renew lease in this duration, others can take over it without force
+	lockLeaseDuration = 60 * time.Second
+	lockRenewInterval = 20 * time.Second
+)
+
+// WorkloadLock exclusive lock of workload intercepted without mesh, stored as Lease object,
+// avoid two developers overwrite each other
+type WorkloadLock struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+	holder    string
+
+	lock   sync.Mutex
+	lost   bool
+	cancel context.CancelFunc
+}
+
+func lockName(nodeID string) string {
+	return fmt.Sprintf("%s.%s", config.ConfigMapPodTrafficManager, nodeID)
+}
+
+// AcquireWorkloadLock acquire lock of workload, if lock is held by others and not expired, refuse it unless force
+func AcquireWorkloadLock(ctx context.Context, clientset kubernetes.Interface, namespace, nodeID, holder string, force bool) (*WorkloadLock, error) {
+	l := &WorkloadLock{
+		clientset: clientset,
+		namespace: namespace,
+		name:      lockName(nodeID),
+		holder:    holder,
+	}
+	leaseInterface := clientset.CoordinationV1().Leases(namespace)
+	now := metav1.NewMicroTime(time.Now())
+	lease, err := leaseInterface.Get(ctx, l.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		_, err = leaseInterface.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      l.name,
+				Namespace: namespace,
+				Labels:    map[string]string{config.ManageBy: config.ConfigMapPodTrafficManager},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       pointer.String(holder),
+				LeaseDurationSeconds: pointer.Int32(int32(lockLeaseDuration.Seconds())),
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+	} else if err == nil {
+		heldByOthers := lease.Spec.HolderIdentity != nil && *lease.Spec.HolderIdentity != holder
+		if heldByOthers && !leaseExpired(lease) && !force {
+			var since string
+			if lease.Spec.AcquireTime != nil {
+				since = lease.Spec.AcquireTime.Format(time.RFC3339)
+			}
+			return nil, fmt.Errorf("workload %s is intercepted by %s since %s, use --force to take over", nodeID, *lease.Spec.HolderIdentity, since)
+		}
+		if heldByOthers {
+			log.Warnf("take over workload %s from %s", nodeID, *lease.Spec.HolderIdentity)
+			lease.Spec.LeaseTransitions = pointer.Int32(pointer.Int32Deref(lease.Spec.LeaseTransitions, 0) + 1)
+		}
+		lease.Spec.HolderIdentity = pointer.String(holder)
+		lease.Spec.LeaseDurationSeconds = pointer.Int32(int32(lockLeaseDuration.Seconds()))
+		lease.Spec.AcquireTime = &now
+		lease.Spec.RenewTime = &now
+		// update with resource version, if someone else acquire it at the same time, only one will succeed
+		_, err = leaseInterface.Update(ctx, lease, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire lock of workload %s, err: %v", nodeID, err)
+	}
+	var renewCtx context.Context
+	renewCtx, l.cancel = context.WithCancel(context.Background())
+	go l.renew(renewCtx)
+	return l, nil
+}
+
+func leaseExpired(lease *coordinationv1.Lease) bool {
+	if lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+		return true
+	}
+	return lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second).Before(time.Now())
+}
+
+func (l *WorkloadLock) renew(ctx context.Context) {
+	ticker := time.NewTicker(lockRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			held, err := l.held(ctx, true)
+			if err != nil {
+				log.Debugf("failed to renew lock %s, err: %v", l.name, err)
+				continue
+			}
+			if !held {
+				log.Warnf("lock %s is taken over by others, workload is not intercepted by you anymore", l.name)
+				l.lock.Lock()
+				l.lost = true
+				l.lock.Unlock()
+				return
+			}
+		}
+	}
+}
+
+// held check lock is still held by this holder, renew it if needed
+func (l *WorkloadLock) held(ctx context.Context, renew bool) (bool, error) {
+	leaseInterface := l.clientset.CoordinationV1().Leases(l.namespace)
+	lease, err := leaseInterface.Get(ctx, l.name, metav1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+	if lease.Spec.HolderIdentity == nil || *lease.Spec.HolderIdentity != l.holder {
+		return false, nil
+	}
+	if renew {
+		lease.Spec.RenewTime = &metav1.MicroTime{Time: time.Now()}
+		_, err = leaseInterface.Update(ctx, lease, metav1.UpdateOptions{})
+	}
+	return err == nil, err
+}
+
+// Release stop renew and delete lease if still held, return false if lock is taken over by others,
+// in this case, caller should not rollback workload, otherwise will break others
+func (l *WorkloadLock) Release() bool {
+	if l == nil {
+		return true
+	}
+	l.cancel()
+	l.lock.Lock()
+	lost := l.lost
+	l.lock.Unlock()
+	if lost {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+	held, err := l.held(ctx, false)
+	if k8serrors.IsNotFound(err) {
+		return true
+	}
+	if err != nil {
+		log.Warnf("failed to check lock %s, err: %v", l.name, err)
+		return true
+	}
+	if !held {
+		return false
+	}
+	if err = l.clientset.CoordinationV1().Leases(l.namespace).Delete(ctx, l.name, metav1.DeleteOptions{}); err != nil && !k8serrors.IsNotFound(err) {
+		log.Warnf("failed to delete lock %s, err: %v", l.name, err)
+	}
+	return true
+}
+
+// releaseWorkloadLock delete lock of workload directly, used by traffic manager after unpatch expired workload
+func releaseWorkloadLock(ctx context.Context, clientset kubernetes.Interface, namespace, nodeID string) {
+	err := clientset.CoordinationV1().Leases(namespace).Delete(ctx, lockName(nodeID), metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		log.Warnf("failed to delete lock of workload %s, err: %v", nodeID, err)
+	}
+}
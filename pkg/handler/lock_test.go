@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+)
+
+func TestWorkloadLock(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	nodeID := "deployments.apps.productpage"
+
+	alice, err := AcquireWorkloadLock(ctx, clientset, "default", nodeID, "alice@laptop", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer alice.cancel()
+
+	// second holder is refused
+	_, err = AcquireWorkloadLock(ctx, clientset, "default", nodeID, "bob@desktop", false)
+	if err == nil || !strings.Contains(err.Error(), "alice@laptop") {
+		t.Fatalf("expect refused with holder in error, but got %v", err)
+	}
+
+	// force take over
+	bob, err := AcquireWorkloadLock(ctx, clientset, "default", nodeID, "bob@desktop", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bob.cancel()
+	if transitions := leaseTransitions(t, clientset, nodeID); transitions != 1 {
+		t.Fatalf("expect 1 lease transition, but got %d", transitions)
+	}
+
+	// lock is taken over, alice should not rollback workload
+	if alice.Release() {
+		t.Fatal("expect release return false after taken over")
+	}
+	if _, err = clientset.CoordinationV1().Leases("default").Get(ctx, lockName(nodeID), metav1.GetOptions{}); err != nil {
+		t.Fatalf("lock of bob should not be deleted, err: %v", err)
+	}
+
+	// bob crashed, lease expired, alice can take over without force
+	expireLock(t, clientset, nodeID)
+	alice, err = AcquireWorkloadLock(ctx, clientset, "default", nodeID, "alice@laptop", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if transitions := leaseTransitions(t, clientset, nodeID); transitions != 2 {
+		t.Fatalf("expect 2 lease transitions, but got %d", transitions)
+	}
+	if bob.Release() {
+		t.Fatal("expect release return false after taken over")
+	}
+	if !alice.Release() {
+		t.Fatal("expect release return true if still held")
+	}
+	if _, err = clientset.CoordinationV1().Leases("default").Get(ctx, lockName(nodeID), metav1.GetOptions{}); err == nil {
+		t.Fatal("expect lock deleted after release")
+	}
+}
+
+func leaseTransitions(t *testing.T, clientset kubernetes.Interface, nodeID string) int32 {
+	lease, err := clientset.CoordinationV1().Leases("default").Get(context.Background(), lockName(nodeID), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pointer.Int32Deref(lease.Spec.LeaseTransitions, 0)
+}
+
+func expireLock(t *testing.T, clientset kubernetes.Interface, nodeID string) {
+	leaseInterface := clientset.CoordinationV1().Leases("default")
+	lease, err := leaseInterface.Get(context.Background(), lockName(nodeID), metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: time.Now().Add(-2 * lockLeaseDuration)}
+	if _, err = leaseInterface.Update(context.Background(), lease, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,683 @@
+package handler
+
+import (
+	"net"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/util"
+)
+
+// TrafficManagerOptions options of traffic manager, connect creates it with default options,
+// kubevpn install renders or creates it with options given by administrator
+type TrafficManagerOptions struct {
+	Namespace string
+	Image     string
+	// TunnelCIDR tun ip of traffic manager with mask of inner tunnel address pool, like 223.254.0.100/16
+	TunnelCIDR string
+	// Pod scheduling and resource options of traffic manager, webhook injects sidecars with them too
+	Pod *config.PodOptions
+	// Installed installed by administrator, clients reuse it, never delete or recreate it
+	Installed bool
+	// Replicas of traffic manager, replicas link to each other and forward packets to peers registered with them
+	Replicas int32
+	// ClusterWide serves workloads in all namespaces, except namespaces served by their own traffic manager
+	ClusterWide bool
+}
+
+func NewTrafficManagerOptions(namespace string) *TrafficManagerOptions {
+	return &TrafficManagerOptions{
+		Namespace:  namespace,
+		Image:      config.Image,
+		TunnelCIDR: config.TunnelCIDR(),
+		Pod:        config.Pod,
+		Replicas:   1,
+	}
+}
+
+// TrafficManagerObjects all resources of traffic manager
+type TrafficManagerObjects struct {
+	// Namespace only carries label ns, webhook selects pods of this namespace by it, no label if cluster-wide
+	Namespace                    *v1.Namespace
+	ServiceAccount               *v1.ServiceAccount
+	Role                         *rbacv1.Role
+	RoleBinding                  *rbacv1.RoleBinding
+	ClusterRole                  *rbacv1.ClusterRole
+	ClusterRoleBinding           *rbacv1.ClusterRoleBinding
+	Secret                       *v1.Secret
+	Service                      *v1.Service
+	Deployment                   *appsv1.Deployment
+	MutatingWebhookConfiguration *admissionv1.MutatingWebhookConfiguration
+}
+
+// Objects all resources in order of creation
+func (t *TrafficManagerObjects) Objects() []runtime.Object {
+	return []runtime.Object{
+		t.Namespace,
+		t.ServiceAccount,
+		t.Role,
+		t.RoleBinding,
+		t.ClusterRole,
+		t.ClusterRoleBinding,
+		t.Secret,
+		t.Service,
+		t.Deployment,
+		t.MutatingWebhookConfiguration,
+	}
+}
+
+// RenderedObjects resources rendered by kubevpn install --dry-run for GitOps tools, private keys are not rendered into
+// secret, webhook generates them and patches caBundle on startup. namespace is not rendered either, pruning it deletes
+// everything in it, label it instead
+func (t *TrafficManagerObjects) RenderedObjects() []runtime.Object {
+	secret := t.Secret.DeepCopy()
+	secret.Data = nil
+	webhook := t.MutatingWebhookConfiguration.DeepCopy()
+	for i := range webhook.Webhooks {
+		webhook.Webhooks[i].ClientConfig.CABundle = nil
+	}
+	return []runtime.Object{
+		t.ServiceAccount,
+		t.Role,
+		t.RoleBinding,
+		t.ClusterRole,
+		t.ClusterRoleBinding,
+		secret,
+		t.Service,
+		t.Deployment,
+		webhook,
+	}
+}
+
+// GenTrafficManagerObjects generate all resources of traffic manager, serving certificate of webhook is generated too
+func GenTrafficManagerObjects(o *TrafficManagerOptions) (*TrafficManagerObjects, error) {
+	// webhook owns certificate after startup, renews it before expiry and keeps caBundle up to date
+	caCrt, caKey, err := util.GenerateCA(o.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	crt, key, err := util.GenerateServingCert(o.Namespace, caCrt, caKey)
+	if err != nil {
+		return nil, err
+	}
+	_, cidr, err := net.ParseCIDR(o.TunnelCIDR)
+	if err != nil {
+		return nil, err
+	}
+	return &TrafficManagerObjects{
+		Namespace:                    genNamespace(o),
+		ServiceAccount:               genServiceAccount(o),
+		Role:                         genRole(o),
+		RoleBinding:                  genRoleBinding(o),
+		ClusterRole:                  genClusterRole(o),
+		ClusterRoleBinding:           genClusterRoleBinding(o),
+		Secret:                       genSecret(o, caCrt, caKey, crt, key),
+		Service:                      genService(o),
+		Deployment:                   genDeployment(o, cidr),
+		MutatingWebhookConfiguration: genMutatingWebhookConfiguration(o, caCrt),
+	}, nil
+}
+
+const (
+	udp8422  = "8422-for-udp"
+	tcp10800 = "10800-for-tcp"
+	tcp10801 = "10801-replica"
+	tcp9002  = "9002-for-envoy"
+	tcp9004  = "9004-for-accesslog"
+	tcp80    = "80-for-webhook"
+)
+
+func genNamespace(o *TrafficManagerOptions) *v1.Namespace {
+	var labels = map[string]string{"ns": o.Namespace}
+	if o.ClusterWide {
+		labels = nil
+	}
+	return &v1.Namespace{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Namespace"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   o.Namespace,
+			Labels: labels,
+		},
+	}
+}
+
+func genServiceAccount(o *TrafficManagerOptions) *v1.ServiceAccount {
+	return &v1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ServiceAccount"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      config.ConfigMapPodTrafficManager,
+			Namespace: o.Namespace,
+		},
+		AutomountServiceAccountToken: pointer.Bool(true),
+	}
+}
+
+func genRole(o *TrafficManagerOptions) *rbacv1.Role {
+	return &rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "Role"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      config.ConfigMapPodTrafficManager,
+			Namespace: o.Namespace,
+		},
+		Rules: []rbacv1.PolicyRule{{
+			Verbs:         []string{"get", "list", "watch", "create", "update", "patch", "delete"},
+			APIGroups:     []string{""},
+			Resources:     []string{"configmaps", "secrets"},
+			ResourceNames: []string{config.ConfigMapPodTrafficManager},
+		}, {
+			// create can not be restricted by resource names, webhook recreates secret of its certificate if it is gone
+			Verbs:     []string{"create"},
+			APIGroups: []string{""},
+			Resources: []string{"secrets"},
+		}, {
+			// unpatch expired interceptions
+			Verbs:     []string{"get", "list", "watch", "update", "patch"},
+			APIGroups: []string{"apps"},
+			Resources: []string{"deployments", "statefulsets", "replicasets", "daemonsets"},
+		}, {
+			Verbs:     []string{"get", "list", "watch", "delete"},
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+		}, {
+			// workload locks, ip leases and sessions
+			Verbs:     []string{"get", "list", "watch", "create", "update", "delete", "deletecollection"},
+			APIGroups: []string{"coordination.k8s.io"},
+			Resources: []string{"leases"},
+		}, {
+			// webhook injects sidecars which forward traffic to traffic manager service
+			Verbs:         []string{"get"},
+			APIGroups:     []string{""},
+			Resources:     []string{"services"},
+			ResourceNames: []string{config.ConfigMapPodTrafficManager},
+		}, {
+			// clean up itself after all sessions expired
+			Verbs:         []string{"delete"},
+			APIGroups:     []string{""},
+			Resources:     []string{"services", "serviceaccounts"},
+			ResourceNames: []string{config.ConfigMapPodTrafficManager},
+		}, {
+			Verbs:         []string{"delete"},
+			APIGroups:     []string{"apps"},
+			Resources:     []string{"deployments"},
+			ResourceNames: []string{config.ConfigMapPodTrafficManager},
+		}, {
+			Verbs:         []string{"delete"},
+			APIGroups:     []string{"rbac.authorization.k8s.io"},
+			Resources:     []string{"roles", "rolebindings"},
+			ResourceNames: []string{config.ConfigMapPodTrafficManager},
+		}},
+	}
+}
+
+func genRoleBinding(o *TrafficManagerOptions) *rbacv1.RoleBinding {
+	return &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "RoleBinding"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      config.ConfigMapPodTrafficManager,
+			Namespace: o.Namespace,
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind: "ServiceAccount",
+			//APIGroup:  "rbac.authorization.k8s.io",
+			Name:      config.ConfigMapPodTrafficManager,
+			Namespace: o.Namespace,
+		}},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "Role",
+			Name:     config.ConfigMapPodTrafficManager,
+		},
+	}
+}
+
+// genClusterRole cluster scoped permissions of traffic manager, only on its own MutatingWebhookConfiguration,
+// cluster-wide traffic manager serves workloads in all namespaces
+func genClusterRole(o *TrafficManagerOptions) *rbacv1.ClusterRole {
+	name := config.ConfigMapPodTrafficManager + "." + o.Namespace
+	rules := []rbacv1.PolicyRule{{
+		Verbs:         []string{"get", "update", "patch", "delete"},
+		APIGroups:     []string{"admissionregistration.k8s.io"},
+		Resources:     []string{"mutatingwebhookconfigurations"},
+		ResourceNames: []string{name},
+	}, {
+		Verbs:         []string{"delete"},
+		APIGroups:     []string{"rbac.authorization.k8s.io"},
+		Resources:     []string{"clusterroles", "clusterrolebindings"},
+		ResourceNames: []string{name},
+	}}
+	if o.ClusterWide {
+		rules = append(rules, rbacv1.PolicyRule{
+			Verbs:     []string{"list"},
+			APIGroups: []string{""},
+			Resources: []string{"namespaces"},
+		}, rbacv1.PolicyRule{
+			// unpatch expired interceptions in all namespaces
+			Verbs:     []string{"get", "list", "watch", "update", "patch"},
+			APIGroups: []string{"apps"},
+			Resources: []string{"deployments", "statefulsets", "replicasets", "daemonsets"},
+		}, rbacv1.PolicyRule{
+			Verbs:     []string{"get", "list", "watch", "delete"},
+			APIGroups: []string{""},
+			Resources: []string{"pods"},
+		}, rbacv1.PolicyRule{
+			// workload locks in namespace of workloads
+			Verbs:     []string{"get", "list", "watch", "create", "update", "delete"},
+			APIGroups: []string{"coordination.k8s.io"},
+			Resources: []string{"leases"},
+		})
+	}
+	return &rbacv1.ClusterRole{
+		TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{config.ManageBy: config.ConfigMapPodTrafficManager},
+		},
+		Rules: rules,
+	}
+}
+
+func genClusterRoleBinding(o *TrafficManagerOptions) *rbacv1.ClusterRoleBinding {
+	name := config.ConfigMapPodTrafficManager + "." + o.Namespace
+	return &rbacv1.ClusterRoleBinding{
+		TypeMeta: metav1.TypeMeta{APIVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRoleBinding"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name,
+			Labels: map[string]string{config.ManageBy: config.ConfigMapPodTrafficManager},
+		},
+		Subjects: []rbacv1.Subject{{
+			Kind:      "ServiceAccount",
+			Name:      config.ConfigMapPodTrafficManager,
+			Namespace: o.Namespace,
+		}},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: "rbac.authorization.k8s.io",
+			Kind:     "ClusterRole",
+			Name:     name,
+		},
+	}
+}
+
+// genSecret reason why not use v1.SecretTypeTls is because it needs key called tls.crt and tls.key, but tls.key can not as env variable
+// ➜  ~ export tls.key=a
+// export: not valid in this context: tls.key
+func genSecret(o *TrafficManagerOptions, caCrt, caKey, crt, key []byte) *v1.Secret {
+	return &v1.Secret{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Secret"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      config.ConfigMapPodTrafficManager,
+			Namespace: o.Namespace,
+		},
+		Data: map[string][]byte{
+			config.TLSCertKey:         crt,
+			config.TLSPrivateKeyKey:   key,
+			config.TLSCAKey:           caCrt,
+			config.TLSCAPrivateKeyKey: caKey,
+		},
+		Type: v1.SecretTypeOpaque,
+	}
+}
+
+func genService(o *TrafficManagerOptions) *v1.Service {
+	return &v1.Service{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "Service"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      config.ConfigMapPodTrafficManager,
+			Namespace: o.Namespace,
+		},
+		Spec: v1.ServiceSpec{
+			Ports: []v1.ServicePort{{
+				Name:       udp8422,
+				Protocol:   v1.ProtocolUDP,
+				Port:       8422,
+				TargetPort: intstr.FromInt(8422),
+			}, {
+				Name:       tcp10800,
+				Protocol:   v1.ProtocolTCP,
+				Port:       10800,
+				TargetPort: intstr.FromInt(10800),
+			}, {
+				Name:       tcp9002,
+				Protocol:   v1.ProtocolTCP,
+				Port:       9002,
+				TargetPort: intstr.FromInt(9002),
+			}, {
+				Name:       tcp9004,
+				Protocol:   v1.ProtocolTCP,
+				Port:       config.PortAccessLog,
+				TargetPort: intstr.FromInt(config.PortAccessLog),
+			}, {
+				Name:       tcp80,
+				Protocol:   v1.ProtocolTCP,
+				Port:       80,
+				TargetPort: intstr.FromInt(80),
+			}},
+			Selector: map[string]string{"app": config.ConfigMapPodTrafficManager},
+			Type:     v1.ServiceTypeClusterIP,
+		},
+	}
+}
+
+func genDeployment(o *TrafficManagerOptions, cidr *net.IPNet) *appsv1.Deployment {
+	var labels map[string]string
+	if o.Installed {
+		labels = map[string]string{config.LabelInstalled: "true"}
+	}
+	if o.ClusterWide {
+		if labels == nil {
+			labels = map[string]string{}
+		}
+		labels[config.LabelClusterWide] = "true"
+	}
+	podLabels := map[string]string{"app": config.ConfigMapPodTrafficManager}
+	for k, v := range o.Pod.Labels {
+		if k != "app" {
+			podLabels[k] = v
+		}
+	}
+	resources := o.Pod.GetResources()
+	replicas := o.Replicas
+	if replicas < 1 {
+		replicas = 1
+	}
+	var affinity *v1.Affinity
+	if replicas > 1 {
+		// spread replicas across nodes, draining one node does not drop all of them
+		affinity = &v1.Affinity{
+			PodAntiAffinity: &v1.PodAntiAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []v1.WeightedPodAffinityTerm{{
+					Weight: 100,
+					PodAffinityTerm: v1.PodAffinityTerm{
+						LabelSelector: &metav1.LabelSelector{
+							MatchLabels: map[string]string{"app": config.ConfigMapPodTrafficManager},
+						},
+						TopologyKey: v1.LabelHostname,
+					},
+				}},
+			},
+		}
+	}
+	maxUnavailable, maxSurge := intstr.FromInt(0), intstr.FromInt(1)
+	return &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      config.ConfigMapPodTrafficManager,
+			Namespace: o.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: pointer.Int32(replicas),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: map[string]string{"app": config.ConfigMapPodTrafficManager},
+			},
+			// new replica is ready before old one terminating, clients move to other replicas while it drains
+			Strategy: appsv1.DeploymentStrategy{
+				Type: appsv1.RollingUpdateDeploymentStrategyType,
+				RollingUpdate: &appsv1.RollingUpdateDeployment{
+					MaxUnavailable: &maxUnavailable,
+					MaxSurge:       &maxSurge,
+				},
+			},
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels:      podLabels,
+					Annotations: o.Pod.Annotations,
+				},
+				Spec: v1.PodSpec{
+					ServiceAccountName: config.ConfigMapPodTrafficManager,
+					Volumes: []v1.Volume{{
+						Name: config.VolumeEnvoyConfig,
+						VolumeSource: v1.VolumeSource{
+							ConfigMap: &v1.ConfigMapVolumeSource{
+								LocalObjectReference: v1.LocalObjectReference{
+									Name: config.ConfigMapPodTrafficManager,
+								},
+								Items: []v1.KeyToPath{
+									{
+										Key:  config.KeyEnvoy,
+										Path: "envoy-config.yaml",
+									},
+								},
+								// configmap is created by first client, pre-installed traffic manager starts before it
+								Optional: pointer.Bool(true),
+							},
+						},
+					}},
+					Containers: []v1.Container{
+						{
+							Name:    config.ContainerSidecarVPN,
+							Image:   o.Image,
+							Command: []string{"/bin/sh", "-c"},
+							Args: []string{`
+sysctl net.ipv4.ip_forward=1
+update-alternatives --set iptables /usr/sbin/iptables-legacy
+iptables -F
+iptables -P INPUT ACCEPT
+iptables -P FORWARD ACCEPT
+iptables -t nat -A POSTROUTING -s ${CIDR} -o eth0 -j MASQUERADE
+kubevpn serve -L "tcp://:10800" -L "tun://:8422?net=${TrafficManagerIP}" -L "replica://:10801" --debug=true`,
+							},
+							EnvFrom: []v1.EnvFromSource{{
+								SecretRef: &v1.SecretEnvSource{
+									LocalObjectReference: v1.LocalObjectReference{
+										Name: config.ConfigMapPodTrafficManager,
+									},
+								},
+							}},
+							Env: []v1.EnvVar{
+								{
+									Name:  "CIDR",
+									Value: cidr.String(),
+								},
+								{
+									Name:  config.EnvTunnelCIDR,
+									Value: o.TunnelCIDR,
+								},
+								{
+									Name:  "TrafficManagerIP",
+									Value: o.TunnelCIDR,
+								},
+								{
+									Name: config.EnvPodNamespace,
+									ValueFrom: &v1.EnvVarSource{
+										FieldRef: &v1.ObjectFieldSelector{
+											FieldPath: "metadata.namespace",
+										},
+									},
+								},
+								{
+									Name: config.EnvPodIP,
+									ValueFrom: &v1.EnvVarSource{
+										FieldRef: &v1.ObjectFieldSelector{
+											FieldPath: "status.podIP",
+										},
+									},
+								},
+							},
+							Ports: []v1.ContainerPort{{
+								Name:          udp8422,
+								ContainerPort: 8422,
+								Protocol:      v1.ProtocolUDP,
+							}, {
+								Name:          tcp10800,
+								ContainerPort: 10800,
+								Protocol:      v1.ProtocolTCP,
+							}, {
+								Name:          tcp10801,
+								ContainerPort: config.PortReplica,
+								Protocol:      v1.ProtocolTCP,
+							}},
+							ReadinessProbe: &v1.Probe{
+								ProbeHandler: v1.ProbeHandler{
+									TCPSocket: &v1.TCPSocketAction{Port: intstr.FromInt(10800)},
+								},
+								PeriodSeconds: 2,
+							},
+							// keep serving while clients port-forward to other replicas
+							Lifecycle: &v1.Lifecycle{
+								PreStop: &v1.LifecycleHandler{
+									Exec: &v1.ExecAction{Command: []string{"sleep", "10"}},
+								},
+							},
+							Resources:       resources,
+							ImagePullPolicy: v1.PullIfNotPresent,
+							SecurityContext: &v1.SecurityContext{
+								Capabilities: &v1.Capabilities{
+									Add: []v1.Capability{
+										"NET_ADMIN",
+										//"SYS_MODULE",
+									},
+								},
+								RunAsUser:  pointer.Int64(0),
+								Privileged: pointer.Bool(true),
+							},
+						},
+						{
+							Name:    config.ContainerSidecarControlPlane,
+							Image:   o.Image,
+							Command: []string{"kubevpn"},
+							Args:    []string{"control-plane", "--source", "configmap"},
+							Env: []v1.EnvVar{{
+								Name: config.EnvPodNamespace,
+								ValueFrom: &v1.EnvVarSource{
+									FieldRef: &v1.ObjectFieldSelector{
+										FieldPath: "metadata.namespace",
+									},
+								},
+							}, {
+								Name:  config.EnvTunnelCIDR,
+								Value: o.TunnelCIDR,
+							}},
+							Ports: []v1.ContainerPort{{
+								Name:          tcp9002,
+								ContainerPort: 9002,
+								Protocol:      v1.ProtocolTCP,
+							}, {
+								Name:          tcp9004,
+								ContainerPort: config.PortAccessLog,
+								Protocol:      v1.ProtocolTCP,
+							}},
+							VolumeMounts: []v1.VolumeMount{
+								{
+									Name:      config.VolumeEnvoyConfig,
+									ReadOnly:  true,
+									MountPath: "/etc/envoy",
+								},
+							},
+							ImagePullPolicy: v1.PullIfNotPresent,
+							Resources:       resources,
+						},
+						{
+							Name:    "webhook",
+							Image:   o.Image,
+							Command: []string{"kubevpn"},
+							Args:    []string{"webhook"},
+							Ports: []v1.ContainerPort{{
+								Name:          tcp80,
+								ContainerPort: 80,
+								Protocol:      v1.ProtocolTCP,
+							}},
+							EnvFrom: []v1.EnvFromSource{{
+								SecretRef: &v1.SecretEnvSource{
+									LocalObjectReference: v1.LocalObjectReference{
+										Name: config.ConfigMapPodTrafficManager,
+									},
+								},
+							}},
+							Env: []v1.EnvVar{{
+								Name: config.EnvPodNamespace,
+								ValueFrom: &v1.EnvVarSource{
+									FieldRef: &v1.ObjectFieldSelector{
+										FieldPath: "metadata.namespace",
+									},
+								},
+							}, {
+								Name:  config.EnvTunnelCIDR,
+								Value: o.TunnelCIDR,
+							}, {
+								Name:  config.EnvImage,
+								Value: o.Image,
+							}, {
+								Name:  config.EnvPodOptions,
+								Value: o.Pod.String(),
+							}},
+							ImagePullPolicy: v1.PullIfNotPresent,
+							Resources:       resources,
+						},
+					},
+					RestartPolicy:     v1.RestartPolicyAlways,
+					Affinity:          affinity,
+					PriorityClassName: o.Pod.GetPriorityClassName(),
+					NodeSelector:      o.Pod.NodeSelector,
+					Tolerations:       o.Pod.Tolerations,
+					ImagePullSecrets:  o.Pod.ImagePullSecrets,
+				},
+			},
+		},
+	}
+}
+
+func genMutatingWebhookConfiguration(o *TrafficManagerOptions, caCrt []byte) *admissionv1.MutatingWebhookConfiguration {
+	// same as label ns of namespace
+	selector := &metav1.LabelSelector{MatchLabels: map[string]string{"ns": o.Namespace}}
+	var objectSelector *metav1.LabelSelector
+	if o.ClusterWide {
+		// namespaces served by their own traffic manager carry label ns
+		selector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      "ns",
+			Operator: metav1.LabelSelectorOpDoesNotExist,
+		}, {
+			Key:      v1.LabelMetadataName,
+			Operator: metav1.LabelSelectorOpNotIn,
+			Values:   []string{metav1.NamespaceSystem, o.Namespace},
+		}}}
+		// only pods of intercepted workloads, pod churn of whole cluster does not depend on traffic manager
+		objectSelector = &metav1.LabelSelector{MatchExpressions: []metav1.LabelSelectorRequirement{{
+			Key:      config.LabelInject,
+			Operator: metav1.LabelSelectorOpExists,
+		}}}
+	}
+	return &admissionv1.MutatingWebhookConfiguration{
+		TypeMeta: metav1.TypeMeta{APIVersion: "admissionregistration.k8s.io/v1", Kind: "MutatingWebhookConfiguration"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: config.ConfigMapPodTrafficManager + "." + o.Namespace,
+		},
+		Webhooks: []admissionv1.MutatingWebhook{{
+			Name: config.ConfigMapPodTrafficManager + ".naison.io", // no sense
+			ClientConfig: admissionv1.WebhookClientConfig{
+				Service: &admissionv1.ServiceReference{
+					Namespace: o.Namespace,
+					Name:      config.ConfigMapPodTrafficManager,
+					Path:      pointer.String("/pods"),
+					Port:      pointer.Int32(80),
+				},
+				CABundle: caCrt,
+			},
+			Rules: []admissionv1.RuleWithOperations{{
+				Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Delete},
+				Rule: admissionv1.Rule{
+					APIGroups:   []string{""},
+					APIVersions: []string{"v1"},
+					Resources:   []string{"pods"},
+					Scope:       (*admissionv1.ScopeType)(pointer.String(string(admissionv1.NamespacedScope))),
+				},
+			}},
+			FailurePolicy: (*admissionv1.FailurePolicyType)(pointer.String(string(admissionv1.Ignore))),
+			// namespaces with label ns, or all namespaces without it if cluster-wide
+			NamespaceSelector: selector,
+			ObjectSelector:    objectSelector,
+			SideEffects:       (*admissionv1.SideEffectClass)(pointer.String(string(admissionv1.SideEffectClassNone))),
+			// pods are created without sidecar if traffic manager is slow, clients check it after rollout
+			TimeoutSeconds:          pointer.Int32(5),
+			AdmissionReviewVersions: []string{"v1", "v1beta1"},
+			ReinvocationPolicy:      (*admissionv1.ReinvocationPolicyType)(pointer.String(string(admissionv1.NeverReinvocationPolicy))),
+		}},
+	}
+}
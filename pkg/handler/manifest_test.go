@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/utils/pointer"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
+func TestGenTrafficManagerObjects(t *testing.T) {
+	o := NewTrafficManagerOptions("test")
+	o.Image = "registry.example.com/kubevpn:latest"
+	o.TunnelCIDR = "198.18.0.100/16"
+	o.Pod = &config.PodOptions{
+		Resources:         &v1.ResourceRequirements{Requests: v1.ResourceList{v1.ResourceCPU: resource.MustParse("100m")}},
+		NodeSelector:      map[string]string{"kubernetes.io/os": "linux"},
+		Tolerations:       []v1.Toleration{{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "infra", Effect: v1.TaintEffectNoSchedule}},
+		PriorityClassName: pointer.String(""),
+		ImagePullSecrets:  []v1.LocalObjectReference{{Name: "regcred"}},
+		Labels:            map[string]string{"app": "override", "team": "infra"},
+		Annotations:       map[string]string{"sidecar.istio.io/inject": "false"},
+	}
+	o.Installed = true
+	o.Replicas = 3
+	objects, err := GenTrafficManagerObjects(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, object := range objects.Objects() {
+		if object.GetObjectKind().GroupVersionKind().Kind == "" {
+			t.Errorf("kind of %T is empty, can not be applied", object)
+		}
+	}
+	for _, object := range objects.RenderedObjects() {
+		switch object := object.(type) {
+		case *v1.Namespace:
+			t.Errorf("namespace should not be rendered, pruning it deletes everything in it")
+		case *v1.Secret:
+			if len(object.Data) != 0 {
+				t.Errorf("private keys should not be rendered")
+			}
+		}
+	}
+	if objects.Secret.Data == nil {
+		t.Errorf("rendering should not modify secret of install")
+	}
+
+	deployment := objects.Deployment
+	if deployment.Labels[config.LabelInstalled] != "true" {
+		t.Errorf("installed traffic manager is not labeled")
+	}
+	if *deployment.Spec.Replicas != 3 || deployment.Spec.Strategy.RollingUpdate.MaxUnavailable.IntValue() != 0 {
+		t.Errorf("replicas not applied or rolling update drops replicas: %v, %v", *deployment.Spec.Replicas, deployment.Spec.Strategy)
+	}
+	spec := deployment.Spec.Template.Spec
+	if spec.Affinity == nil || spec.Affinity.PodAntiAffinity == nil {
+		t.Errorf("replicas are not spread across nodes")
+	}
+	if spec.NodeSelector["kubernetes.io/os"] != "linux" || len(spec.Tolerations) != 1 || len(spec.ImagePullSecrets) != 1 {
+		t.Errorf("scheduling options not applied: %v, %v, %v", spec.NodeSelector, spec.Tolerations, spec.ImagePullSecrets)
+	}
+	if spec.PriorityClassName != "" {
+		t.Errorf("expect no priority class, but got %s", spec.PriorityClassName)
+	}
+	meta := deployment.Spec.Template.ObjectMeta
+	if meta.Labels["app"] != config.ConfigMapPodTrafficManager || meta.Labels["team"] != "infra" {
+		t.Errorf("pod labels not applied or selector label is overridden: %v", meta.Labels)
+	}
+	if meta.Annotations["sidecar.istio.io/inject"] != "false" {
+		t.Errorf("pod annotations not applied: %v", meta.Annotations)
+	}
+	for _, container := range spec.Containers {
+		if container.Image != o.Image {
+			t.Errorf("image of container %s is %s, expect %s", container.Name, container.Image, o.Image)
+		}
+		if !container.Resources.Requests.Cpu().Equal(resource.MustParse("100m")) {
+			t.Errorf("resources of container %s not applied: %v", container.Name, container.Resources)
+		}
+	}
+	if cidr := tunnelCIDROf(deployment); cidr != o.TunnelCIDR {
+		t.Errorf("expect tunnel cidr %s, but got %s", o.TunnelCIDR, cidr)
+	}
+
+	ca := objects.Secret.Data[config.TLSCAKey]
+	if string(objects.MutatingWebhookConfiguration.Webhooks[0].ClientConfig.CABundle) != string(ca) {
+		t.Errorf("caBundle of webhook not match CA in secret")
+	}
+	if objects.Namespace.Labels["ns"] != "test" {
+		t.Errorf("namespace is not labeled for webhook")
+	}
+}
+
+func TestGenClusterWideTrafficManagerObjects(t *testing.T) {
+	o := NewTrafficManagerOptions("kubevpn")
+	o.Installed = true
+	o.ClusterWide = true
+	objects, err := GenTrafficManagerObjects(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := objects.Namespace.Labels["ns"]; ok {
+		t.Errorf("namespace of cluster-wide traffic manager should not be labeled, it would be excluded by webhook")
+	}
+	if objects.Deployment.Labels[config.LabelClusterWide] != "true" || objects.Deployment.Labels[config.LabelInstalled] != "true" {
+		t.Errorf("cluster-wide traffic manager is not labeled: %v", objects.Deployment.Labels)
+	}
+	selector := objects.MutatingWebhookConfiguration.Webhooks[0].NamespaceSelector
+	if len(selector.MatchLabels) != 0 || len(selector.MatchExpressions) != 2 {
+		t.Errorf("webhook should select all namespaces without their own traffic manager, but got %v", selector)
+	}
+	webhook := objects.MutatingWebhookConfiguration.Webhooks[0]
+	if webhook.ObjectSelector == nil || len(webhook.ObjectSelector.MatchExpressions) != 1 || webhook.ObjectSelector.MatchExpressions[0].Key != config.LabelInject {
+		t.Errorf("cluster-wide webhook should only receive pods of intercepted workloads, but got %v", webhook.ObjectSelector)
+	}
+	if webhook.TimeoutSeconds == nil || *webhook.TimeoutSeconds > 5 {
+		t.Errorf("pod creation should not wait for traffic manager long")
+	}
+	var clusterWide bool
+	for _, rule := range objects.ClusterRole.Rules {
+		for _, resource := range rule.Resources {
+			clusterWide = clusterWide || resource == "deployments"
+		}
+	}
+	if !clusterWide {
+		t.Errorf("cluster role can not unpatch workloads in other namespaces: %v", objects.ClusterRole.Rules)
+	}
+}
+
+func TestTrafficManagerCanNotReadSecretsOfWorkloads(t *testing.T) {
+	for _, clusterWide := range []bool{false, true} {
+		o := NewTrafficManagerOptions("kubevpn")
+		o.ClusterWide = clusterWide
+		objects, err := GenTrafficManagerObjects(o)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules := append(objects.Role.Rules, objects.ClusterRole.Rules...)
+		for _, rule := range rules {
+			for _, resource := range rule.Resources {
+				// tls secrets of workloads are mounted into envoy-proxy sidecar by kubelet
+				if resource == "secrets" && len(rule.ResourceNames) == 0 && sets.New[string](rule.Verbs...).Has("get") {
+					t.Errorf("traffic manager (cluster-wide: %v) should not get any secret: %v", clusterWide, rule)
+				}
+			}
+		}
+	}
+}
+
+func TestWebhookCanRecreateCertificateSecret(t *testing.T) {
+	objects, err := GenTrafficManagerObjects(NewTrafficManagerOptions("test"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rule := range objects.Role.Rules {
+		// api-server rejects create with resource names
+		if sets.New[string](rule.Resources...).Has("secrets") && sets.New[string](rule.Verbs...).Has("create") && len(rule.ResourceNames) == 0 {
+			return
+		}
+	}
+	t.Errorf("webhook can not create secret of its certificate: %v", objects.Role.Rules)
+}
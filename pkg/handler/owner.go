@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	authenticationv1alpha1 "k8s.io/api/authentication/v1alpha1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
+)
+
+// getOwner who is using kubevpn, user is kubernetes user, not user of operating system
+func (c *ConnectOptions) getOwner(ctx context.Context) *controlplane.Owner {
+	var owner = &controlplane.Owner{User: "unknown", Hostname: "unknown"}
+	if hostname, err := os.Hostname(); err == nil {
+		owner.Hostname = hostname
+	}
+	review, err := c.clientset.AuthenticationV1alpha1().SelfSubjectReviews().Create(ctx, &authenticationv1alpha1.SelfSubjectReview{}, metav1.CreateOptions{})
+	if err == nil && review.Status.UserInfo.Username != "" {
+		owner.User = review.Status.UserInfo.Username
+		return owner
+	}
+	// SelfSubjectReview is alpha feature, fallback to user of current context in kubeconfig
+	log.Debugf("can not get user by SelfSubjectReview, err: %v", err)
+	if rawConfig, err := c.factory.ToRawKubeConfigLoader().RawConfig(); err == nil {
+		if context, ok := rawConfig.Contexts[rawConfig.CurrentContext]; ok && context.AuthInfo != "" {
+			owner.User = context.AuthInfo
+		}
+	}
+	return owner
+}
+
+// setInterceptionAnnotations record who intercepts this workload, when and when to expire
+func setInterceptionAnnotations(annotations map[string]string, owner *controlplane.Owner, expire *metav1.Time) map[string]string {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	b, _ := json.Marshal(owner)
+	annotations[config.AnnotationOwner] = string(b)
+	annotations[config.AnnotationStartTime] = time.Now().Format(time.RFC3339)
+	if expire != nil {
+		annotations[config.AnnotationExpire] = expire.Format(time.RFC3339)
+	} else {
+		delete(annotations, config.AnnotationExpire)
+	}
+	return annotations
+}
+
+// removeInterceptionAnnotations remove annotations added by kubevpn
+func removeInterceptionAnnotations(annotations map[string]string) map[string]string {
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	for _, key := range []string{config.AnnotationOwner, config.AnnotationStartTime, config.AnnotationExpire, config.AnnotationProbe, config.AnnotationTLSSecret} {
+		delete(annotations, key)
+	}
+	return annotations
+}
+
+// interceptionExpired workload intercepted by normal mode is expired or not
+func interceptionExpired(u *unstructured.Unstructured, now time.Time) bool {
+	value, ok := u.GetAnnotations()[config.AnnotationExpire]
+	if !ok {
+		return false
+	}
+	expire, err := time.Parse(time.RFC3339, value)
+	return err == nil && expire.Before(now)
+}
+
+// annotationsPatch json patch add operation will replace whole annotations if exists
+func annotationsPatch(annotations map[string]string) P {
+	return P{
+		Op:    "add",
+		Path:  "/metadata/annotations",
+		Value: annotations,
+	}
+}
@@ -0,0 +1,186 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/client-go/kubernetes"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/utils/pointer"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/util"
+)
+
+// ReapExpired runs in traffic manager, unpatch expired interceptions, reclaim expired ips and clean up traffic manager
+// itself after all sessions expired periodically, someone closed laptop without cleanup, workloads will be intercepted forever
+func ReapExpired(ctx context.Context, factory cmdutil.Factory, clientset kubernetes.Interface, namespace string, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// clients need time to register session after traffic manager startup
+	lastActive := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reapExpiredMesh(ctx, factory, clientset, namespace)
+			for _, ns := range servedNamespaces(ctx, clientset, namespace) {
+				reapExpiredNormal(ctx, factory, clientset, ns)
+			}
+			reapExpiredIPs(ctx, clientset, namespace)
+			reapIdleTrafficManager(ctx, clientset, namespace, &lastActive)
+		}
+	}
+}
+
+// reapExpiredMesh remove expired rules, if no rule left, remove sidecar containers
+func reapExpiredMesh(ctx context.Context, factory cmdutil.Factory, clientset kubernetes.Interface, namespace string) {
+	mapInterface := clientset.CoreV1().ConfigMaps(namespace)
+	virtuals, err := getVirtuals(ctx, mapInterface)
+	if err != nil {
+		log.Debugf("can not get envoy config, err: %v", err)
+		return
+	}
+	now := time.Now()
+	for _, virtual := range virtuals {
+		for _, rule := range virtual.Rules {
+			if !rule.Expired(now) {
+				continue
+			}
+			workload := UidToWorkload(virtual.Uid)
+			log.Infof("rule of %s (local tun ip: %s) on %s expired at %v, remove it", rule.Owner, rule.LocalTunIP, workload, rule.Expire)
+			if err = UnPatchContainer(factory, mapInterface, namespace, workloadNamespace(virtual, namespace), workload, rule.LocalTunIP); err != nil {
+				log.Errorf("failed to remove expired rule of %s, err: %v", workload, err)
+			}
+		}
+	}
+}
+
+// servedNamespaces namespaces of workloads served by traffic manager, cluster-wide traffic manager serves all
+// namespaces without their own traffic manager
+func servedNamespaces(ctx context.Context, clientset kubernetes.Interface, namespace string) []string {
+	if !isClusterWide(ctx, clientset, namespace) {
+		return []string{namespace}
+	}
+	list, err := clientset.CoreV1().Namespaces().List(ctx, metav1.ListOptions{})
+	if err != nil {
+		log.Debugf("can not list namespaces, err: %v", err)
+		return []string{namespace}
+	}
+	var namespaces []string
+	for _, item := range list.Items {
+		if _, ok := item.Labels["ns"]; ok && item.Name != namespace {
+			continue
+		}
+		if item.Name == metav1.NamespaceSystem {
+			continue
+		}
+		namespaces = append(namespaces, item.Name)
+	}
+	return namespaces
+}
+
+// reapExpiredNormal unpatch workloads intercepted without mesh
+func reapExpiredNormal(ctx context.Context, factory cmdutil.Factory, clientset kubernetes.Interface, namespace string) {
+	now := time.Now()
+	unpatchNormal(ctx, factory, clientset, namespace, func(u *unstructured.Unstructured) bool {
+		return interceptionExpired(u, now)
+	})
+}
+
+// unpatchNormal unpatch workloads intercepted without mesh which match filter, and release their locks
+func unpatchNormal(ctx context.Context, factory cmdutil.Factory, clientset kubernetes.Interface, namespace string, filter func(u *unstructured.Unstructured) bool) {
+	list, err := util.GetUnstructuredObjectList(factory, namespace, []string{"deployments,statefulsets,replicasets,daemonsets"})
+	if err != nil {
+		log.Debugf("can not list workloads, err: %v", err)
+		return
+	}
+	for _, info := range selectNormal(list, filter) {
+		workload := fmt.Sprintf("%s/%s", info.Mapping.Resource.GroupResource().String(), info.Name)
+		annotations := info.Object.(*unstructured.Unstructured).GetAnnotations()
+		log.Infof("unpatch workload %s intercepted by %s, expire at %s", workload, annotations[config.AnnotationOwner], annotations[config.AnnotationExpire])
+		if err = UnPatchInboundContainer(factory, namespace, workload); err != nil {
+			log.Errorf("failed to unpatch workload %s, err: %v", workload, err)
+			continue
+		}
+		releaseWorkloadLock(ctx, clientset, namespace, getNodeID(info))
+	}
+}
+
+// selectNormal select workloads intercepted without mesh which match filter
+func selectNormal(list []*resource.Info, filter func(u *unstructured.Unstructured) bool) []*resource.Info {
+	var result []*resource.Info
+	for _, info := range list {
+		u, ok := info.Object.(*unstructured.Unstructured)
+		if !ok || !filter(u) || !interceptedNormalWorkload(u) {
+			continue
+		}
+		result = append(result, info)
+	}
+	return result
+}
+
+// interceptedByKubevpn workload is intercepted by kubevpn, whether expired or not
+func interceptedByKubevpn(u *unstructured.Unstructured) bool {
+	_, ok := u.GetAnnotations()[config.AnnotationOwner]
+	return ok
+}
+
+// interceptedNormalWorkload workload is intercepted without mesh and not controlled by other workload
+func interceptedNormalWorkload(u *unstructured.Unstructured) bool {
+	// replicasets of deployments carry annotations of deployments, unpatch deployments only
+	if metav1.GetControllerOf(u) != nil {
+		return false
+	}
+	templateSpec, _, err := util.GetPodTemplateSpecPath(u)
+	if err != nil {
+		return false
+	}
+	// mesh mode is handled by rules
+	return interceptedNormal(templateSpec)
+}
+
+// reapExpiredIPs reclaim ip which lease is not renewed by laptop or sidecar
+func reapExpiredIPs(ctx context.Context, clientset kubernetes.Interface, namespace string) {
+	dhcp := NewDHCPManager(clientset, namespace, &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask})
+	if err := dhcp.ReclaimExpiredIPs(ctx); err != nil {
+		log.Debugf("can not reclaim expired ips, err: %v", err)
+	}
+}
+
+// reapIdleTrafficManager delete expired sessions, if no session is alive for a grace period, like all clients crashed,
+// clean up traffic manager itself
+func reapIdleTrafficManager(ctx context.Context, clientset kubernetes.Interface, namespace string, lastActive *time.Time) {
+	sessions, err := ListSessions(ctx, clientset, namespace)
+	if err != nil {
+		log.Debugf("can not list sessions, err: %v", err)
+		return
+	}
+	for i := range sessions {
+		if !leaseExpired(&sessions[i]) {
+			*lastActive = time.Now()
+			continue
+		}
+		log.Infof("session %s of %s expired, delete it", sessions[i].Name, pointer.StringDeref(sessions[i].Spec.HolderIdentity, ""))
+		err = clientset.CoordinationV1().Leases(namespace).Delete(ctx, sessions[i].Name, metav1.DeleteOptions{})
+		if err != nil && !k8serrors.IsNotFound(err) {
+			log.Errorf("failed to delete expired session %s, err: %v", sessions[i].Name, err)
+		}
+	}
+	if usedByLegacyClients(ctx, clientset, namespace) {
+		*lastActive = time.Now()
+	}
+	if time.Since(*lastActive) < sessionGracePeriod || isInstalled(ctx, clientset, namespace) {
+		return
+	}
+	log.Infof("no session is alive since %s, clean up traffic manager", lastActive.Format(time.RFC3339))
+	cleanup(clientset, namespace, config.ConfigMapPodTrafficManager, true)
+}
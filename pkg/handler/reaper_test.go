@@ -0,0 +1,169 @@
+package handler
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/resource"
+	"k8s.io/utils/pointer"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
+)
+
+func TestInterceptionExpired(t *testing.T) {
+	now := time.Now()
+	testcases := []struct {
+		name   string
+		expire string
+		expect bool
+	}{
+		{name: "no expire"},
+		{name: "expired", expire: now.Add(-time.Minute).Format(time.RFC3339), expect: true},
+		{name: "not expired", expire: now.Add(time.Minute).Format(time.RFC3339)},
+		{name: "malformed", expire: "1h"},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			u := &unstructured.Unstructured{Object: map[string]interface{}{}}
+			if tc.expire != "" {
+				u.SetAnnotations(map[string]string{config.AnnotationExpire: tc.expire})
+			}
+			if got := interceptionExpired(u, now); got != tc.expect {
+				t.Errorf("expect %v, but got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestInterceptedNormalWorkload(t *testing.T) {
+	template := v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "productpage"}, {Name: config.ContainerSidecarVPN}}}}
+	mesh := *template.DeepCopy()
+	mesh.Spec.Containers = append(mesh.Spec.Containers, v1.Container{Name: config.ContainerSidecarEnvoyProxy})
+	deployment := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "productpage", Namespace: "default", UID: "uid"},
+	}
+
+	testcases := []struct {
+		name   string
+		object runtime.Object
+		expect bool
+	}{
+		{
+			name:   "normal",
+			object: &appsv1.Deployment{ObjectMeta: deployment.ObjectMeta, Spec: appsv1.DeploymentSpec{Template: template}},
+			expect: true,
+		},
+		{
+			name:   "mesh",
+			object: &appsv1.Deployment{ObjectMeta: deployment.ObjectMeta, Spec: appsv1.DeploymentSpec{Template: mesh}},
+		},
+		{
+			name:   "not intercepted",
+			object: &appsv1.Deployment{ObjectMeta: deployment.ObjectMeta},
+		},
+		{
+			// replicaset of deployment carries annotations of deployment
+			name: "controlled by deployment",
+			object: &appsv1.ReplicaSet{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "productpage-7d9b8f",
+					Namespace:       "default",
+					OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+				},
+				Spec: appsv1.ReplicaSetSpec{Replicas: pointer.Int32(1), Template: template},
+			},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(tc.object)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := interceptedNormalWorkload(&unstructured.Unstructured{Object: object}); got != tc.expect {
+				t.Errorf("expect %v, but got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestRemoveInterceptionAnnotations(t *testing.T) {
+	expire := metav1.NewTime(time.Now().Add(time.Hour))
+	annotations := setInterceptionAnnotations(map[string]string{"app": "productpage"}, &controlplane.Owner{User: "naison", Hostname: "laptop"}, &expire)
+	annotations[config.AnnotationProbe] = "probe"
+	annotations[config.AnnotationTLSSecret] = "secret"
+	for _, key := range []string{config.AnnotationOwner, config.AnnotationStartTime, config.AnnotationExpire} {
+		if annotations[key] == "" {
+			t.Fatalf("expect annotation %s set", key)
+		}
+	}
+
+	annotations = removeInterceptionAnnotations(annotations)
+	if len(annotations) != 1 || annotations["app"] != "productpage" {
+		t.Fatalf("expect only annotations of user left, but got %v", annotations)
+	}
+	if removeInterceptionAnnotations(nil) == nil {
+		t.Fatal("expect empty annotations for json patch, but got nil")
+	}
+}
+
+func TestSelectNormal(t *testing.T) {
+	template := v1.PodTemplateSpec{Spec: v1.PodSpec{Containers: []v1.Container{{Name: "productpage"}, {Name: config.ContainerSidecarVPN}}}}
+	newInfo := func(name string, annotations map[string]string, template v1.PodTemplateSpec) *resource.Info {
+		object, err := runtime.DefaultUnstructuredConverter.ToUnstructured(&appsv1.Deployment{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "test", Annotations: annotations},
+			Spec:       appsv1.DeploymentSpec{Template: template},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &resource.Info{Name: name, Object: &unstructured.Unstructured{Object: object}}
+	}
+	now := time.Now()
+	owner := map[string]string{config.AnnotationOwner: `{"user":"naison"}`}
+	expired := withAnnotation(owner, config.AnnotationExpire, now.Add(-time.Minute).Format(time.RFC3339))
+	notExpired := withAnnotation(owner, config.AnnotationExpire, now.Add(time.Minute).Format(time.RFC3339))
+	list := []*resource.Info{
+		newInfo("expired", expired, template),
+		newInfo("not-expired", notExpired, template),
+		newInfo("never-expire", owner, template),
+		newInfo("not-intercepted", nil, v1.PodTemplateSpec{}),
+	}
+
+	testcases := []struct {
+		name   string
+		filter func(u *unstructured.Unstructured) bool
+		expect []string
+	}{
+		{
+			name:   "reap expired",
+			filter: func(u *unstructured.Unstructured) bool { return interceptionExpired(u, now) },
+			expect: []string{"expired"},
+		},
+		{
+			// kubevpn uninstall unpatch all workloads intercepted by kubevpn
+			name:   "uninstall",
+			filter: interceptedByKubevpn,
+			expect: []string{"expired", "not-expired", "never-expire"},
+		},
+	}
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []string
+			for _, info := range selectNormal(list, tc.filter) {
+				got = append(got, info.Name)
+			}
+			if !reflect.DeepEqual(got, tc.expect) {
+				t.Errorf("expect %v, but got %v", tc.expect, got)
+			}
+		})
+	}
+}
@@ -12,21 +12,15 @@ import (
 	"time"
 
 	log "github.com/sirupsen/logrus"
-	admissionv1 "k8s.io/api/admissionregistration/v1"
-	appsv1 "k8s.io/api/apps/v1"
 	v1 "k8s.io/api/core/v1"
-	rbacv1 "k8s.io/api/rbac/v1"
 	k8serrors "k8s.io/apimachinery/pkg/api/errors"
-	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/types"
-	"k8s.io/apimachinery/pkg/util/intstr"
 	"k8s.io/apimachinery/pkg/util/wait"
 	pkgresource "k8s.io/cli-runtime/pkg/resource"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/util/cert"
 	"k8s.io/client-go/util/retry"
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 	"k8s.io/kubectl/pkg/polymorphichelpers"
@@ -34,6 +28,7 @@ import (
 	"k8s.io/utils/pointer"
 
 	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
 	"github.com/wencaiwulue/kubevpn/pkg/exchange"
 	"github.com/wencaiwulue/kubevpn/pkg/util"
 )
@@ -43,17 +38,38 @@ func CreateOutboundPod(ctx context.Context, factory cmdutil.Factory, clientset *
 	if err == nil {
 		_, err = polymorphichelpers.AttachablePodForObjectFn(factory, service, 2*time.Second)
 		if err == nil {
-			_, err = updateRefCount(clientset.CoreV1().ConfigMaps(namespace), config.ConfigMapPodTrafficManager, 1)
-			if err != nil {
-				return
-			}
 			log.Infoln("traffic manager already exist, reuse it")
 			return net.ParseIP(service.Spec.ClusterIP), nil
 		}
 	}
+	// installed by administrator, developers may have no permission to create rbac, never delete or recreate it
+	if isInstalled(ctx, clientset, namespace) {
+		if service == nil || service.Spec.ClusterIP == "" {
+			return nil, fmt.Errorf("traffic manager is installed in namespace %s, but service %s not found, please contact administrator", namespace, config.ConfigMapPodTrafficManager)
+		}
+		log.Infoln("traffic manager is installed by administrator, wait it to be ready")
+		if _, err = polymorphichelpers.AttachablePodForObjectFn(factory, service, 5*time.Minute); err != nil {
+			return nil, fmt.Errorf("traffic manager is installed in namespace %s, but not ready, please contact administrator, err: %v", namespace, err)
+		}
+		return net.ParseIP(service.Spec.ClusterIP), nil
+	}
+	o := NewTrafficManagerOptions(namespace)
+	o.TunnelCIDR = trafficManagerIP
+	return InstallTrafficManager(ctx, clientset, o)
+}
+
+// InstallTrafficManager delete stale traffic manager and create a new one with options, wait it to be ready
+func InstallTrafficManager(ctx context.Context, clientset kubernetes.Interface, o *TrafficManagerOptions) (ip net.IP, err error) {
+	objects, err := GenTrafficManagerObjects(o)
+	if err != nil {
+		return nil, err
+	}
+	namespace := o.Namespace
 	var deleteResource = func(ctx context.Context) {
 		options := metav1.DeleteOptions{}
 		_ = clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Delete(ctx, config.ConfigMapPodTrafficManager+"."+namespace, options)
+		_ = clientset.RbacV1().ClusterRoleBindings().Delete(ctx, config.ConfigMapPodTrafficManager+"."+namespace, options)
+		_ = clientset.RbacV1().ClusterRoles().Delete(ctx, config.ConfigMapPodTrafficManager+"."+namespace, options)
 		_ = clientset.RbacV1().RoleBindings(namespace).Delete(ctx, config.ConfigMapPodTrafficManager, options)
 		_ = clientset.RbacV1().Roles(namespace).Delete(ctx, config.ConfigMapPodTrafficManager, options)
 		_ = clientset.CoreV1().ServiceAccounts(namespace).Delete(ctx, config.ConfigMapPodTrafficManager, options)
@@ -76,274 +92,52 @@ func CreateOutboundPod(ctx context.Context, factory cmdutil.Factory, clientset *
 	if ns.Labels == nil {
 		ns.Labels = map[string]string{}
 	}
-	ns.Labels["ns"] = namespace
+	for k, v := range objects.Namespace.Labels {
+		ns.Labels[k] = v
+	}
 	_, err = clientset.CoreV1().Namespaces().Update(ctx, ns, metav1.UpdateOptions{})
 	if err != nil {
 		return nil, err
 	}
 
 	// 2) create serviceAccount
-	_, err = clientset.CoreV1().ServiceAccounts(namespace).Create(ctx, &v1.ServiceAccount{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      config.ConfigMapPodTrafficManager,
-			Namespace: namespace,
-		},
-		AutomountServiceAccountToken: pointer.Bool(true),
-	}, metav1.CreateOptions{})
+	_, err = clientset.CoreV1().ServiceAccounts(namespace).Create(ctx, objects.ServiceAccount, metav1.CreateOptions{})
 	if err != nil {
 		return nil, err
 	}
 
 	// 3) create roles
-	_, err = clientset.RbacV1().Roles(namespace).Create(ctx, &rbacv1.Role{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      config.ConfigMapPodTrafficManager,
-			Namespace: namespace,
-		},
-		Rules: []rbacv1.PolicyRule{{
-			Verbs:         []string{"get", "list", "watch", "create", "update", "patch", "delete"},
-			APIGroups:     []string{""},
-			Resources:     []string{"configmaps", "secrets"},
-			ResourceNames: []string{config.ConfigMapPodTrafficManager},
-		}},
-	}, metav1.CreateOptions{})
+	_, err = clientset.RbacV1().Roles(namespace).Create(ctx, objects.Role, metav1.CreateOptions{})
 	if err != nil {
 		return nil, err
 	}
 
 	// 4) create roleBinding
-	_, err = clientset.RbacV1().RoleBindings(namespace).Create(ctx, &rbacv1.RoleBinding{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      config.ConfigMapPodTrafficManager,
-			Namespace: namespace,
-		},
-		Subjects: []rbacv1.Subject{{
-			Kind: "ServiceAccount",
-			//APIGroup:  "rbac.authorization.k8s.io",
-			Name:      config.ConfigMapPodTrafficManager,
-			Namespace: namespace,
-		}},
-		RoleRef: rbacv1.RoleRef{
-			APIGroup: "rbac.authorization.k8s.io",
-			Kind:     "Role",
-			Name:     config.ConfigMapPodTrafficManager,
-		},
-	}, metav1.CreateOptions{})
-	if err != nil {
-		return nil, err
-	}
-
-	udp8422 := "8422-for-udp"
-	tcp10800 := "10800-for-tcp"
-	tcp9002 := "9002-for-envoy"
-	tcp80 := "80-for-webhook"
-	svc, err := clientset.CoreV1().Services(namespace).Create(ctx, &v1.Service{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      config.ConfigMapPodTrafficManager,
-			Namespace: namespace,
-		},
-		Spec: v1.ServiceSpec{
-			Ports: []v1.ServicePort{{
-				Name:       udp8422,
-				Protocol:   v1.ProtocolUDP,
-				Port:       8422,
-				TargetPort: intstr.FromInt(8422),
-			}, {
-				Name:       tcp10800,
-				Protocol:   v1.ProtocolTCP,
-				Port:       10800,
-				TargetPort: intstr.FromInt(10800),
-			}, {
-				Name:       tcp9002,
-				Protocol:   v1.ProtocolTCP,
-				Port:       9002,
-				TargetPort: intstr.FromInt(9002),
-			}, {
-				Name:       tcp80,
-				Protocol:   v1.ProtocolTCP,
-				Port:       80,
-				TargetPort: intstr.FromInt(80),
-			}},
-			Selector: map[string]string{"app": config.ConfigMapPodTrafficManager},
-			Type:     v1.ServiceTypeClusterIP,
-		},
-	}, metav1.CreateOptions{})
+	_, err = clientset.RbacV1().RoleBindings(namespace).Create(ctx, objects.RoleBinding, metav1.CreateOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	var Resources = v1.ResourceRequirements{
-		Requests: map[v1.ResourceName]resource.Quantity{
-			v1.ResourceCPU:    resource.MustParse("1000m"),
-			v1.ResourceMemory: resource.MustParse("1024Mi"),
-		},
-		Limits: map[v1.ResourceName]resource.Quantity{
-			v1.ResourceCPU:    resource.MustParse("2000m"),
-			v1.ResourceMemory: resource.MustParse("2048Mi"),
-		},
+	// 5) create clusterRole and clusterRoleBinding, webhook keeps caBundle of MutatingWebhookConfiguration up to date,
+	// and traffic manager deletes it while cleanup itself, not required, webhook works until certificate expired without it
+	if err = createClusterRole(ctx, clientset, objects); err != nil {
+		log.Warnf("failed to create cluster role, webhook can not renew caBundle, err: %v", err)
 	}
 
-	domain := util.GetTlsDomain(namespace)
-	var crt, key []byte
-	crt, key, err = cert.GenerateSelfSignedCertKey(domain, nil, nil)
+	svc, err := clientset.CoreV1().Services(namespace).Create(ctx, objects.Service, metav1.CreateOptions{})
 	if err != nil {
 		return nil, err
 	}
 
-	// reason why not use v1.SecretTypeTls is because it needs key called tls.crt and tls.key, but tls.key can not as env variable
-	// ➜  ~ export tls.key=a
-	//export: not valid in this context: tls.key
-	secret := &v1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      config.ConfigMapPodTrafficManager,
-			Namespace: namespace,
-		},
-		Data: map[string][]byte{
-			config.TLSCertKey:       crt,
-			config.TLSPrivateKeyKey: key,
-		},
-		Type: v1.SecretTypeOpaque,
+	_, err = clientset.CoreV1().Secrets(namespace).Create(ctx, objects.Secret, metav1.CreateOptions{})
+	// stale secret, caBundle must match it
+	if k8serrors.IsAlreadyExists(err) {
+		_, err = clientset.CoreV1().Secrets(namespace).Update(ctx, objects.Secret, metav1.UpdateOptions{})
 	}
-	_, err = clientset.CoreV1().Secrets(namespace).Create(ctx, secret, metav1.CreateOptions{})
-
-	if err != nil && !k8serrors.IsAlreadyExists(err) {
+	if err != nil {
 		return nil, err
 	}
 
-	deployment := &appsv1.Deployment{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      config.ConfigMapPodTrafficManager,
-			Namespace: namespace,
-		},
-		Spec: appsv1.DeploymentSpec{
-			Replicas: pointer.Int32(1),
-			Selector: &metav1.LabelSelector{
-				MatchLabels: map[string]string{"app": config.ConfigMapPodTrafficManager},
-			},
-			Template: v1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: map[string]string{"app": config.ConfigMapPodTrafficManager},
-				},
-				Spec: v1.PodSpec{
-					ServiceAccountName: config.ConfigMapPodTrafficManager,
-					Volumes: []v1.Volume{{
-						Name: config.VolumeEnvoyConfig,
-						VolumeSource: v1.VolumeSource{
-							ConfigMap: &v1.ConfigMapVolumeSource{
-								LocalObjectReference: v1.LocalObjectReference{
-									Name: config.ConfigMapPodTrafficManager,
-								},
-								Items: []v1.KeyToPath{
-									{
-										Key:  config.KeyEnvoy,
-										Path: "envoy-config.yaml",
-									},
-								},
-								Optional: pointer.Bool(false),
-							},
-						},
-					}},
-					Containers: []v1.Container{
-						{
-							Name:    config.ContainerSidecarVPN,
-							Image:   config.Image,
-							Command: []string{"/bin/sh", "-c"},
-							Args: []string{`
-sysctl net.ipv4.ip_forward=1
-update-alternatives --set iptables /usr/sbin/iptables-legacy
-iptables -F
-iptables -P INPUT ACCEPT
-iptables -P FORWARD ACCEPT
-iptables -t nat -A POSTROUTING -s ${CIDR} -o eth0 -j MASQUERADE
-kubevpn serve -L "tcp://:10800" -L "tun://:8422?net=${TrafficManagerIP}" --debug=true`,
-							},
-							EnvFrom: []v1.EnvFromSource{{
-								SecretRef: &v1.SecretEnvSource{
-									LocalObjectReference: v1.LocalObjectReference{
-										Name: config.ConfigMapPodTrafficManager,
-									},
-								},
-							}},
-							Env: []v1.EnvVar{
-								{
-									Name:  "CIDR",
-									Value: config.CIDR.String(),
-								},
-								{
-									Name:  "TrafficManagerIP",
-									Value: trafficManagerIP,
-								},
-							},
-							Ports: []v1.ContainerPort{{
-								Name:          udp8422,
-								ContainerPort: 8422,
-								Protocol:      v1.ProtocolUDP,
-							}, {
-								Name:          tcp10800,
-								ContainerPort: 10800,
-								Protocol:      v1.ProtocolTCP,
-							}},
-							Resources:       Resources,
-							ImagePullPolicy: v1.PullIfNotPresent,
-							SecurityContext: &v1.SecurityContext{
-								Capabilities: &v1.Capabilities{
-									Add: []v1.Capability{
-										"NET_ADMIN",
-										//"SYS_MODULE",
-									},
-								},
-								RunAsUser:  pointer.Int64(0),
-								Privileged: pointer.Bool(true),
-							},
-						},
-						{
-							Name:    config.ContainerSidecarControlPlane,
-							Image:   config.Image,
-							Command: []string{"kubevpn"},
-							Args:    []string{"control-plane", "--watchDirectoryFilename", "/etc/envoy/envoy-config.yaml"},
-							Ports: []v1.ContainerPort{{
-								Name:          tcp9002,
-								ContainerPort: 9002,
-								Protocol:      v1.ProtocolTCP,
-							}},
-							VolumeMounts: []v1.VolumeMount{
-								{
-									Name:      config.VolumeEnvoyConfig,
-									ReadOnly:  true,
-									MountPath: "/etc/envoy",
-								},
-							},
-							ImagePullPolicy: v1.PullIfNotPresent,
-							Resources:       Resources,
-						},
-						{
-							Name:    "webhook",
-							Image:   config.Image,
-							Command: []string{"kubevpn"},
-							Args:    []string{"webhook"},
-							Ports: []v1.ContainerPort{{
-								Name:          tcp80,
-								ContainerPort: 80,
-								Protocol:      v1.ProtocolTCP,
-							}},
-							EnvFrom: []v1.EnvFromSource{{
-								SecretRef: &v1.SecretEnvSource{
-									LocalObjectReference: v1.LocalObjectReference{
-										Name: config.ConfigMapPodTrafficManager,
-									},
-								},
-							}},
-							Env:             []v1.EnvVar{},
-							ImagePullPolicy: v1.PullIfNotPresent,
-							Resources:       Resources,
-						},
-					},
-					RestartPolicy:     v1.RestartPolicyAlways,
-					PriorityClassName: "system-cluster-critical",
-				},
-			},
-		},
-	}
 	watchStream, err := clientset.CoreV1().Pods(namespace).Watch(ctx, metav1.ListOptions{
 		LabelSelector: fields.OneTermEqualSelector("app", config.ConfigMapPodTrafficManager).String(),
 	})
@@ -351,7 +145,7 @@ kubevpn serve -L "tcp://:10800" -L "tun://:8422?net=${TrafficManagerIP}" --debug
 		return nil, err
 	}
 	defer watchStream.Stop()
-	if _, err = clientset.AppsV1().Deployments(namespace).Create(ctx, deployment, metav1.CreateOptions{}); err != nil {
+	if _, err = clientset.AppsV1().Deployments(namespace).Create(ctx, objects.Deployment, metav1.CreateOptions{}); err != nil {
 		return nil, err
 	}
 	var last string
@@ -389,51 +183,45 @@ out:
 			return nil, errors.New(fmt.Sprintf("wait pod %s to be ready timeout", config.ConfigMapPodTrafficManager))
 		}
 	}
-	_, err = clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Create(ctx, &admissionv1.MutatingWebhookConfiguration{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      config.ConfigMapPodTrafficManager + "." + namespace,
-			Namespace: namespace,
-		},
-		Webhooks: []admissionv1.MutatingWebhook{{
-			Name: config.ConfigMapPodTrafficManager + ".naison.io", // no sense
-			ClientConfig: admissionv1.WebhookClientConfig{
-				Service: &admissionv1.ServiceReference{
-					Namespace: namespace,
-					Name:      config.ConfigMapPodTrafficManager,
-					Path:      pointer.String("/pods"),
-					Port:      pointer.Int32(80),
-				},
-				CABundle: crt,
-			},
-			Rules: []admissionv1.RuleWithOperations{{
-				Operations: []admissionv1.OperationType{admissionv1.Create, admissionv1.Delete},
-				Rule: admissionv1.Rule{
-					APIGroups:   []string{""},
-					APIVersions: []string{"v1"},
-					Resources:   []string{"pods"},
-					Scope:       (*admissionv1.ScopeType)(pointer.String(string(admissionv1.NamespacedScope))),
-				},
-			}},
-			FailurePolicy: (*admissionv1.FailurePolicyType)(pointer.String(string(admissionv1.Ignore))),
-			// same as above label ns
-			NamespaceSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"ns": namespace}},
-			SideEffects:             (*admissionv1.SideEffectClass)(pointer.String(string(admissionv1.SideEffectClassNone))),
-			TimeoutSeconds:          nil,
-			AdmissionReviewVersions: []string{"v1", "v1beta1"},
-			ReinvocationPolicy:      (*admissionv1.ReinvocationPolicyType)(pointer.String(string(admissionv1.NeverReinvocationPolicy))),
-		}},
-	}, metav1.CreateOptions{})
+	_, err = clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Create(ctx, objects.MutatingWebhookConfiguration, metav1.CreateOptions{})
 	if err != nil && !k8serrors.IsForbidden(err) && !k8serrors.IsAlreadyExists(err) {
 		return nil, fmt.Errorf("failed to create MutatingWebhookConfigurations, err: %v", err)
 	}
-	_, err = updateRefCount(clientset.CoreV1().ConfigMaps(namespace), config.ConfigMapPodTrafficManager, 1)
+	return net.ParseIP(svc.Spec.ClusterIP), nil
+}
+
+// createClusterRole cluster scoped permissions of traffic manager, only on its own MutatingWebhookConfiguration
+func createClusterRole(ctx context.Context, clientset kubernetes.Interface, objects *TrafficManagerObjects) error {
+	_, err := clientset.RbacV1().ClusterRoles().Create(ctx, objects.ClusterRole, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	_, err = clientset.RbacV1().ClusterRoleBindings().Create(ctx, objects.ClusterRoleBinding, metav1.CreateOptions{})
+	if err != nil && !k8serrors.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+// isInstalled traffic manager is installed by kubevpn install, managed by administrator
+func isInstalled(ctx context.Context, clientset kubernetes.Interface, namespace string) bool {
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
 	if err != nil {
-		return
+		return false
 	}
-	return net.ParseIP(svc.Spec.ClusterIP), nil
+	return deployment.Labels[config.LabelInstalled] == "true"
 }
 
-func InjectVPNSidecar(ctx1 context.Context, factory cmdutil.Factory, namespace, workloads string, config util.PodRouteConfig) error {
+// isClusterWide traffic manager serves workloads in all namespaces
+func isClusterWide(ctx context.Context, clientset kubernetes.Interface, namespace string) bool {
+	deployment, err := clientset.AppsV1().Deployments(namespace).Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+	if err != nil {
+		return false
+	}
+	return deployment.Labels[config.LabelClusterWide] == "true"
+}
+
+func InjectVPNSidecar(ctx1 context.Context, factory cmdutil.Factory, namespace, workloads string, c util.PodRouteConfig, owner *controlplane.Owner, expire *metav1.Time, lock *WorkloadLock) error {
 	object, err := util.GetUnstructuredObject(factory, namespace, workloads)
 	if err != nil {
 		return err
@@ -450,10 +238,10 @@ func InjectVPNSidecar(ctx1 context.Context, factory cmdutil.Factory, namespace,
 
 	helper := pkgresource.NewHelper(object.Client, object.Mapping)
 
-	exchange.AddContainer(&podTempSpec.Spec, config)
-
 	// pods without controller
 	if len(path) == 0 {
+		exchange.AddContainer(&podTempSpec.Spec, c)
+		config.Pod.ApplyToPod(&podTempSpec.ObjectMeta, &podTempSpec.Spec, c.TrafficManagerNamespace == "")
 		podTempSpec.Spec.PriorityClassName = ""
 		for _, c := range podTempSpec.Spec.Containers {
 			c.LivenessProbe = nil
@@ -461,12 +249,20 @@ func InjectVPNSidecar(ctx1 context.Context, factory cmdutil.Factory, namespace,
 			c.ReadinessProbe = nil
 		}
 		p := &v1.Pod{ObjectMeta: podTempSpec.ObjectMeta, Spec: podTempSpec.Spec}
+		p.Annotations = setInterceptionAnnotations(p.Annotations, owner, expire)
+		// webhook rents ip for vpn sidecar of new pod
+		p.Labels = withAnnotation(p.Labels, config.LabelInject, "true")
 		CleanupUselessInfo(p)
 		if err = createAfterDeletePod(factory, p, helper); err != nil {
 			return err
 		}
 
 		RollbackFuncList = append(RollbackFuncList, func() {
+			// taken over by others, rollback will break others
+			if !lock.Release() {
+				log.Warnf("workload %s is taken over by others, skip rollback", workloads)
+				return
+			}
 			p2 := &v1.Pod{ObjectMeta: origin.ObjectMeta, Spec: origin.Spec}
 			CleanupUselessInfo(p2)
 			if err = createAfterDeletePod(factory, p2, helper); err != nil {
@@ -476,14 +272,14 @@ func InjectVPNSidecar(ctx1 context.Context, factory cmdutil.Factory, namespace,
 	} else
 	// controllers
 	{
-		// remove probe
-		removePatch, restorePatch := patch(origin, path)
-		p := []P{{
-			Op:    "replace",
-			Path:  "/" + strings.Join(append(path, "spec"), "/"),
-			Value: podTempSpec.Spec,
-		}}
-		bytes, _ := json.Marshal(append(p, removePatch...))
+		// webhook injects vpn sidecar and removes probes of new pods by annotation, workloads spec is untouched,
+		// so GitOps controllers will not fight with us
+		p := []P{
+			templateAnnotationsPatch(path, withAnnotation(podTempSpec.Annotations, config.AnnotationProxy, c.LocalTunIP)),
+			templateLabelsPatch(path, podTempSpec.Labels, true),
+			annotationsPatch(setInterceptionAnnotations(u.GetAnnotations(), owner, expire)),
+		}
+		bytes, _ := json.Marshal(p)
 		_, err = helper.Patch(object.Namespace, object.Name, types.JSONPatchType, bytes, &metav1.PatchOptions{})
 		if err != nil {
 			log.Errorf("error while inject proxy container, err: %v, exiting...", err)
@@ -491,13 +287,13 @@ func InjectVPNSidecar(ctx1 context.Context, factory cmdutil.Factory, namespace,
 		}
 
 		RollbackFuncList = append(RollbackFuncList, func() {
-			if err = removeInboundContainer(factory, namespace, workloads); err != nil {
-				log.Error(err)
+			// taken over by others, rollback will break others
+			if !lock.Release() {
+				log.Warnf("workload %s is taken over by others, skip rollback", workloads)
+				return
 			}
-			b, _ := json.Marshal(restorePatch)
-			if _, err = helper.Patch(object.Namespace, object.Name, types.JSONPatchType, b, &metav1.PatchOptions{}); err != nil {
-				log.Warnf("error while restore probe of resource: %s %s, ignore, err: %v",
-					object.Mapping.GroupVersionKind.GroupKind().String(), object.Name, err)
+			if err = UnPatchInboundContainer(factory, namespace, workloads); err != nil {
+				log.Error(err)
 			}
 		})
 	}
@@ -505,7 +301,10 @@ func InjectVPNSidecar(ctx1 context.Context, factory cmdutil.Factory, namespace,
 		return err
 	}
 	err = util.RolloutStatus(ctx1, factory, namespace, workloads, time.Minute*60)
-	return err
+	if err != nil || len(path) == 0 {
+		return err
+	}
+	return checkSidecarInjected(factory, object)
 }
 
 func createAfterDeletePod(factory cmdutil.Factory, p *v1.Pod, helper *pkgresource.Helper) error {
@@ -599,6 +398,40 @@ func CleanupUselessInfo(pod *v1.Pod) {
 	pod.SetOwnerReferences(nil)
 }
 
+// UnPatchInboundContainer remove inbound container, restore probe and remove annotations added by kubevpn
+func UnPatchInboundContainer(factory cmdutil.Factory, namespace, workloads string) error {
+	if err := removeInboundContainer(factory, namespace, workloads); err != nil {
+		return err
+	}
+	object, err := util.GetUnstructuredObject(factory, namespace, workloads)
+	if err != nil {
+		return err
+	}
+	u := object.Object.(*unstructured.Unstructured)
+	var ps []P
+	if probe, ok := u.GetAnnotations()[config.AnnotationProbe]; ok && probe != "" {
+		if err = json.Unmarshal([]byte(probe), &ps); err != nil {
+			log.Warnf("can not restore probe of resource: %s %s, ignore, err: %v",
+				object.Mapping.GroupVersionKind.GroupKind().String(), object.Name, err)
+			ps = nil
+		}
+	}
+	ps = append(ps, annotationsPatch(removeInterceptionAnnotations(u.GetAnnotations())))
+	if templateSpec, path, err := util.GetPodTemplateSpecPath(u); err == nil && len(path) != 0 {
+		if _, ok := templateSpec.Annotations[config.AnnotationProxy]; ok {
+			ps = append(ps, templateAnnotationsPatch(path, withAnnotation(templateSpec.Annotations, config.AnnotationProxy, "")))
+			ps = append(ps, templateLabelsPatch(path, templateSpec.Labels, false))
+		}
+	}
+	bytes, err := json.Marshal(ps)
+	if err != nil {
+		return err
+	}
+	helper := pkgresource.NewHelper(object.Client, object.Mapping)
+	_, err = helper.Patch(object.Namespace, object.Name, types.JSONPatchType, bytes, &metav1.PatchOptions{})
+	return err
+}
+
 type P struct {
 	Op    string      `json:"op,omitempty"`
 	Path  string      `json:"path,omitempty"`
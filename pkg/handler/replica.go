@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"os"
+	"strconv"
+
+	log "github.com/sirupsen/logrus"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/client-go/kubernetes"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/core"
+)
+
+// KeepReplicaLinks traffic manager with multiple replicas links to all other replicas, peers may register with any
+// replica, packets to peers registered with other replicas are forwarded by them
+func KeepReplicaLinks(ctx context.Context, f cmdutil.Factory, r core.Route) {
+	port := replicaPort(r)
+	if port == "" {
+		return
+	}
+	clientset, err := f.KubernetesClientSet()
+	if err != nil {
+		log.Errorf("can not get clientset, replicas will not link to each other, err: %v", err)
+		return
+	}
+	namespace := os.Getenv(config.EnvPodNamespace)
+	self := os.Getenv(config.EnvPodIP)
+	core.KeepReplicaLinks(ctx, func(ctx context.Context) ([]string, error) {
+		return listReplicas(ctx, clientset, namespace, self, port)
+	})
+}
+
+// replicaPort port of replica node, empty if traffic manager serves without replica node
+func replicaPort(r core.Route) string {
+	for _, serveNode := range r.ServeNodes {
+		node, err := core.ParseNode(serveNode)
+		if err != nil || node.Protocol != "replica" {
+			continue
+		}
+		if _, port, err := net.SplitHostPort(node.Addr); err == nil && port != "" {
+			return port
+		}
+		return strconv.Itoa(config.PortReplica)
+	}
+	return ""
+}
+
+// listReplicas addresses of other running replicas, terminating replicas are still linked until they are gone,
+// peers registered with them are moving to other replicas
+func listReplicas(ctx context.Context, clientset kubernetes.Interface, namespace, self, port string) ([]string, error) {
+	list, err := clientset.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{
+		LabelSelector: fields.OneTermEqualSelector("app", config.ConfigMapPodTrafficManager).String(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var addrs []string
+	for _, pod := range list.Items {
+		if pod.Status.Phase != v1.PodRunning || pod.Status.PodIP == "" || pod.Status.PodIP == self {
+			continue
+		}
+		addrs = append(addrs, net.JoinHostPort(pod.Status.PodIP, port))
+	}
+	return addrs, nil
+}
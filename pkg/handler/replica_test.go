@@ -0,0 +1,46 @@
+package handler
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/core"
+)
+
+func TestListReplicas(t *testing.T) {
+	pod := func(name, ip string, phase v1.PodPhase) *v1.Pod {
+		return &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "test",
+				Labels:    map[string]string{"app": config.ConfigMapPodTrafficManager},
+			},
+			Status: v1.PodStatus{Phase: phase, PodIP: ip},
+		}
+	}
+	clientset := fake.NewSimpleClientset(
+		pod("self", "10.0.0.1", v1.PodRunning),
+		pod("other", "10.0.0.2", v1.PodRunning),
+		pod("pending", "", v1.PodPending),
+	)
+	addrs, err := listReplicas(context.Background(), clientset, "test", "10.0.0.1", "10801")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(addrs, []string{"10.0.0.2:10801"}) {
+		t.Errorf("expect only other running replica, but got %v", addrs)
+	}
+
+	if port := replicaPort(core.Route{ServeNodes: []string{"tcp://:10800", "replica://:10801"}}); port != "10801" {
+		t.Errorf("expect replica port 10801, but got %s", port)
+	}
+	if port := replicaPort(core.Route{ServeNodes: []string{"tcp://:10800"}}); port != "" {
+		t.Errorf("expect no replica port, but got %s", port)
+	}
+}
@@ -2,11 +2,8 @@ package handler
 
 import (
 	"context"
-	"strings"
 
 	log "github.com/sirupsen/logrus"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"sigs.k8s.io/yaml"
 
 	"github.com/wencaiwulue/kubevpn/pkg/config"
 	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
@@ -16,29 +13,47 @@ import (
 // 1, get all proxy-resources from configmap
 // 2, cleanup all containers
 func (c *ConnectOptions) Reset(ctx2 context.Context) error {
-	cm, err := c.clientset.CoreV1().ConfigMaps(c.Namespace).Get(ctx2, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+	// cluster-wide traffic manager serves others, only reset workloads in my namespace
+	var namespace string
+	if c.managerNamespace() != c.Namespace {
+		namespace = c.Namespace
+	}
+	if err := c.unpatchAll(ctx2, namespace); err != nil {
+		return err
+	}
+	if isInstalled(ctx2, c.clientset, c.managerNamespace()) {
+		log.Infof("traffic manager in namespace %s is installed by administrator, keep it, uninstall it by kubevpn uninstall", c.managerNamespace())
+		return nil
+	}
+	cleanup(c.clientset, c.managerNamespace(), config.ConfigMapPodTrafficManager, false)
+	return nil
+}
+
+// unpatchAll remove sidecars of all proxied workloads in namespace, empty namespace means all namespaces
+func (c *ConnectOptions) unpatchAll(ctx2 context.Context, namespace string) error {
+	mapInterface := c.clientset.CoreV1().ConfigMaps(c.managerNamespace())
+	virtuals, err := getVirtuals(ctx2, mapInterface)
 	if err != nil {
 		return err
 	}
-	var v = make([]*controlplane.Virtual, 0)
-	if str, ok := cm.Data[config.KeyEnvoy]; ok && len(str) != 0 {
-		if err = yaml.Unmarshal([]byte(str), &v); err != nil {
-			log.Error(err)
-			return err
+	unpatchVirtuals(virtuals, c.managerNamespace(), namespace, func(workloadNamespace, workload, localTunIP string) error {
+		return UnPatchContainer(c.factory, mapInterface, c.managerNamespace(), workloadNamespace, workload, localTunIP)
+	})
+	return nil
+}
+
+// unpatchVirtuals unpatch every rule of workloads in namespace, envoy config of workloads in namespace of traffic
+// manager has no namespace, empty namespace means all namespaces
+func unpatchVirtuals(virtuals []*controlplane.Virtual, managerNamespace, namespace string, unpatch func(workloadNamespace, workload, localTunIP string) error) {
+	for _, virtual := range virtuals {
+		ns := workloadNamespace(virtual, managerNamespace)
+		if namespace != "" && ns != namespace {
+			continue
 		}
-		for _, virtual := range v {
-			// deployments.apps.ry-server --> deployments.apps/ry-server
-			lastIndex := strings.LastIndex(virtual.Uid, ".")
-			uid := virtual.Uid[:lastIndex] + "/" + virtual.Uid[lastIndex+1:]
-			for _, rule := range virtual.Rules {
-				err = UnPatchContainer(c.factory, c.clientset.CoreV1().ConfigMaps(c.Namespace), c.Namespace, uid, rule.Headers)
-				if err != nil {
-					log.Error(err)
-					continue
-				}
+		for _, rule := range virtual.Rules {
+			if err := unpatch(ns, UidToWorkload(virtual.Uid), rule.LocalTunIP); err != nil {
+				log.Error(err)
 			}
 		}
 	}
-	cleanup(c.clientset, c.Namespace, config.ConfigMapPodTrafficManager, false)
-	return nil
 }
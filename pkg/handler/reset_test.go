@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"context"
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"sigs.k8s.io/yaml"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
+)
+
+func TestUnpatchVirtuals(t *testing.T) {
+	virtuals := []*controlplane.Virtual{
+		{Uid: "deployments.apps.productpage", Rules: []*controlplane.Rule{{LocalTunIP: "223.254.0.101"}}},
+		{Uid: "deployments.apps.reviews", Namespace: "test", Rules: []*controlplane.Rule{{LocalTunIP: "223.254.0.102"}}},
+	}
+	data, err := yaml.Marshal(virtuals)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientset := fake.NewSimpleClientset(&v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapPodTrafficManager, Namespace: "default"},
+		Data:       map[string]string{config.KeyEnvoy: string(data)},
+	})
+	list, err := getVirtuals(context.Background(), clientset.CoreV1().ConfigMaps("default"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testcases := []struct {
+		namespace string
+		expect    []string
+	}{
+		// kubevpn uninstall, or kubevpn reset of traffic manager in namespace of workloads
+		{namespace: "", expect: []string{"default/deployments.apps/productpage", "test/deployments.apps/reviews"}},
+		// kubevpn reset -n test by cluster-wide traffic manager
+		{namespace: "test", expect: []string{"test/deployments.apps/reviews"}},
+	}
+	for _, tc := range testcases {
+		var unpatched []string
+		unpatchVirtuals(list, "default", tc.namespace, func(workloadNamespace, workload, localTunIP string) error {
+			unpatched = append(unpatched, workloadNamespace+"/"+workload)
+			return nil
+		})
+		if len(unpatched) != len(tc.expect) {
+			t.Fatalf("expect unpatch %v, but got %v", tc.expect, unpatched)
+		}
+		for i := range tc.expect {
+			if unpatched[i] != tc.expect[i] {
+				t.Errorf("expect unpatch %s, but got %s", tc.expect[i], unpatched[i])
+			}
+		}
+	}
+}
@@ -1,11 +1,13 @@
 package handler
 
 import (
+	"context"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 
@@ -20,7 +22,7 @@ func Complete(route *core.Route) error {
 		if namespace == "" {
 			return fmt.Errorf("can not get namespace")
 		}
-		url := fmt.Sprintf("https://%s:80%s", util.GetTlsDomain(namespace), config.APIRentIP)
+		url := fmt.Sprintf("https://%s:80%s", util.GetTlsDomain(trafficManagerNamespace()), config.APIRentIP)
 		req, err := http.NewRequest("GET", url, nil)
 		if err != nil {
 			return fmt.Errorf("can not new req, err: %v", err)
@@ -64,7 +66,7 @@ func Final() error {
 		return err
 	}
 	namespace := os.Getenv(config.EnvPodNamespace)
-	url := fmt.Sprintf("https://%s:80%s", util.GetTlsDomain(namespace), config.APIReleaseIP)
+	url := fmt.Sprintf("https://%s:80%s", util.GetTlsDomain(trafficManagerNamespace()), config.APIReleaseIP)
 	req, err := http.NewRequest("DELETE", url, nil)
 	if err != nil {
 		return fmt.Errorf("can not new req, err: %v", err)
@@ -75,3 +77,41 @@ func Final() error {
 	_, err = util.DoReq(req)
 	return err
 }
+
+// Heartbeat renew ip lease of sidecar by webhook periodically, traffic manager will reclaim ip if pod is gone without release it
+func Heartbeat(ctx context.Context) {
+	v, ok := os.LookupEnv(config.EnvInboundPodTunIP)
+	if !ok || v == "" {
+		return
+	}
+	namespace := os.Getenv(config.EnvPodNamespace)
+	url := fmt.Sprintf("https://%s:80%s", util.GetTlsDomain(trafficManagerNamespace()), config.APIRenewIP)
+	ticker := time.NewTicker(IPLeaseRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			req, err := http.NewRequestWithContext(ctx, "PUT", url, nil)
+			if err != nil {
+				log.Errorf("can not new req, err: %v", err)
+				continue
+			}
+			req.Header.Set(config.HeaderPodName, os.Getenv(config.EnvPodName))
+			req.Header.Set(config.HeaderPodNamespace, namespace)
+			req.Header.Set(config.HeaderIP, v)
+			if _, err = util.DoReq(req); err != nil {
+				log.Errorf("failed to renew lease of ip %s, err: %v", v, err)
+			}
+		}
+	}
+}
+
+// trafficManagerNamespace sidecars of cluster-wide traffic manager are in other namespaces
+func trafficManagerNamespace() string {
+	if namespace := os.Getenv(config.EnvTrafficManagerNamespace); namespace != "" {
+		return namespace
+	}
+	return os.Getenv(config.EnvPodNamespace)
+}
@@ -0,0 +1,153 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	utilrand "k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/utils/pointer"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
+)
+
+const (
+	// sessionLeaseDuration if client not renew session in this duration, session is expired, like laptop crash or kill -9
+	sessionLeaseDuration = 5 * time.Minute
+	// SessionRenewInterval interval of client renew session
+	SessionRenewInterval = time.Minute
+	// sessionGracePeriod traffic manager cleans up itself after all sessions expired for this period
+	sessionGracePeriod = 10 * time.Minute
+)
+
+// Session every client using traffic manager registers a session, stored as Lease object,
+// traffic manager is cleaned up after no session is alive
+type Session struct {
+	clientset kubernetes.Interface
+	namespace string
+	name      string
+	owner     *controlplane.Owner
+}
+
+// RegisterSession register session of client, client needs to keep it alive, otherwise it will be expired
+func RegisterSession(ctx context.Context, clientset kubernetes.Interface, namespace string, owner *controlplane.Owner) (*Session, error) {
+	s := &Session{
+		clientset: clientset,
+		namespace: namespace,
+		name:      fmt.Sprintf("%s.session-%s", config.ConfigMapPodTrafficManager, utilrand.String(8)),
+		owner:     owner,
+	}
+	if err := s.create(ctx); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Session) create(ctx context.Context) error {
+	now := metav1.NewMicroTime(time.Now())
+	_, err := s.clientset.CoordinationV1().Leases(s.namespace).Create(ctx, &coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      s.name,
+			Namespace: s.namespace,
+			Labels: map[string]string{
+				config.ManageBy:     config.ConfigMapPodTrafficManager,
+				config.LabelSession: "",
+			},
+			Annotations: map[string]string{
+				config.AnnotationHostname: s.owner.Hostname,
+			},
+		},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       pointer.String(s.owner.String()),
+			LeaseDurationSeconds: pointer.Int32(int32(sessionLeaseDuration.Seconds())),
+			AcquireTime:          &now,
+			RenewTime:            &now,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to register session, err: %v", err)
+	}
+	return nil
+}
+
+// renew renew session, if session is garbage collected, like laptop sleep too long, register it again
+func (s *Session) renew(ctx context.Context) error {
+	leaseInterface := s.clientset.CoordinationV1().Leases(s.namespace)
+	lease, err := leaseInterface.Get(ctx, s.name, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		log.Warnf("session %s is expired, register it again", s.name)
+		return s.create(ctx)
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: time.Now()}
+	_, err = leaseInterface.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// Keep renew session periodically until ctx done
+func (s *Session) Keep(ctx context.Context) {
+	ticker := time.NewTicker(SessionRenewInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.renew(ctx); err != nil {
+				log.Errorf("failed to renew session %s, err: %v", s.name, err)
+			}
+		}
+	}
+}
+
+// Close unregister session
+func (s *Session) Close() error {
+	err := s.clientset.CoordinationV1().Leases(s.namespace).Delete(context.Background(), s.name, metav1.DeleteOptions{})
+	if err != nil && !k8serrors.IsNotFound(err) {
+		return fmt.Errorf("failed to unregister session %s, err: %v", s.name, err)
+	}
+	return nil
+}
+
+// ListSessions list all sessions, include expired ones
+func ListSessions(ctx context.Context, clientset kubernetes.Interface, namespace string) ([]coordinationv1.Lease, error) {
+	list, err := clientset.CoordinationV1().Leases(namespace).List(ctx, metav1.ListOptions{LabelSelector: config.LabelSession})
+	if err != nil {
+		return nil, err
+	}
+	return list.Items, nil
+}
+
+// usedByLegacyClients clients of old version not register session, they increase REF_COUNT of configmap while
+// connecting and decrease it while exiting, traffic manager is in use if it is not zero
+func usedByLegacyClients(ctx context.Context, clientset kubernetes.Interface, namespace string) bool {
+	cm, err := clientset.CoreV1().ConfigMaps(namespace).Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return false
+	}
+	if err != nil {
+		// can not make sure, keep it
+		return true
+	}
+	count, _ := strconv.Atoi(cm.Data[config.KeyRefCount])
+	return count > 0
+}
+
+// countAliveSessions count sessions which not expired
+func countAliveSessions(sessions []coordinationv1.Lease) (count int) {
+	for i := range sessions {
+		if !leaseExpired(&sessions[i]) {
+			count++
+		}
+	}
+	return
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
+)
+
+func TestSessionLifecycle(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset()
+	owner := &controlplane.Owner{User: "naison", Hostname: "laptop"}
+	var sessions []*Session
+	for i := 0; i < 2; i++ {
+		session, err := RegisterSession(ctx, clientset, "default", owner)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions = append(sessions, session)
+	}
+	list, err := ListSessions(ctx, clientset, "default")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count := countAliveSessions(list); count != 2 {
+		t.Fatalf("expect 2 alive sessions, but got %d", count)
+	}
+
+	// one client exits normally, another one crashed
+	if err = sessions[0].Close(); err != nil {
+		t.Fatal(err)
+	}
+	expireSession(t, clientset, sessions[1].name)
+	list, _ = ListSessions(ctx, clientset, "default")
+	if count := countAliveSessions(list); count != 0 {
+		t.Fatalf("expect no alive session, but got %d", count)
+	}
+
+	// crashed client comes back, like laptop wakes up
+	if err = sessions[1].renew(ctx); err != nil {
+		t.Fatal(err)
+	}
+	list, _ = ListSessions(ctx, clientset, "default")
+	if count := countAliveSessions(list); count != 1 {
+		t.Fatalf("expect 1 alive session, but got %d", count)
+	}
+}
+
+func TestReapIdleTrafficManager(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapPodTrafficManager, Namespace: "default"},
+	})
+	session, err := RegisterSession(ctx, clientset, "default", &controlplane.Owner{User: "naison", Hostname: "laptop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deploymentExist := func() bool {
+		_, err := clientset.AppsV1().Deployments("default").Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+		return err == nil
+	}
+
+	// session is alive, keep traffic manager even it was idle for a long time
+	lastActive := time.Now().Add(-2 * sessionGracePeriod)
+	reapIdleTrafficManager(ctx, clientset, "default", &lastActive)
+	if !deploymentExist() {
+		t.Fatal("traffic manager is cleaned up while session is alive")
+	}
+
+	// session expired, expired session is deleted, but traffic manager is kept in grace period
+	expireSession(t, clientset, session.name)
+	reapIdleTrafficManager(ctx, clientset, "default", &lastActive)
+	if list, _ := ListSessions(ctx, clientset, "default"); len(list) != 0 {
+		t.Fatalf("expect expired session is deleted, but got %d sessions", len(list))
+	}
+	if !deploymentExist() {
+		t.Fatal("traffic manager is cleaned up in grace period")
+	}
+
+	// grace period passed
+	lastActive = time.Now().Add(-sessionGracePeriod)
+	reapIdleTrafficManager(ctx, clientset, "default", &lastActive)
+	if deploymentExist() {
+		t.Fatal("traffic manager is not cleaned up after grace period")
+	}
+}
+
+func TestReapIdleInstalledTrafficManager(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      config.ConfigMapPodTrafficManager,
+			Namespace: "default",
+			Labels:    map[string]string{config.LabelInstalled: "true"},
+		},
+	})
+	// installed by administrator, keep it even nobody uses it
+	lastActive := time.Now().Add(-2 * sessionGracePeriod)
+	reapIdleTrafficManager(ctx, clientset, "default", &lastActive)
+	if _, err := clientset.AppsV1().Deployments("default").Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{}); err != nil {
+		t.Fatalf("installed traffic manager is cleaned up, err: %v", err)
+	}
+}
+
+func expireSession(t *testing.T, clientset *fake.Clientset, name string) {
+	lease, err := clientset.CoordinationV1().Leases("default").Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: time.Now().Add(-2 * sessionLeaseDuration)}
+	if _, err = clientset.CoordinationV1().Leases("default").Update(context.Background(), lease, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReapIdleTrafficManagerUsedByLegacyClients(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset(&appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapPodTrafficManager, Namespace: "default"},
+	}, &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapPodTrafficManager, Namespace: "default"},
+		Data:       map[string]string{config.KeyRefCount: "1"},
+	})
+	// clients of old version only increase ref-count, no session is registered
+	lastActive := time.Now().Add(-2 * sessionGracePeriod)
+	reapIdleTrafficManager(ctx, clientset, "default", &lastActive)
+	if _, err := clientset.AppsV1().Deployments("default").Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{}); err != nil {
+		t.Fatalf("traffic manager used by old clients is cleaned up, err: %v", err)
+	}
+}
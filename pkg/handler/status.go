@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v12 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"sigs.k8s.io/yaml"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/controlplane"
+)
+
+// GetVirtuals get all mesh rules of proxy-resources from configmap, namespace of workloads is always set
+func (c *ConnectOptions) GetVirtuals(ctx context.Context) ([]*controlplane.Virtual, error) {
+	virtuals, err := getVirtuals(ctx, c.clientset.CoreV1().ConfigMaps(c.managerNamespace()))
+	if err != nil {
+		return nil, err
+	}
+	for _, virtual := range virtuals {
+		virtual.Namespace = workloadNamespace(virtual, c.managerNamespace())
+	}
+	return virtuals, nil
+}
+
+// workloadNamespace namespace of workloads, envoy config of workloads in namespace of traffic manager has no namespace
+func workloadNamespace(virtual *controlplane.Virtual, managerNamespace string) string {
+	if virtual.Namespace == "" {
+		return managerNamespace
+	}
+	return virtual.Namespace
+}
+
+func getVirtuals(ctx context.Context, mapInterface v12.ConfigMapInterface) ([]*controlplane.Virtual, error) {
+	cm, err := mapInterface.Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var v = make([]*controlplane.Virtual, 0)
+	if str, ok := cm.Data[config.KeyEnvoy]; ok && len(str) != 0 {
+		if err = yaml.Unmarshal([]byte(str), &v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+// GetIPLeases get all ip leases of laptops and pods
+func (c *ConnectOptions) GetIPLeases(ctx context.Context) ([]coordinationv1.Lease, error) {
+	leases, err := NewDHCPManager(c.clientset, c.managerNamespace(), &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask}).ListIPLeases(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// pods rent ip from traffic manager in their own namespace before
+	for i := range leases {
+		if leases[i].Labels[config.LabelIPLease] == IPLeaseKindPod && leases[i].Annotations[config.AnnotationNamespace] == "" {
+			if leases[i].Annotations == nil {
+				leases[i].Annotations = map[string]string{}
+			}
+			leases[i].Annotations[config.AnnotationNamespace] = c.managerNamespace()
+		}
+	}
+	return leases, nil
+}
+
+// GetSessions get all sessions of clients which using traffic manager
+func (c *ConnectOptions) GetSessions(ctx context.Context) ([]coordinationv1.Lease, error) {
+	return ListSessions(ctx, c.clientset, c.managerNamespace())
+}
+
+// UidToWorkload deployments.apps.ry-server --> deployments.apps/ry-server
+func UidToWorkload(uid string) string {
+	lastIndex := strings.LastIndex(uid, ".")
+	return uid[:lastIndex] + "/" + uid[lastIndex+1:]
+}
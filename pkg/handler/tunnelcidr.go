@@ -0,0 +1,139 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	log "github.com/sirupsen/logrus"
+	appsv1 "k8s.io/api/apps/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/util"
+)
+
+// resolveTunnelCIDR tunnel cidr is decided by whom creates traffic manager and persisted in configmap,
+// later clients read it back, and --tunnel-cidr should be the same as it
+func (c *ConnectOptions) resolveTunnelCIDR(ctx context.Context) error {
+	cm, err := c.clientset.CoreV1().ConfigMaps(c.managerNamespace()).Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	pool, found := cm.Data[config.KeyTunnelCIDR]
+	if !found {
+		var deployment *appsv1.Deployment
+		deployment, err = c.clientset.AppsV1().Deployments(c.managerNamespace()).Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+		switch {
+		case err == nil:
+			// traffic manager installed by administrator or created by old version which not persist tunnel cidr
+			pool = tunnelCIDROf(deployment)
+		case k8serrors.IsNotFound(err):
+			pool = c.TunnelCIDR
+			clusterCIDRs := append(parseCIDRs(c.ExtraCIDR), c.cidrs...)
+			if pool == "" {
+				// keep compatible, default one only warns
+				pool = config.DefaultTunnelCIDR()
+				if err = validateTunnelCIDR(pool, clusterCIDRs, localNetworks()); err != nil {
+					log.Warn(err)
+				}
+			} else if err = validateTunnelCIDR(pool, clusterCIDRs, localNetworks()); err != nil {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+	if c.TunnelCIDR != "" && c.TunnelCIDR != pool {
+		return fmt.Errorf("traffic manager in namespace %s already uses tunnel cidr %s, can not use %s, "+
+			"please use the same one or reset traffic manager first", c.managerNamespace(), pool, c.TunnelCIDR)
+	}
+	if err = config.SetTunnelCIDR(pool); err != nil {
+		return err
+	}
+	if !found {
+		if err = c.dhcp.Set(config.KeyTunnelCIDR, pool); err != nil {
+			return err
+		}
+	}
+	log.Infof("use tunnel cidr %s", pool)
+	return nil
+}
+
+// tunnelCIDROf tunnel cidr of traffic manager deployment, old version without env uses default one
+func tunnelCIDROf(deployment *appsv1.Deployment) string {
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		if container.Name != config.ContainerSidecarVPN {
+			continue
+		}
+		for _, env := range container.Env {
+			if env.Name == config.EnvTunnelCIDR && env.Value != "" {
+				return env.Value
+			}
+		}
+	}
+	return config.DefaultTunnelCIDR()
+}
+
+// validateTunnelCIDR tunnel cidr should not overlap with cluster cidrs, otherwise traffic to cluster goes to wrong place,
+// and should not overlap with local networks and routes, otherwise local PC can not access them after connect
+func validateTunnelCIDR(pool string, clusterCIDRs, localCIDRs []*net.IPNet) error {
+	_, tunnel, err := net.ParseCIDR(pool)
+	if err != nil {
+		return fmt.Errorf("invalid tunnel cidr %s, err: %v", pool, err)
+	}
+	for _, cidr := range clusterCIDRs {
+		if overlap(tunnel, cidr) {
+			return fmt.Errorf("tunnel cidr %s overlaps with cluster cidr %s, please use another one by --tunnel-cidr", pool, cidr.String())
+		}
+	}
+	for _, cidr := range localCIDRs {
+		if overlap(tunnel, cidr) {
+			return fmt.Errorf("tunnel cidr %s overlaps with local network or route %s, please use another one by --tunnel-cidr", pool, cidr.String())
+		}
+	}
+	return nil
+}
+
+func overlap(a, b *net.IPNet) bool {
+	return a.Contains(b.IP) || b.Contains(a.IP)
+}
+
+// localNetworks networks of local interfaces and routes of routing table, like routes pushed by other vpn to partner
+// networks, ignore default route, loopback, link local and multicast
+func localNetworks() []*net.IPNet {
+	var result []*net.IPNet
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		log.Warnf("failed to get addresses of local interfaces, err: %v", err)
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.To4() != nil {
+			result = append(result, &net.IPNet{IP: ipNet.IP.Mask(ipNet.Mask), Mask: ipNet.Mask})
+		}
+	}
+	routes, err := util.LocalRoutes()
+	if err != nil {
+		log.Warnf("failed to get local routes, err: %v", err)
+	}
+	result = append(result, routes...)
+	var networks []*net.IPNet
+	for _, ipNet := range result {
+		if ip := ipNet.IP.To4(); ip == nil || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsMulticast() || ip.Equal(net.IPv4bcast) {
+			continue
+		}
+		networks = append(networks, ipNet)
+	}
+	return networks
+}
+
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	var result []*net.IPNet
+	for _, s := range cidrs {
+		if _, cidr, err := net.ParseCIDR(s); err == nil {
+			result = append(result, cidr)
+		}
+	}
+	return result
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"net"
+	"testing"
+)
+
+func TestValidateTunnelCIDR(t *testing.T) {
+	parse := func(s string) *net.IPNet {
+		_, cidr, _ := net.ParseCIDR(s)
+		return cidr
+	}
+	clusterCIDRs := []*net.IPNet{parse("10.233.64.0/18"), parse("10.96.0.0/12")}
+	localCIDRs := []*net.IPNet{parse("192.168.1.0/24")}
+	testcases := []struct {
+		pool    string
+		wantErr bool
+	}{
+		{pool: "223.254.0.100/16"},
+		{pool: "198.18.0.100/16"},
+		{pool: "10.233.100.100/24", wantErr: true},
+		{pool: "10.0.0.100/8", wantErr: true},
+		{pool: "192.168.0.100/16", wantErr: true},
+		{pool: "192.168.2.100/24"},
+		{pool: "198.18.0.100", wantErr: true},
+	}
+	for _, tc := range testcases {
+		err := validateTunnelCIDR(tc.pool, clusterCIDRs, localCIDRs)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("pool %s, want error: %v, but got: %v", tc.pool, tc.wantErr, err)
+		}
+	}
+}
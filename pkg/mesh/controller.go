@@ -2,10 +2,10 @@ package mesh
 
 import (
 	_ "embed"
+	"strings"
 
 	log "github.com/sirupsen/logrus"
 	v1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/resource"
 	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/utils/pointer"
@@ -24,6 +24,12 @@ func RemoveContainers(spec *v1.PodTemplateSpec) {
 			i--
 		}
 	}
+	for i := 0; i < len(spec.Spec.Volumes); i++ {
+		if spec.Spec.Volumes[i].Name == config.VolumeTLSSecret {
+			spec.Spec.Volumes = append(spec.Spec.Volumes[:i], spec.Spec.Volumes[i+1:]...)
+			i--
+		}
+	}
 }
 
 func AddMeshContainer(spec *v1.PodTemplateSpec, nodeId string, c util.PodRouteConfig) {
@@ -43,18 +49,16 @@ iptables -t nat -A PREROUTING ! -p icmp ! -s 127.0.0.1 ! -d ${CIDR} -j DNAT --to
 iptables -t nat -A POSTROUTING ! -p icmp ! -s 127.0.0.1 ! -d ${CIDR} -j MASQUERADE
 kubevpn serve -L "tun:/127.0.0.1:8422?net=${InboundPodTunIP}&route=${CIDR}" -F "tcp://${TrafficManagerRealIP}:10800"`,
 		},
-		EnvFrom: []v1.EnvFromSource{{
-			SecretRef: &v1.SecretEnvSource{
-				LocalObjectReference: v1.LocalObjectReference{
-					Name: config.ConfigMapPodTrafficManager,
-				},
-			},
-		}},
-		Env: []v1.EnvVar{
+		EnvFrom: c.SidecarEnvFrom(),
+		Env: append([]v1.EnvVar{
 			{
 				Name:  "CIDR",
 				Value: config.CIDR.String(),
 			},
+			{
+				Name:  config.EnvTunnelCIDR,
+				Value: config.TunnelCIDR(),
+			},
 			{
 				Name:  "TrafficManagerRealIP",
 				Value: c.TrafficManagerRealIP,
@@ -79,17 +83,8 @@ kubevpn serve -L "tun:/127.0.0.1:8422?net=${InboundPodTunIP}&route=${CIDR}" -F "
 					},
 				},
 			},
-		},
-		Resources: v1.ResourceRequirements{
-			Requests: map[v1.ResourceName]resource.Quantity{
-				v1.ResourceCPU:    resource.MustParse("128m"),
-				v1.ResourceMemory: resource.MustParse("128Mi"),
-			},
-			Limits: map[v1.ResourceName]resource.Quantity{
-				v1.ResourceCPU:    resource.MustParse("256m"),
-				v1.ResourceMemory: resource.MustParse("256Mi"),
-			},
-		},
+		}, c.SidecarEnv()...),
+		Resources:       config.Pod.GetSidecarResources(),
 		ImagePullPolicy: v1.PullIfNotPresent,
 		SecurityContext: &v1.SecurityContext{
 			Capabilities: &v1.Capabilities{
@@ -118,20 +113,44 @@ kubevpn serve -L "tun:/127.0.0.1:8422?net=${InboundPodTunIP}&route=${CIDR}" -F "
 			"--config-yaml",
 		},
 		Args: []string{
-			string(envoyConfig),
-		},
-		Resources: v1.ResourceRequirements{
-			Requests: map[v1.ResourceName]resource.Quantity{
-				v1.ResourceCPU:    resource.MustParse("128m"),
-				v1.ResourceMemory: resource.MustParse("128Mi"),
-			},
-			Limits: map[v1.ResourceName]resource.Quantity{
-				v1.ResourceCPU:    resource.MustParse("256m"),
-				v1.ResourceMemory: resource.MustParse("256Mi"),
-			},
+			xdsConfig(c.TrafficManagerNamespace),
 		},
+		Resources:       config.Pod.GetSidecarResources(),
 		ImagePullPolicy: v1.PullIfNotPresent,
 	})
+	addTLSSecretVolume(spec, c.TLSSecret)
+}
+
+// addTLSSecretVolume mount tls secret of workloads into envoy-proxy sidecar, secret is read by kubelet in namespace of
+// workloads, so traffic manager needs no permission of secrets
+func addTLSSecretVolume(spec *v1.PodTemplateSpec, secretName string) {
+	if secretName == "" {
+		return
+	}
+	spec.Spec.Volumes = append(spec.Spec.Volumes, v1.Volume{
+		Name: config.VolumeTLSSecret,
+		VolumeSource: v1.VolumeSource{
+			Secret: &v1.SecretVolumeSource{SecretName: secretName},
+		},
+	})
+	for i := range spec.Spec.Containers {
+		if spec.Spec.Containers[i].Name == config.ContainerSidecarEnvoyProxy {
+			spec.Spec.Containers[i].VolumeMounts = append(spec.Spec.Containers[i].VolumeMounts, v1.VolumeMount{
+				Name:      config.VolumeTLSSecret,
+				ReadOnly:  true,
+				MountPath: config.TLSSecretMountPath,
+			})
+		}
+	}
+}
+
+// xdsConfig envoy config with address of control-plane, envoy-proxy sidecars in other namespaces connect to
+// cluster-wide traffic manager by full domain name
+func xdsConfig(namespace string) string {
+	if namespace == "" {
+		return string(envoyConfig)
+	}
+	return strings.Replace(string(envoyConfig), `"address":"`+config.ConfigMapPodTrafficManager+`"`, `"address":"`+util.GetTlsDomain(namespace)+`"`, 1)
 }
 
 func init() {
@@ -0,0 +1,121 @@
+package util
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math"
+	"math/big"
+	"time"
+
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/keyutil"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
+var (
+	// CAValidity validity of CA which signs webhook serving certificate
+	CAValidity = 10 * 365 * 24 * time.Hour
+	// CertValidity validity of webhook serving certificate, webhook renews it before expiry
+	CertValidity = 365 * 24 * time.Hour
+)
+
+// GenerateCA generate self-signed CA, returns pem encoded certificate and private key
+func GenerateCA(namespace string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:          newSerialNumber(),
+		Subject:               pkix.Name{CommonName: fmt.Sprintf("%s-ca@%d", GetTlsDomain(namespace), now.Unix())},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(CAValidity),
+		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	return encode(template, template, key, key)
+}
+
+// GenerateServingCert generate webhook serving certificate signed by CA, certificate pem contains CA,
+// sidecars trust it by env, so renewed serving certificate signed by the same CA is still trusted
+func GenerateServingCert(namespace string, caCertPEM, caKeyPEM []byte) (certPEM, keyPEM []byte, err error) {
+	caCert, caKey, err := ParseCA(caCertPEM, caKeyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), cryptorand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	domain := GetTlsDomain(namespace)
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber: newSerialNumber(),
+		Subject:      pkix.Name{CommonName: domain},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(CertValidity),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{config.ConfigMapPodTrafficManager, config.ConfigMapPodTrafficManager + "." + namespace, domain, domain + ".cluster.local"},
+	}
+	if template.NotAfter.After(caCert.NotAfter) {
+		template.NotAfter = caCert.NotAfter
+	}
+	certPEM, keyPEM, err = encode(template, caCert, key, caKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	return append(certPEM, caCertPEM...), keyPEM, nil
+}
+
+// ParseCA parse pem encoded CA certificate and private key
+func ParseCA(certPEM, keyPEM []byte) (*x509.Certificate, crypto.Signer, error) {
+	certs, err := cert.ParseCertsPEM(certPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := keyutil.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok || !certs[0].IsCA {
+		return nil, nil, fmt.Errorf("invalid CA")
+	}
+	return certs[0], signer, nil
+}
+
+// encode sign certificate of key by signer, returns pem encoded certificate and key
+func encode(template, parent *x509.Certificate, key, signer crypto.Signer) (certPEM, keyPEM []byte, err error) {
+	der, err := x509.CreateCertificate(cryptorand.Reader, template, parent, key.Public(), signer)
+	if err != nil {
+		return nil, nil, err
+	}
+	var certBuffer bytes.Buffer
+	if err = pem.Encode(&certBuffer, &pem.Block{Type: cert.CertificateBlockType, Bytes: der}); err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err = keyutil.MarshalPrivateKeyToPEM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	return certBuffer.Bytes(), keyPEM, nil
+}
+
+func newSerialNumber() *big.Int {
+	serial, err := cryptorand.Int(cryptorand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+	if err != nil {
+		return big.NewInt(time.Now().UnixNano())
+	}
+	return serial
+}
@@ -0,0 +1,62 @@
+//go:build darwin || freebsd || openbsd
+
+package util
+
+import (
+	"net"
+	"syscall"
+
+	"golang.org/x/net/route"
+)
+
+// flags of bsd route, https://github.com/freebsd/freebsd/blob/master/sys/net/route.h
+const (
+	rtfUp        = 0x1
+	rtfHost      = 0x4
+	rtfReject    = 0x8
+	rtfBlackhole = 0x1000
+	rtfLocal     = 0x200000
+	rtfBroadcast = 0x400000
+	rtfMulticast = 0x800000
+)
+
+// LocalRoutes ipv4 routes of routing table, default route is ignored
+func LocalRoutes() ([]*net.IPNet, error) {
+	rib, err := route.FetchRIB(syscall.AF_INET, route.RIBTypeRoute, 0)
+	if err != nil {
+		return nil, err
+	}
+	msgs, err := route.ParseRIB(route.RIBTypeRoute, rib)
+	if err != nil {
+		return nil, err
+	}
+	var result []*net.IPNet
+	for _, msg := range msgs {
+		m, ok := msg.(*route.RouteMessage)
+		if !ok || m.Flags&rtfUp == 0 || m.Flags&(rtfReject|rtfBlackhole|rtfLocal|rtfBroadcast|rtfMulticast) != 0 {
+			continue
+		}
+		if len(m.Addrs) <= syscall.RTAX_NETMASK {
+			continue
+		}
+		dst, ok := m.Addrs[syscall.RTAX_DST].(*route.Inet4Addr)
+		if !ok {
+			continue
+		}
+		ones := 32
+		if m.Flags&rtfHost == 0 {
+			mask, ok := m.Addrs[syscall.RTAX_NETMASK].(*route.Inet4Addr)
+			if !ok {
+				// no netmask means default route
+				continue
+			}
+			ones, _ = net.IPv4Mask(mask.IP[0], mask.IP[1], mask.IP[2], mask.IP[3]).Size()
+		}
+		if ones == 0 {
+			continue
+		}
+		ip := net.IPv4(dst.IP[0], dst.IP[1], dst.IP[2], dst.IP[3])
+		result = append(result, &net.IPNet{IP: ip.Mask(net.CIDRMask(ones, 32)), Mask: net.CIDRMask(ones, 32)})
+	}
+	return result, nil
+}
@@ -0,0 +1,25 @@
+//go:build linux
+
+package util
+
+import (
+	"net"
+
+	"github.com/docker/libcontainer/netlink"
+)
+
+// LocalRoutes ipv4 routes of main routing table, default route is ignored
+func LocalRoutes() ([]*net.IPNet, error) {
+	routes, err := netlink.NetworkGetRoutes()
+	if err != nil {
+		return nil, err
+	}
+	var result []*net.IPNet
+	for _, route := range routes {
+		if route.Default || route.IPNet == nil {
+			continue
+		}
+		result = append(result, route.IPNet)
+	}
+	return result, nil
+}
@@ -0,0 +1,27 @@
+//go:build windows
+
+package util
+
+import (
+	"net"
+
+	"golang.org/x/sys/windows"
+	"golang.zx2c4.com/wireguard/windows/tunnel/winipcfg"
+)
+
+// LocalRoutes ipv4 routes of routing table, default route is ignored
+func LocalRoutes() ([]*net.IPNet, error) {
+	rows, err := winipcfg.GetIPForwardTable2(windows.AF_INET)
+	if err != nil {
+		return nil, err
+	}
+	var result []*net.IPNet
+	for _, row := range rows {
+		prefix := row.DestinationPrefix.Prefix()
+		if row.Loopback || !prefix.IsValid() || prefix.Bits() == 0 {
+			continue
+		}
+		result = append(result, &net.IPNet{IP: prefix.Masked().Addr().AsSlice(), Mask: net.CIDRMask(prefix.Bits(), 32)})
+	}
+	return result, nil
+}
@@ -1,7 +1,43 @@
 package util
 
+import (
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
 type PodRouteConfig struct {
 	LocalTunIP           string
 	InboundPodTunIP      string
 	TrafficManagerRealIP string
+	// TrafficManagerNamespace namespace of cluster-wide traffic manager, empty if traffic manager is in namespace of workload
+	TrafficManagerNamespace string
+	// TLSSecret tls secret of workloads in namespace of workloads, mounted into envoy-proxy sidecar
+	TLSSecret string
+}
+
+// SidecarEnvFrom certificate of webhook is stored in secret of traffic manager namespace, sidecars of cluster-wide traffic
+// manager in other namespaces can not refer to it, webhook sets CA as env of them while creating pods
+func (c PodRouteConfig) SidecarEnvFrom() []v1.EnvFromSource {
+	if c.TrafficManagerNamespace != "" {
+		return nil
+	}
+	return []v1.EnvFromSource{{
+		SecretRef: &v1.SecretEnvSource{
+			LocalObjectReference: v1.LocalObjectReference{
+				Name: config.ConfigMapPodTrafficManager,
+			},
+		},
+	}}
+}
+
+// SidecarEnv sidecars of cluster-wide traffic manager rent and renew ip from webhook in traffic manager namespace
+func (c PodRouteConfig) SidecarEnv() []v1.EnvVar {
+	if c.TrafficManagerNamespace == "" {
+		return nil
+	}
+	return []v1.EnvVar{{
+		Name:  config.EnvTrafficManagerNamespace,
+		Value: c.TrafficManagerNamespace,
+	}}
 }
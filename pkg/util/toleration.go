@@ -0,0 +1,36 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+// ParseToleration parse toleration like taint format of kubectl, key[=value][:effect]
+// key=value:NoSchedule --> key equals value with effect NoSchedule
+// key:NoSchedule --> key exists with effect NoSchedule
+// key --> key exists with all effects
+// :NoSchedule --> all taints with effect NoSchedule
+func ParseToleration(spec string) (v1.Toleration, error) {
+	var toleration v1.Toleration
+	keyValue, effect, _ := strings.Cut(spec, ":")
+	switch v1.TaintEffect(effect) {
+	case "", v1.TaintEffectNoSchedule, v1.TaintEffectPreferNoSchedule, v1.TaintEffectNoExecute:
+		toleration.Effect = v1.TaintEffect(effect)
+	default:
+		return toleration, fmt.Errorf("invalid toleration %s, unsupported effect %s", spec, effect)
+	}
+	key, value, found := strings.Cut(keyValue, "=")
+	if key == "" && (found || effect == "") {
+		return toleration, fmt.Errorf("invalid toleration %s, key is required", spec)
+	}
+	toleration.Key = key
+	if found {
+		toleration.Operator = v1.TolerationOpEqual
+		toleration.Value = value
+	} else {
+		toleration.Operator = v1.TolerationOpExists
+	}
+	return toleration, nil
+}
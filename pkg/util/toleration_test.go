@@ -0,0 +1,33 @@
+package util
+
+import (
+	"testing"
+
+	v1 "k8s.io/api/core/v1"
+)
+
+func TestParseToleration(t *testing.T) {
+	testcases := []struct {
+		spec    string
+		want    v1.Toleration
+		wantErr bool
+	}{
+		{spec: "dedicated=infra:NoSchedule", want: v1.Toleration{Key: "dedicated", Operator: v1.TolerationOpEqual, Value: "infra", Effect: v1.TaintEffectNoSchedule}},
+		{spec: "spot:NoExecute", want: v1.Toleration{Key: "spot", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoExecute}},
+		{spec: "spot", want: v1.Toleration{Key: "spot", Operator: v1.TolerationOpExists}},
+		{spec: ":NoSchedule", want: v1.Toleration{Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule}},
+		{spec: "spot:Never", wantErr: true},
+		{spec: "=infra", wantErr: true},
+		{spec: "", wantErr: true},
+	}
+	for _, tc := range testcases {
+		got, err := ParseToleration(tc.spec)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("spec %s, want error: %v, but got: %v", tc.spec, tc.wantErr, err)
+			continue
+		}
+		if !tc.wantErr && got != tc.want {
+			t.Errorf("spec %s, want %v, but got %v", tc.spec, tc.want, got)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	corev1 "k8s.io/api/core/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/util/cert"
+	"k8s.io/client-go/util/retry"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/util"
+)
+
+const (
+	// certRenewBefore renew serving certificate if it expires in this duration
+	certRenewBefore = 30 * 24 * time.Hour
+	// certSyncInterval interval of checking certificate expiry, secret recreated by others and caBundle
+	certSyncInterval = time.Minute
+)
+
+// certManager webhook owns its certificate, stored in secret, renews it before expiry, keeps caBundle of
+// MutatingWebhookConfiguration up to date, and serves latest certificate without restart
+type certManager struct {
+	clientset kubernetes.Interface
+	namespace string
+
+	lock sync.RWMutex
+	cert *tls.Certificate
+	ca   []byte
+}
+
+func newCertManager(clientset kubernetes.Interface, namespace string) *certManager {
+	return &certManager{clientset: clientset, namespace: namespace}
+}
+
+// GetCertificate used by tls.Config, hot reload serving certificate
+func (m *certManager) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	if m.cert == nil {
+		return nil, fmt.Errorf("certificate is not ready")
+	}
+	return m.cert, nil
+}
+
+// CABundle CA of serving certificate, sidecars in other namespaces trust webhook by it
+func (m *certManager) CABundle() []byte {
+	if m == nil {
+		return nil
+	}
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return m.ca
+}
+
+// Run sync certificate periodically until ctx done
+func (m *certManager) Run(ctx context.Context) {
+	ticker := time.NewTicker(certSyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := m.sync(ctx); err != nil {
+				log.Errorf("failed to sync webhook certificate, err: %v", err)
+			}
+		}
+	}
+}
+
+// sync renew certificate in secret if needed, load it and patch caBundle
+func (m *certManager) sync(ctx context.Context) error {
+	var secret *corev1.Secret
+	err := retry.RetryOnConflict(retry.DefaultRetry, func() (err error) {
+		secret, err = m.clientset.CoreV1().Secrets(m.namespace).Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+		if k8serrors.IsNotFound(err) {
+			secret = &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapPodTrafficManager, Namespace: m.namespace},
+				Type:       corev1.SecretTypeOpaque,
+			}
+			if !m.renew(secret) {
+				return nil
+			}
+			secret, err = m.clientset.CoreV1().Secrets(m.namespace).Create(ctx, secret, metav1.CreateOptions{})
+			return err
+		}
+		if err != nil || !m.renew(secret) {
+			return err
+		}
+		secret, err = m.clientset.CoreV1().Secrets(m.namespace).Update(ctx, secret, metav1.UpdateOptions{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	pair, err := tls.X509KeyPair(secret.Data[config.TLSCertKey], secret.Data[config.TLSPrivateKeyKey])
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	m.cert = &pair
+	m.ca = caBundle(secret)
+	m.lock.Unlock()
+	return m.patchCABundle(ctx, caBundle(secret))
+}
+
+// renew generate CA and serving certificate into secret if needed, returns whether secret is changed
+func (m *certManager) renew(secret *corev1.Secret) bool {
+	if secret.Data == nil {
+		secret.Data = map[string][]byte{}
+	}
+	caCrt, caKey := secret.Data[config.TLSCAKey], secret.Data[config.TLSCAPrivateKeyKey]
+	ca, _, err := util.ParseCA(caCrt, caKey)
+	if !needRenew(secret.Data[config.TLSCertKey], secret.Data[config.TLSPrivateKeyKey], ca) {
+		return false
+	}
+	// secret created by old version has no CA key, and CA expires soon can not sign certificate long enough
+	if err != nil || time.Until(ca.NotAfter) < util.CertValidity {
+		if caCrt, caKey, err = util.GenerateCA(m.namespace); err != nil {
+			log.Errorf("failed to generate CA, err: %v", err)
+			return false
+		}
+	}
+	crt, key, err := util.GenerateServingCert(m.namespace, caCrt, caKey)
+	if err != nil {
+		log.Errorf("failed to generate serving certificate, err: %v", err)
+		return false
+	}
+	log.Infof("renew serving certificate of webhook")
+	secret.Data[config.TLSCertKey] = crt
+	secret.Data[config.TLSPrivateKeyKey] = key
+	secret.Data[config.TLSCAKey] = caCrt
+	secret.Data[config.TLSCAPrivateKeyKey] = caKey
+	return true
+}
+
+// needRenew serving certificate is invalid, expires soon, or not signed by CA
+func needRenew(crt, key []byte, ca *x509.Certificate) bool {
+	if _, err := tls.X509KeyPair(crt, key); err != nil {
+		return true
+	}
+	certs, err := cert.ParseCertsPEM(crt)
+	if err != nil || time.Until(certs[0].NotAfter) < certRenewBefore {
+		return true
+	}
+	if ca != nil && certs[0].CheckSignatureFrom(ca) != nil {
+		return true
+	}
+	return false
+}
+
+// caBundle CA of serving certificate, certificate created by old version contains CA after serving certificate
+func caBundle(secret *corev1.Secret) []byte {
+	if ca := secret.Data[config.TLSCAKey]; len(ca) != 0 {
+		return ca
+	}
+	return secret.Data[config.TLSCertKey]
+}
+
+// patchCABundle caBundle of MutatingWebhookConfiguration should match CA of serving certificate, otherwise webhook fails
+func (m *certManager) patchCABundle(ctx context.Context, ca []byte) error {
+	name := config.ConfigMapPodTrafficManager + "." + m.namespace
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		webhook, err := m.clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+		// not created yet while traffic manager startup
+		if k8serrors.IsNotFound(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var changed bool
+		for i := range webhook.Webhooks {
+			if !bytes.Equal(webhook.Webhooks[i].ClientConfig.CABundle, ca) {
+				webhook.Webhooks[i].ClientConfig.CABundle = ca
+				changed = true
+			}
+		}
+		if !changed {
+			return nil
+		}
+		log.Infof("update caBundle of MutatingWebhookConfiguration %s", name)
+		_, err = m.clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Update(ctx, webhook, metav1.UpdateOptions{})
+		return err
+	})
+}
@@ -0,0 +1,107 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+	"time"
+
+	admissionv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/util"
+)
+
+func TestCertManagerSync(t *testing.T) {
+	ctx := context.Background()
+	clientset := fake.NewSimpleClientset(&admissionv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapPodTrafficManager + ".default"},
+		Webhooks:   []admissionv1.MutatingWebhook{{Name: config.ConfigMapPodTrafficManager + ".naison.io"}},
+	})
+	m := newCertManager(clientset, "default")
+	secrets := clientset.CoreV1().Secrets("default")
+
+	// secret not exist, generate it
+	if err := m.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	secret, err := secrets.Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkServing(t, m, clientset, secret.Data[config.TLSCAKey])
+
+	// nothing changed
+	if err = m.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	same, _ := secrets.Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+	if !bytes.Equal(same.Data[config.TLSCertKey], secret.Data[config.TLSCertKey]) {
+		t.Fatal("certificate is renewed but not expires soon")
+	}
+
+	// certificate expires soon, renew it with the same CA, sidecars still trust it
+	validity := util.CertValidity
+	util.CertValidity = certRenewBefore / 2
+	crt, key, err := util.GenerateServingCert("default", secret.Data[config.TLSCAKey], secret.Data[config.TLSCAPrivateKeyKey])
+	util.CertValidity = validity
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret.Data[config.TLSCertKey], secret.Data[config.TLSPrivateKeyKey] = crt, key
+	if _, err = secrets.Update(ctx, secret, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	renewed, _ := secrets.Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+	if bytes.Equal(renewed.Data[config.TLSCertKey], crt) {
+		t.Fatal("certificate expires soon but not renewed")
+	}
+	if !bytes.Equal(renewed.Data[config.TLSCAKey], secret.Data[config.TLSCAKey]) {
+		t.Fatal("CA is changed while renew certificate")
+	}
+	checkServing(t, m, clientset, renewed.Data[config.TLSCAKey])
+
+	// secret recreated by others, caBundle follows it
+	if err = secrets.Delete(ctx, config.ConfigMapPodTrafficManager, metav1.DeleteOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err = m.sync(ctx); err != nil {
+		t.Fatal(err)
+	}
+	recreated, _ := secrets.Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+	if bytes.Equal(recreated.Data[config.TLSCAKey], secret.Data[config.TLSCAKey]) {
+		t.Fatal("CA is not regenerated")
+	}
+	checkServing(t, m, clientset, recreated.Data[config.TLSCAKey])
+}
+
+// checkServing serving certificate is valid and signed by CA in caBundle
+func checkServing(t *testing.T, m *certManager, clientset *fake.Clientset, ca []byte) {
+	webhook, err := clientset.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), config.ConfigMapPodTrafficManager+".default", metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(webhook.Webhooks[0].ClientConfig.CABundle, ca) {
+		t.Fatal("caBundle is not updated")
+	}
+	serving, err := m.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(serving.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(ca)
+	if _, err = leaf.Verify(x509.VerifyOptions{DNSName: util.GetTlsDomain("default"), Roots: pool, CurrentTime: time.Now()}); err != nil {
+		t.Fatalf("serving certificate is not trusted by caBundle, err: %v", err)
+	}
+}
@@ -14,6 +14,8 @@ import (
 
 type dhcpServer struct {
 	f util.Factory
+	// namespace of traffic manager, pods in other namespaces rent ip from cluster-wide traffic manager
+	namespace string
 }
 
 func (d *dhcpServer) rentIP(w http.ResponseWriter, r *http.Request) {
@@ -27,9 +29,8 @@ func (d *dhcpServer) rentIP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	cmi := clientset.CoreV1().ConfigMaps(namespace)
-	dhcp := handler.NewDHCPManager(cmi, namespace, &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask})
-	random, err := dhcp.RentIPRandom()
+	dhcp := handler.NewDHCPManager(clientset, managerNamespace(d.namespace, namespace), &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask})
+	random, err := dhcp.RentIPRandom(&handler.IPLeaseHolder{Kind: handler.IPLeaseKindPod, Holder: podName, Namespace: namespace})
 	if err != nil {
 		log.Error(err)
 		w.WriteHeader(http.StatusBadRequest)
@@ -47,13 +48,15 @@ func (d *dhcpServer) releaseIP(w http.ResponseWriter, r *http.Request) {
 	namespace := r.Header.Get("POD_NAMESPACE")
 	ip := r.Header.Get("IP")
 
-	_, ipNet, err := net.ParseCIDR(ip)
+	i, ipNet, err := net.ParseCIDR(ip)
 	if err != nil {
 		log.Errorf("ip is invailed, ip: %s, err: %v", ip, err)
 		w.WriteHeader(http.StatusBadRequest)
 		w.Write([]byte(fmt.Sprintf("ip is invailed, ip: %s, err: %v", ip, err)))
 		return
 	}
+	// release ip itself, not network address
+	ipNet.IP = i
 
 	log.Infof("handling release ip request, pod name: %s, ns: %s", podName, namespace)
 	clientset, err := d.f.KubernetesClientSet()
@@ -62,8 +65,7 @@ func (d *dhcpServer) releaseIP(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	cmi := clientset.CoreV1().ConfigMaps(namespace)
-	dhcp := handler.NewDHCPManager(cmi, namespace, &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask})
+	dhcp := handler.NewDHCPManager(clientset, managerNamespace(d.namespace, namespace), &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask})
 	err = dhcp.ReleaseIpToDHCP(ipNet)
 	if err != nil {
 		log.Error(err)
@@ -72,3 +74,36 @@ func (d *dhcpServer) releaseIP(w http.ResponseWriter, r *http.Request) {
 	}
 	w.WriteHeader(http.StatusOK)
 }
+
+// renewIP sidecar renew ip lease periodically, otherwise traffic manager will reclaim it
+func (d *dhcpServer) renewIP(w http.ResponseWriter, r *http.Request) {
+	podName := r.Header.Get(config.HeaderPodName)
+	namespace := r.Header.Get(config.HeaderPodNamespace)
+	ip := r.Header.Get(config.HeaderIP)
+
+	i, ipNet, err := net.ParseCIDR(ip)
+	if err != nil {
+		log.Errorf("ip is invailed, ip: %s, err: %v", ip, err)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("ip is invailed, ip: %s, err: %v", ip, err)))
+		return
+	}
+
+	log.Debugf("handling renew ip request, pod name: %s, ns: %s, ip: %s", podName, namespace, ip)
+	clientset, err := d.f.KubernetesClientSet()
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	dhcp := handler.NewDHCPManager(clientset, managerNamespace(d.namespace, namespace), &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask})
+	holder := &handler.IPLeaseHolder{Kind: handler.IPLeaseKindPod, Holder: podName, Namespace: namespace}
+	err = dhcp.RenewIPLease(r.Context(), &net.IPNet{IP: i, Mask: ipNet.Mask}, holder)
+	if err != nil {
+		log.Error(err)
+		w.WriteHeader(http.StatusConflict)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
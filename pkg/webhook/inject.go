@@ -0,0 +1,84 @@
+package webhook
+
+import (
+	"context"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/kubectl/pkg/cmd/util/podcmd"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/exchange"
+	"github.com/wencaiwulue/kubevpn/pkg/mesh"
+	"github.com/wencaiwulue/kubevpn/pkg/util"
+)
+
+// shouldInject pod of workloads annotated by proxy, and sidecar is not injected by old version
+func shouldInject(pod *corev1.Pod) bool {
+	if pod.Annotations[config.AnnotationProxy] == "" && pod.Annotations[config.AnnotationMesh] == "" {
+		return false
+	}
+	container, _ := podcmd.FindContainerByName(pod, config.ContainerSidecarVPN)
+	return container == nil
+}
+
+// injectSidecar inject vpn sidecar, or vpn and envoy-proxy sidecars for mesh, into pod by annotations of pod template,
+// ip of vpn sidecar is empty, rented later as sidecar patched by old version
+func injectSidecar(ctx context.Context, clientset kubernetes.Interface, managerNamespace, namespace string, pod *corev1.Pod) error {
+	svc, err := clientset.CoreV1().Services(managerNamespace).Get(ctx, config.ConfigMapPodTrafficManager, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	c := util.PodRouteConfig{
+		LocalTunIP:           pod.Annotations[config.AnnotationProxy],
+		TrafficManagerRealIP: svc.Spec.ClusterIP,
+		TLSSecret:            pod.Annotations[config.AnnotationTLSSecret],
+	}
+	if namespace != managerNamespace {
+		c.TrafficManagerNamespace = managerNamespace
+	}
+	// priority is resolved before calling webhook, changing priority class name will be rejected
+	priorityClassName := pod.Spec.PriorityClassName
+	if nodeID := pod.Annotations[config.AnnotationMesh]; nodeID != "" {
+		spec := &corev1.PodTemplateSpec{ObjectMeta: pod.ObjectMeta, Spec: pod.Spec}
+		mesh.AddMeshContainer(spec, nodeID, c)
+		pod.Spec = spec.Spec
+	} else {
+		exchange.AddContainer(&pod.Spec, c)
+	}
+	pod.Spec.PriorityClassName = priorityClassName
+	config.Pod.ApplyToPod(&pod.ObjectMeta, &pod.Spec, namespace == managerNamespace)
+	// traffic is redirected to local PC or envoy-proxy, probes of origin containers will fail
+	for i := range pod.Spec.Containers {
+		pod.Spec.Containers[i].LivenessProbe = nil
+		pod.Spec.Containers[i].ReadinessProbe = nil
+		pod.Spec.Containers[i].StartupProbe = nil
+	}
+	return nil
+}
+
+// trustTrafficManager sidecars of cluster-wide traffic manager in other namespaces can not refer to secret of it,
+// set CA as env, sidecars trust webhook by it, returns whether pod is changed
+func trustTrafficManager(pod *corev1.Pod, ca []byte) bool {
+	if len(ca) == 0 {
+		return false
+	}
+	for i := range pod.Spec.Containers {
+		container := &pod.Spec.Containers[i]
+		if container.Name != config.ContainerSidecarVPN {
+			continue
+		}
+		var clusterWide, trusted bool
+		for _, env := range container.Env {
+			clusterWide = clusterWide || env.Name == config.EnvTrafficManagerNamespace
+			trusted = trusted || env.Name == config.TLSCertKey
+		}
+		if !clusterWide || trusted {
+			return false
+		}
+		container.Env = append(container.Env, corev1.EnvVar{Name: config.TLSCertKey, Value: string(ca)})
+		return true
+	}
+	return false
+}
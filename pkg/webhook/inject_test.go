@@ -0,0 +1,130 @@
+package webhook
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/kubectl/pkg/cmd/util/podcmd"
+
+	"github.com/wencaiwulue/kubevpn/pkg/config"
+)
+
+func TestInjectSidecar(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapPodTrafficManager, Namespace: "default"},
+		Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.100"},
+	})
+	newPod := func(annotations map[string]string) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{GenerateName: "productpage-", Namespace: "default", Annotations: annotations},
+			Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:           "productpage",
+				ReadinessProbe: &corev1.Probe{},
+			}}},
+		}
+	}
+	testcases := []struct {
+		annotations map[string]string
+		containers  []string
+	}{
+		{annotations: map[string]string{config.AnnotationProxy: "223.254.0.101"}, containers: []string{config.ContainerSidecarVPN}},
+		{annotations: map[string]string{config.AnnotationMesh: "deployments.apps.productpage"}, containers: []string{config.ContainerSidecarVPN, config.ContainerSidecarEnvoyProxy}},
+	}
+	for _, tc := range testcases {
+		pod := newPod(tc.annotations)
+		if !shouldInject(pod) {
+			t.Fatalf("pod with annotations %v should be injected", tc.annotations)
+		}
+		if err := injectSidecar(context.Background(), clientset, "default", "default", pod); err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range tc.containers {
+			if container, _ := podcmd.FindContainerByName(pod, name); container == nil {
+				t.Errorf("container %s is not injected", name)
+			}
+		}
+		if pod.Spec.Containers[0].ReadinessProbe != nil {
+			t.Errorf("probe of origin container is not removed")
+		}
+		if pod.Spec.PriorityClassName != "" {
+			t.Errorf("priority class name should not be changed, but got %s", pod.Spec.PriorityClassName)
+		}
+		if shouldInject(pod) {
+			t.Errorf("pod is already injected, should not inject again")
+		}
+	}
+	if shouldInject(newPod(nil)) {
+		t.Errorf("pod without annotations should not be injected")
+	}
+}
+
+func TestInjectSidecarClusterWide(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapPodTrafficManager, Namespace: "kubevpn"},
+		Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.100"},
+	})
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "productpage-",
+			Namespace:    "test",
+			Annotations:  map[string]string{config.AnnotationProxy: "223.254.0.101"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "productpage"}}},
+	}
+	if err := injectSidecar(context.Background(), clientset, "kubevpn", "test", pod); err != nil {
+		t.Fatal(err)
+	}
+	container, _ := podcmd.FindContainerByName(pod, config.ContainerSidecarVPN)
+	if container == nil {
+		t.Fatalf("container %s is not injected", config.ContainerSidecarVPN)
+	}
+	if len(container.EnvFrom) != 0 {
+		t.Errorf("sidecar in other namespace can not refer to secret of traffic manager: %v", container.EnvFrom)
+	}
+	if !trustTrafficManager(pod, []byte("ca")) {
+		t.Fatalf("CA is not set to sidecar of cluster-wide traffic manager")
+	}
+	if trustTrafficManager(pod, []byte("ca")) {
+		t.Errorf("CA should be set only once")
+	}
+	var namespace string
+	for _, env := range container.Env {
+		if env.Name == config.EnvTrafficManagerNamespace {
+			namespace = env.Value
+		}
+	}
+	if namespace != "kubevpn" {
+		t.Errorf("expect namespace of traffic manager kubevpn, but got %s", namespace)
+	}
+}
+
+func TestInjectSidecarTLSSecret(t *testing.T) {
+	clientset := fake.NewSimpleClientset(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: config.ConfigMapPodTrafficManager, Namespace: "default"},
+		Spec:       corev1.ServiceSpec{ClusterIP: "10.96.0.100"},
+	})
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "productpage-",
+			Namespace:    "default",
+			Annotations: map[string]string{
+				config.AnnotationMesh:      "deployments.apps.productpage",
+				config.AnnotationTLSSecret: "productpage-tls",
+			},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "productpage"}}},
+	}
+	if err := injectSidecar(context.Background(), clientset, "default", "default", pod); err != nil {
+		t.Fatal(err)
+	}
+	if len(pod.Spec.Volumes) != 1 || pod.Spec.Volumes[0].Secret == nil || pod.Spec.Volumes[0].Secret.SecretName != "productpage-tls" {
+		t.Fatalf("tls secret is not mounted: %v", pod.Spec.Volumes)
+	}
+	container, _ := podcmd.FindContainerByName(pod, config.ContainerSidecarEnvoyProxy)
+	if container == nil || len(container.VolumeMounts) != 1 || container.VolumeMounts[0].MountPath != config.TLSSecretMountPath {
+		t.Errorf("tls secret is not mounted into envoy-proxy sidecar")
+	}
+}
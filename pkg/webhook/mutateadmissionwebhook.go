@@ -1,12 +1,14 @@
 package webhook
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	v1 "k8s.io/api/admission/v1"
@@ -15,11 +17,23 @@ import (
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 
 	"github.com/wencaiwulue/kubevpn/pkg/config"
+	"github.com/wencaiwulue/kubevpn/pkg/handler"
 )
 
 // admissionReviewHandler is a handler to handle business logic, holding an util.Factory
 type admissionReviewHandler struct {
 	f cmdutil.Factory
+	// namespace of traffic manager, cluster-wide traffic manager serves pods in other namespaces
+	namespace string
+	certs     *certManager
+}
+
+// managerNamespace namespace of traffic manager, webhook started without namespace env serves its own namespace only
+func managerNamespace(namespace, requestNamespace string) string {
+	if namespace == "" {
+		return requestNamespace
+	}
+	return namespace
 }
 
 // admitv1beta1Func handles a v1beta1 admission
@@ -66,7 +80,8 @@ func serve(w http.ResponseWriter, r *http.Request, admit admitHandler) {
 		return
 	}
 
-	log.Infof("handling request: %s", body)
+	// pods carry env values, never log them
+	log.Debugf("handling request %s", r.URL.Path)
 
 	deserializer := codecs.UniversalDeserializer()
 	obj, gvk, err := deserializer.Decode(body, nil, nil)
@@ -108,7 +123,6 @@ func serve(w http.ResponseWriter, r *http.Request, admit admitHandler) {
 		return
 	}
 
-	log.Infof("sending response: %v", responseObj)
 	respBytes, err := json.Marshal(responseObj)
 	if err != nil {
 		log.Error(err)
@@ -122,25 +136,44 @@ func serve(w http.ResponseWriter, r *http.Request, admit admitHandler) {
 }
 
 func Main(f cmdutil.Factory) error {
-	h := &admissionReviewHandler{f: f}
+	namespace := os.Getenv(config.EnvPodNamespace)
+	h := &admissionReviewHandler{f: f, namespace: namespace}
 	http.HandleFunc("/pods", func(w http.ResponseWriter, r *http.Request) { serve(w, r, newDelegateToV1AdmitHandler(h.admitPods)) })
 	http.HandleFunc("/readyz", func(w http.ResponseWriter, req *http.Request) { w.Write([]byte("ok")) })
-	s := dhcpServer{f: f}
+	s := dhcpServer{f: f, namespace: namespace}
 	http.HandleFunc(config.APIRentIP, s.rentIP)
 	http.HandleFunc(config.APIReleaseIP, s.releaseIP)
-	cert, ok := os.LookupEnv(config.TLSCertKey)
-	if !ok {
-		return fmt.Errorf("can not get %s from env", config.TLSCertKey)
-	}
-	key, ok := os.LookupEnv(config.TLSPrivateKeyKey)
-	if !ok {
-		return fmt.Errorf("can not get %s from env", config.TLSPrivateKeyKey)
-	}
-	pair, err := tls.X509KeyPair([]byte(cert), []byte(key))
-	if err != nil {
-		return err
+	http.HandleFunc(config.APIRenewIP, s.renewIP)
+	var t = &tls.Config{}
+	if namespace != "" {
+		clientset, err := f.KubernetesClientSet()
+		if err != nil {
+			return err
+		}
+		go handler.ReapExpired(context.Background(), f, clientset, namespace, time.Second*30)
+		// webhook owns its certificate, renew it before expiry and hot reload
+		m := newCertManager(clientset, namespace)
+		if err = m.sync(context.Background()); err != nil {
+			return err
+		}
+		go m.Run(context.Background())
+		t.GetCertificate = m.GetCertificate
+		h.certs = m
+	} else {
+		cert, ok := os.LookupEnv(config.TLSCertKey)
+		if !ok {
+			return fmt.Errorf("can not get %s from env", config.TLSCertKey)
+		}
+		key, ok := os.LookupEnv(config.TLSPrivateKeyKey)
+		if !ok {
+			return fmt.Errorf("can not get %s from env", config.TLSPrivateKeyKey)
+		}
+		pair, err := tls.X509KeyPair([]byte(cert), []byte(key))
+		if err != nil {
+			return err
+		}
+		t.Certificates = []tls.Certificate{pair}
 	}
-	t := &tls.Config{Certificates: []tls.Certificate{pair}}
 	server := &http.Server{
 		Addr:      fmt.Sprintf(":%d", 80),
 		TLSConfig: t,
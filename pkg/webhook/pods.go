@@ -1,6 +1,7 @@
 package webhook
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -20,8 +21,8 @@ import (
 
 // only allow pods to pull images from specific registry.
 func (h *admissionReviewHandler) admitPods(ar v1.AdmissionReview) *v1.AdmissionResponse {
-	r, _ := json.Marshal(ar)
-	log.Infof("admitting pods called, req: %v", string(r))
+	// pods carry env values, never log them
+	log.Debugf("admitting pods called, uid: %s, namespace: %s, name: %s", ar.Request.UID, ar.Request.Namespace, ar.Request.Name)
 	podResource := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
 	if ar.Request.Resource != podResource {
 		err := fmt.Errorf("expect resource to be %s but real %s", podResource, ar.Request.Resource)
@@ -35,7 +36,7 @@ func (h *admissionReviewHandler) admitPods(ar v1.AdmissionReview) *v1.AdmissionR
 		pod := corev1.Pod{}
 		deserializer := codecs.UniversalDeserializer()
 		if _, _, err := deserializer.Decode(raw, nil, &pod); err != nil {
-			log.Errorf("can not decode into pod, err: %v, raw: %s", err, string(raw))
+			log.Errorf("can not decode into pod, err: %v", err)
 			return toV1AdmissionResponse(err)
 		}
 
@@ -45,6 +46,23 @@ func (h *admissionReviewHandler) admitPods(ar v1.AdmissionReview) *v1.AdmissionR
 			return toV1AdmissionResponse(err)
 		}
 		var found bool
+		if shouldInject(&pod) {
+			var clientset *kubernetes.Clientset
+			clientset, err = h.f.KubernetesClientSet()
+			if err == nil {
+				err = injectSidecar(context.Background(), clientset, managerNamespace(h.namespace, ar.Request.Namespace), ar.Request.Namespace, &pod)
+			}
+			if err != nil {
+				// rejecting pods breaks workloads, admit it without sidecar, client finds sidecar missing after rollout
+				log.Errorf("can not inject sidecar into pod %s in namespace %s, admit it without sidecar, err: %v", pod.GenerateName, ar.Request.Namespace, err)
+				return &v1.AdmissionResponse{UID: ar.Request.UID, Allowed: true}
+			}
+			log.Infof("inject sidecar into pod %s in namespace: %s", pod.GenerateName, ar.Request.Namespace)
+		}
+		// sidecars injected by webhook or patched by client
+		if trustTrafficManager(&pod, h.certs.CABundle()) {
+			found = true
+		}
 		for i := 0; i < len(pod.Spec.Containers); i++ {
 			if pod.Spec.Containers[i].Name == config.ContainerSidecarVPN {
 				for j := 0; j < len(pod.Spec.Containers[i].Env); j++ {
@@ -57,18 +75,21 @@ func (h *admissionReviewHandler) admitPods(ar v1.AdmissionReview) *v1.AdmissionR
 							log.Errorf("can not get clientset, err: %v", err)
 							return toV1AdmissionResponse(err)
 						}
-						cmi := clientset.CoreV1().ConfigMaps(ar.Request.Namespace)
-						dhcp := handler.NewDHCPManager(cmi, ar.Request.Namespace, &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask})
+						var name string
+						if accessor, errT := meta.Accessor(ar.Request.Object); errT == nil {
+							name = accessor.GetName()
+						}
+						// name of pod created by controller is not generated yet, sidecar will update holder while renewing lease
+						if name == "" {
+							name = pod.GenerateName
+						}
+						dhcp := handler.NewDHCPManager(clientset, managerNamespace(h.namespace, ar.Request.Namespace), &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask})
 						var random *net.IPNet
-						random, err = dhcp.RentIPRandom()
+						random, err = dhcp.RentIPRandom(&handler.IPLeaseHolder{Kind: handler.IPLeaseKindPod, Holder: name, Namespace: ar.Request.Namespace})
 						if err != nil {
 							log.Errorf("rent ip random failed, err: %v", err)
 							return toV1AdmissionResponse(err)
 						}
-						var name string
-						if accessor, errT := meta.Accessor(ar.Request.Object); errT == nil {
-							name = accessor.GetName()
-						}
 
 						log.Infof("rent ip %s for pod %s in namespace: %s", random.String(), name, ar.Request.Namespace)
 						pod.Spec.Containers[i].Env[j].Value = random.String()
@@ -92,14 +113,14 @@ func (h *admissionReviewHandler) admitPods(ar v1.AdmissionReview) *v1.AdmissionR
 			var marshal []byte
 			marshal, err = json.Marshal(patch)
 			if err != nil {
-				log.Errorf("can not marshal json patch %v, err: %v", patch, err)
+				log.Errorf("can not marshal json patch, err: %v", err)
 				return toV1AdmissionResponse(err)
 			}
 			return applyPodPatch(
 				ar,
 				func(pod *corev1.Pod) bool {
 					name, _ := podcmd.FindContainerByName(pod, config.ContainerSidecarVPN)
-					return name != nil
+					return name != nil || shouldInject(pod)
 				},
 				string(marshal),
 			)
@@ -113,7 +134,7 @@ func (h *admissionReviewHandler) admitPods(ar v1.AdmissionReview) *v1.AdmissionR
 		pod := corev1.Pod{}
 		deserializer := codecs.UniversalDeserializer()
 		if _, _, err := deserializer.Decode(raw, nil, &pod); err != nil {
-			log.Errorf("can not decode into pod, err: %v, raw: %s", err, string(raw))
+			log.Errorf("can not decode into pod, err: %v", err)
 			return toV1AdmissionResponse(err)
 		}
 
@@ -129,12 +150,11 @@ func (h *admissionReviewHandler) admitPods(ar v1.AdmissionReview) *v1.AdmissionR
 							log.Errorf("can not get clientset, err: %v", err)
 							return toV1AdmissionResponse(err)
 						}
-						cmi := clientset.CoreV1().ConfigMaps(ar.Request.Namespace)
 						ipnet := &net.IPNet{
 							IP:   ip,
 							Mask: cidr.Mask,
 						}
-						err = handler.NewDHCPManager(cmi, ar.Request.Namespace, &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask}).ReleaseIpToDHCP(ipnet)
+						err = handler.NewDHCPManager(clientset, managerNamespace(h.namespace, ar.Request.Namespace), &net.IPNet{IP: config.RouterIP, Mask: config.CIDR.Mask}).ReleaseIpToDHCP(ipnet)
 						if err != nil {
 							log.Errorf("release ip to dhcp err: %v, ip: %s", err, envVar.Value)
 						} else {
@@ -156,8 +176,7 @@ func (h *admissionReviewHandler) admitPods(ar v1.AdmissionReview) *v1.AdmissionR
 }
 
 func applyPodPatch(ar v1.AdmissionReview, shouldPatchPod func(*corev1.Pod) bool, patch string) *v1.AdmissionResponse {
-	r, _ := json.Marshal(ar)
-	log.Infof("mutating pods called, req: %s", string(r))
+	log.Debugf("mutating pods called, uid: %s, namespace: %s, name: %s", ar.Request.UID, ar.Request.Namespace, ar.Request.Name)
 	podResource := metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"}
 	if ar.Request.Resource != podResource {
 		log.Errorf("expect resource to be %s but real is %s", podResource, ar.Request.Resource)
@@ -168,7 +187,7 @@ func applyPodPatch(ar v1.AdmissionReview, shouldPatchPod func(*corev1.Pod) bool,
 	pod := corev1.Pod{}
 	deserializer := codecs.UniversalDeserializer()
 	if _, _, err := deserializer.Decode(raw, nil, &pod); err != nil {
-		log.Errorf("can not decode request into pod, err: %v, req: %s", err, string(raw))
+		log.Errorf("can not decode request into pod, err: %v", err)
 		return toV1AdmissionResponse(err)
 	}
 	reviewResponse := v1.AdmissionResponse{}